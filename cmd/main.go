@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -20,6 +21,8 @@ import (
 	"github.com/google/uuid"
 	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
 	docker "github.com/intertwin-eu/interlink-docker-plugin/pkg/docker"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/acceleratorledger"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
 	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/dindmanager"
 	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/fpgastrategies"
 	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/gpustrategies"
@@ -175,24 +178,112 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	availableDinds := os.Getenv("AVAILABLEDINDS")
-	if availableDinds == "" {
-		availableDinds = "2"
-	}
-	var dindHandler dindmanager.DindManagerInterface = &dindmanager.DindManager{
-		DindList: []dindmanager.DindSpecs{},
-		Ctx:      ctx,
-	}
-	availableDindsInt, err := strconv.ParseInt(availableDinds, 10, 8)
-	if err != nil {
-		log.G(ctx).Info("\u2705 Error parsing availableDinds")
+	var hostRuntime containerruntime.ContainerRuntime
+	var sandboxes docker.SandboxManager
+	// dindPool is the pool of DIND containers, only used by the docker runtime
+	var dindPool dindmanager.DindManagerInterface
+
+	switch interLinkConfig.ContainerRuntime {
+	case "podman":
+		podmanRuntime := containerruntime.NewPodmanRuntime(interLinkConfig.PodmanSocket)
+		err = podmanRuntime.Ping(ctx)
+		if err != nil {
+			log.G(ctx).Fatal("\u274C Unable to reach the Podman service: ", err)
+		}
+		log.G(ctx).Info("\u2705 Using the Podman runtime")
+
+		hostRuntime = podmanRuntime
+		sandboxes = &docker.PodmanSandboxManager{
+			Runtime: podmanRuntime,
+			Ctx:     ctx,
+		}
+	case "apptainer", "singularity":
+		apptainerRuntime, err := containerruntime.NewApptainerRuntime(interLinkConfig.Commandprefix, interLinkConfig.SingularityPrefix, interLinkConfig.BashPath, filepath.Join(interLinkConfig.DataRootFolder, "apptainer"))
+		if err != nil {
+			log.G(ctx).Fatal(err)
+		}
+		err = apptainerRuntime.Ping(ctx)
+		if err != nil {
+			log.G(ctx).Fatal("\u274C Unable to run Apptainer: ", err)
+		}
+		log.G(ctx).Info("\u2705 Using the Apptainer runtime")
+
+		hostRuntime = apptainerRuntime
+		sandboxes = &docker.ApptainerSandboxManager{
+			Runtime: apptainerRuntime,
+			Ctx:     ctx,
+		}
+	case "", "docker":
+		dockerRuntime, err := containerruntime.NewDockerRuntime("")
+		if err != nil {
+			log.G(ctx).Fatal(err)
+		}
+		hostRuntime = dockerRuntime
+
+		availableDinds := os.Getenv("AVAILABLEDINDS")
+		if availableDinds == "" {
+			availableDinds = "2"
+		}
+
+		availableDindsInt, err := strconv.ParseInt(availableDinds, 10, 8)
+		if err != nil {
+			log.G(ctx).Info("\u2705 Error parsing availableDinds")
+		}
+		executionMode := interLinkConfig.ExecutionMode
+		if executionMode == "" {
+			executionMode = docker.ExecutionModeDind
+		}
+		log.G(ctx).Info("\u2705 Default execution mode is " + executionMode)
+
+		// in direct mode the DIND containers are built only for the PODs asking for them
+		poolSize := 0
+		if executionMode == docker.ExecutionModeDind {
+			poolSize = int(availableDindsInt)
+		}
+
+		var dindHandler dindmanager.DindManagerInterface = &dindmanager.DindManager{
+			DindList:  []dindmanager.DindSpecs{},
+			Runtime:   hostRuntime,
+			Ctx:       ctx,
+			StorePath: filepath.Join(interLinkConfig.DataRootFolder, "dind_registry.json"),
+			PoolSize:  poolSize,
+		}
+
+		err = dindHandler.CleanDindContainers()
+		if err != nil {
+			log.G(ctx).Error("\u274C Error cleaning zombie DIND containers: ", err)
+		}
+		// the DIND containers adopted from a previous run are part of the pool
+		err = dindHandler.FillPool()
+		if err != nil {
+			log.G(ctx).Error("\u274C Error building the DIND containers: ", err)
+		}
+
+		dindPool = dindHandler
+		sandboxes = &docker.ExecutionModeSandboxManager{
+			DefaultMode: executionMode,
+			Managers: map[string]docker.SandboxManager{
+				docker.ExecutionModeDind: &docker.DindSandboxManager{
+					DindManager: dindHandler,
+					Runtime:     hostRuntime,
+					Ctx:         ctx,
+				},
+				docker.ExecutionModeDirect: &docker.DirectSandboxManager{
+					Runtime: hostRuntime,
+					Ctx:     ctx,
+				},
+			},
+		}
+	default:
+		log.G(ctx).Fatal("\u274C Unknown ContainerRuntime " + interLinkConfig.ContainerRuntime + ", supported runtimes are docker, podman and apptainer")
 	}
-	dindHandler.CleanDindContainers()
-	dindHandler.BuildDindContainers(int8(availableDindsInt))
+	defer hostRuntime.Close()
 
 	var gpuManager gpustrategies.GPUManagerInterface = &gpustrategies.GPUManager{
-		GPUSpecsList: []gpustrategies.GPUSpecs{},
-		Ctx:          ctx,
+		GPUSpecsList:    []gpustrategies.GPUSpecs{},
+		Ctx:             ctx,
+		Ledger:          acceleratorledger.Ledger{Path: filepath.Join(interLinkConfig.DataRootFolder, "gpu_specs.json")},
+		IsContainerLive: docker.ContainerLivenessCheck(ctx, sandboxes),
 	}
 
 	err = gpuManager.Init()
@@ -210,14 +301,25 @@ func main() {
 		log.G(ctx).Info("\u274C Check of GPUs failed, error: ", err)
 	}
 
+	wd, err := os.Getwd()
+	if err != nil {
+		log.G(ctx).Fatal(err)
+	}
+	statusCache := docker.NewStatusCache(ctx, sandboxes)
+	logArchive := docker.NewLogArchive(ctx, statusCache, filepath.Join(wd, interLinkConfig.DataRootFolder), interLinkConfig.LogMaxSizeMB, interLinkConfig.LogMaxFiles, time.Duration(interLinkConfig.LogRetentionMins)*time.Minute)
+
 	SidecarAPIs := docker.SidecarHandler{
 		Config:      interLinkConfig,
 		Ctx:         ctx,
 		GpuManager:  gpuManager,
-		DindManager: dindHandler,
+		Sandboxes:   sandboxes,
+		Supervisors: docker.NewPodSupervisors(ctx, logArchive, statusCache),
+		StatusCache: statusCache,
+		Logs:        logArchive,
+		Metrics:     docker.NewMetrics(),
+		Usage:       docker.NewUsageSamples(),
 	}
-
-	log.G(ctx).Info("\u2705 Start cleaning zombie DIND containers")
+	SidecarAPIs.CreateQueue = SidecarAPIs.NewCreateQueue(interLinkConfig.CreateWorkers, interLinkConfig.CreateQueueSize)
 
 	if os.Getenv("ENABLE_TRACING") == "1" {
 		shutdown, err := initProvider(ctx)
@@ -239,8 +341,10 @@ func main() {
 
 	if os.Getenv("FPGAENABLED") == "1" {
 		fpgaManager := &fpgastrategies.FPGAManager{
-			FPGASpecsList: []fpgastrategies.FPGASpecs{},
-			Ctx:           ctx,
+			FPGASpecsList:   []fpgastrategies.FPGASpecs{},
+			Ctx:             ctx,
+			Ledger:          acceleratorledger.Ledger{Path: filepath.Join(interLinkConfig.DataRootFolder, "fpga_specs.json")},
+			IsContainerLive: docker.ContainerLivenessCheck(ctx, sandboxes),
 		}
 		err = fpgaManager.Init()
 		if err != nil {
@@ -252,16 +356,30 @@ func main() {
 			log.G(ctx).Info("\u274C Error during fpga discover: %w", err)
 		}
 
+		err = fpgaManager.Check()
+		if err != nil {
+			log.G(ctx).Info("\u274C Error during fpga check: ", err)
+		}
+
 		SidecarAPIs.FPGAManager = fpgaManager
 	}
 
+	SidecarAPIs.Metrics.Register(&SidecarAPIs, dindPool)
+
 	log.G(ctx).Info(fmt.Sprintf("\u2705 Going to start the sidecar on port %s", interLinkConfig.Sidecarport))
 
+	metrics := SidecarAPIs.Metrics
 	mutex := http.NewServeMux()
-	mutex.HandleFunc("/status", SidecarAPIs.StatusHandler)
-	mutex.HandleFunc("/create", SidecarAPIs.CreateHandler)
-	mutex.HandleFunc("/delete", SidecarAPIs.DeleteHandler)
-	mutex.HandleFunc("/getLogs", SidecarAPIs.GetLogsHandler)
+	mutex.Handle("/status", metrics.Instrument("status", SidecarAPIs.StatusHandler))
+	mutex.Handle("/create", metrics.Instrument("create", SidecarAPIs.CreateHandler))
+	mutex.Handle("/delete", metrics.Instrument("delete", SidecarAPIs.DeleteHandler))
+	mutex.Handle("/getLogs", metrics.Instrument("getLogs", SidecarAPIs.GetLogsHandler))
+	mutex.Handle("/plan", metrics.Instrument("plan", SidecarAPIs.PlanHandler))
+	mutex.Handle("/createQueue", metrics.Instrument("createQueue", SidecarAPIs.CreateQueueHandler))
+	mutex.Handle("/exec", metrics.Instrument("exec", SidecarAPIs.ExecHandler))
+	mutex.Handle("/portForward", metrics.Instrument("portForward", SidecarAPIs.PortForwardHandler))
+	mutex.Handle("/stats", metrics.Instrument("stats", SidecarAPIs.StatsHandler))
+	mutex.Handle("/metrics", metrics.Handler())
 
 	if strings.HasPrefix(interLinkConfig.Socket, "unix://") {
 		// Create a Unix domain socket and listen for incoming connections.
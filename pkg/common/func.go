@@ -60,6 +60,8 @@ func NewInterLinkConfig() (InterLinkConfig, error) {
 			log.G(context.Background()).Error("\u274C Error opening config file, exiting...")
 			return InterLinkConfig{}, err
 		}
+		// a retention set to 0 in the config disables it, so the default is set beforehand
+		InterLinkConfigInst.LogRetentionMins = DefaultLogRetentionMins
 		yaml.Unmarshal(yfile, &InterLinkConfigInst)
 
 		if os.Getenv("INTERLINKURL") != "" {
@@ -90,6 +92,63 @@ func NewInterLinkConfig() (InterLinkConfig, error) {
 			InterLinkConfigInst.PodIP = os.Getenv("POD_IP")
 		}
 
+		if os.Getenv("CONTAINERRUNTIME") != "" {
+			InterLinkConfigInst.ContainerRuntime = os.Getenv("CONTAINERRUNTIME")
+		}
+
+		if os.Getenv("PODMANSOCKET") != "" {
+			InterLinkConfigInst.PodmanSocket = os.Getenv("PODMANSOCKET")
+		}
+
+		if os.Getenv("EXECUTIONMODE") != "" {
+			InterLinkConfigInst.ExecutionMode = os.Getenv("EXECUTIONMODE")
+		}
+
+		if os.Getenv("CREATEWORKERS") != "" {
+			createWorkers, err := strconv.Atoi(os.Getenv("CREATEWORKERS"))
+			if err != nil {
+				fmt.Println("export CREATEWORKERS as an integer")
+				return InterLinkConfig{}, err
+			}
+			InterLinkConfigInst.CreateWorkers = createWorkers
+		}
+
+		if os.Getenv("CREATEQUEUESIZE") != "" {
+			createQueueSize, err := strconv.Atoi(os.Getenv("CREATEQUEUESIZE"))
+			if err != nil {
+				fmt.Println("export CREATEQUEUESIZE as an integer")
+				return InterLinkConfig{}, err
+			}
+			InterLinkConfigInst.CreateQueueSize = createQueueSize
+		}
+
+		if os.Getenv("LOGMAXSIZEMB") != "" {
+			logMaxSizeMB, err := strconv.Atoi(os.Getenv("LOGMAXSIZEMB"))
+			if err != nil {
+				fmt.Println("export LOGMAXSIZEMB as an integer")
+				return InterLinkConfig{}, err
+			}
+			InterLinkConfigInst.LogMaxSizeMB = logMaxSizeMB
+		}
+
+		if os.Getenv("LOGMAXFILES") != "" {
+			logMaxFiles, err := strconv.Atoi(os.Getenv("LOGMAXFILES"))
+			if err != nil {
+				fmt.Println("export LOGMAXFILES as an integer")
+				return InterLinkConfig{}, err
+			}
+			InterLinkConfigInst.LogMaxFiles = logMaxFiles
+		}
+
+		if os.Getenv("LOGRETENTIONMINS") != "" {
+			logRetentionMins, err := strconv.Atoi(os.Getenv("LOGRETENTIONMINS"))
+			if err != nil {
+				fmt.Println("export LOGRETENTIONMINS as an integer")
+				return InterLinkConfig{}, err
+			}
+			InterLinkConfigInst.LogRetentionMins = logRetentionMins
+		}
+
 		if os.Getenv("TSOCKS") != "" {
 			if os.Getenv("TSOCKS") != "true" && os.Getenv("TSOCKS") != "false" {
 				fmt.Println("export TSOCKS as true or false")
@@ -39,6 +39,9 @@ type RetrievedPodData struct {
 	JobScript      string               `json:"jobScript"`
 }
 
+// DefaultLogRetentionMins is the time the logs of a deleted POD are kept, unless configured otherwise
+const DefaultLogRetentionMins = 24 * 60
+
 // InterLinkConfig holds the whole configuration
 type InterLinkConfig struct {
 	VKConfigPath      string `yaml:"VKConfigPath"`
@@ -65,7 +68,16 @@ type InterLinkConfig struct {
 	ErrorsOnlyLogging bool   `yaml:"ErrorsOnlyLogging"`
 	PodIP             string `yaml:"PodIP"`
 	SingularityPrefix string `yaml:"SingularityPrefix"`
-	set               bool
+	ContainerRuntime  string `yaml:"ContainerRuntime"`
+	PodmanSocket      string `yaml:"PodmanSocket"`
+	ExecutionMode     string `yaml:"ExecutionMode"`
+	CreateWorkers     int    `yaml:"CreateWorkers"`
+	CreateQueueSize   int    `yaml:"CreateQueueSize"`
+	LogMaxSizeMB      int    `yaml:"LogMaxSizeMB"`
+	LogMaxFiles       int    `yaml:"LogMaxFiles"`
+	// LogRetentionMins is the time the logs of a deleted POD are kept, DefaultLogRetentionMins if unset. 0 or a negative value removes them with the POD.
+	LogRetentionMins int `yaml:"LogRetentionMins"`
+	set              bool
 }
 
 // ContainerLogOpts is a struct in which it is possible to specify options to retrieve logs from the sidecar
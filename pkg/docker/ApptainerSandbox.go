@@ -0,0 +1,75 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/log"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// ApptainerSandboxManager runs the containers of every POD as Apptainer instances on the host.
+// Apptainer has no notion of pod, the sandbox of a POD is a directory under the state directory of the runtime.
+type ApptainerSandboxManager struct {
+	Runtime *containerruntime.ApptainerRuntime
+	Ctx     context.Context
+}
+
+func (m *ApptainerSandboxManager) sandboxDir(podUID string) string {
+	return filepath.Join(m.Runtime.StateDir, "pods", podUID)
+}
+
+func (m *ApptainerSandboxManager) Create(ctx context.Context, pod v1.Pod) (Sandbox, error) {
+	podUID := string(pod.UID)
+
+	if pod.Annotations["interlink.eu/pod-ip"] != "" {
+		log.G(m.Ctx).Info("\u274C [POD FLOW] The interlink.eu/pod-ip annotation is not supported by Apptainer and is ignored for POD " + podUID)
+	}
+
+	err := os.MkdirAll(m.sandboxDir(podUID), os.ModePerm)
+	if err != nil {
+		return Sandbox{}, err
+	}
+
+	return Sandbox{ID: podUID, PodUID: podUID, Runtime: m.Runtime}, nil
+}
+
+func (m *ApptainerSandboxManager) Get(ctx context.Context, podUID string) (Sandbox, error) {
+	_, err := os.Stat(m.sandboxDir(podUID))
+	if os.IsNotExist(err) {
+		return Sandbox{}, fmt.Errorf("%w: sandbox of POD %s", containerruntime.ErrNotFound, podUID)
+	} else if err != nil {
+		return Sandbox{}, err
+	}
+
+	return Sandbox{ID: podUID, PodUID: podUID, Runtime: m.Runtime}, nil
+}
+
+func (m *ApptainerSandboxManager) Remove(ctx context.Context, podUID string) error {
+	log.G(m.Ctx).Debug("\u2705 [DELETE CALL] Deleting the Apptainer instances of POD " + podUID)
+
+	containers, err := m.Runtime.List(ctx, containerruntime.ListOptions{All: true, Labels: map[string]string{PodUIDLabel: podUID}})
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		err = m.Runtime.Remove(ctx, container.ID, true)
+		if err != nil && !containerruntime.IsNotFound(err) {
+			log.G(m.Ctx).Error("\u274C [DELETE CALL] Error deleting container " + container.Name)
+			return err
+		}
+		log.G(m.Ctx).Info("\u2705 [DELETE CALL] Deleted container " + container.Name)
+	}
+
+	_, err = os.Stat(m.sandboxDir(podUID))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: sandbox of POD %s", containerruntime.ErrNotFound, podUID)
+	}
+
+	return os.RemoveAll(m.sandboxDir(podUID))
+}
@@ -1,7 +1,10 @@
 package docker
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -9,14 +12,11 @@ import (
 	"strings"
 	"time"
 
-	exec "github.com/alexellis/go-execute/pkg/v1"
 	"github.com/containerd/containerd/log"
 	v1 "k8s.io/api/core/v1"
 
-	"errors"
-
 	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
-	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/dindmanager"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
 
 	"path/filepath"
 
@@ -25,7 +25,9 @@ import (
 	trace "go.opentelemetry.io/otel/trace"
 )
 
-func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, w http.ResponseWriter, podIp string) ([]DockerRunStruct, error) {
+// prepareDockerRuns builds the specs of the init containers, in order, followed by the ones of the containers of the POD, and writes the files they mount.
+// In dry run the accelerators are not assigned, the specs refer to the ones available at the moment, and the host paths are not created.
+func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, dryRun bool) ([]DockerRunStruct, error) {
 
 	var dockerRunStructs []DockerRunStruct
 	var gpuArgs string = ""
@@ -42,16 +44,16 @@ func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, w
 				_, err := os.Stat(volume.HostPath.Path)
 				if *volume.HostPath.Type == v1.HostPathDirectory {
 					if os.IsNotExist(err) {
-						HandleErrorAndRemoveData(h, w, "Host path directory does not exist", err, podNamespace, podUID)
-						return dockerRunStructs, errors.New("Host path directory does not exist")
+						return nil, fmt.Errorf("Host path directory does not exist: %w", err)
 					}
 					pathsOfVolumes[volume.Name] = volume.HostPath.Path
 				} else if *volume.HostPath.Type == v1.HostPathDirectoryOrCreate {
-					if os.IsNotExist(err) {
+					if os.IsNotExist(err) && dryRun {
+						pathsOfVolumes[volume.Name] = volume.HostPath.Path
+					} else if os.IsNotExist(err) {
 						err = os.MkdirAll(volume.HostPath.Path, os.ModePerm)
 						if err != nil {
-							HandleErrorAndRemoveData(h, w, "An error occurred during mkdir of host path directory", err, podNamespace, podUID)
-							return dockerRunStructs, errors.New("An error occurred during mkdir of host path directory")
+							return nil, fmt.Errorf("An error occurred during mkdir of host path directory: %w", err)
 						} else {
 							pathsOfVolumes[volume.Name] = volume.HostPath.Path
 						}
@@ -71,13 +73,11 @@ func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, w
 		}
 	}
 
-	allContainers := map[string][]v1.Container{
-		"initContainers": podData.Pod.Spec.InitContainers,
-		"containers":     podData.Pod.Spec.Containers,
-	}
+	// the init containers come first, in the order in which they have to be executed
+	allContainers := [][]v1.Container{podData.Pod.Spec.InitContainers, podData.Pod.Spec.Containers}
 
 	for containerType, containers := range allContainers {
-		isInitContainer := containerType == "initContainers"
+		isInitContainer := containerType == 0
 
 		for _, container := range containers {
 
@@ -85,7 +85,8 @@ func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, w
 
 			var isGpuRequested bool = false
 			var isFPGARequested bool = false
-			var additionalGpuArgs []string
+			var gpuIndexes string
+			var fpgaDevices []string
 
 			if val, ok := container.Resources.Limits["nvidia.com/gpu"]; ok {
 
@@ -101,17 +102,17 @@ func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, w
 					isGpuRequested = true
 
 					numGpusRequestedInt := int(numGpusRequested)
-					_, err := h.GpuManager.GetAvailableGPUs(numGpusRequestedInt)
+					gpuSpecs, err := h.GpuManager.GetAvailableGPUs(numGpusRequestedInt)
 
 					if err != nil {
-						HandleErrorAndRemoveData(h, w, "An error occurred during request of get available GPUs", err, podNamespace, podUID)
-						return dockerRunStructs, errors.New("An error occurred during request of get available GPUs")
+						return nil, fmt.Errorf("An error occurred during request of get available GPUs: %w", err)
 					}
 
-					gpuSpecs, err := h.GpuManager.GetAndAssignAvailableGPUs(numGpusRequestedInt, containerName)
-					if err != nil {
-						HandleErrorAndRemoveData(h, w, "An error occurred during request of get and assign of an available GPU", err, podNamespace, podUID)
-						return dockerRunStructs, errors.New("An error occurred during request of get and assign of an available GPU")
+					if !dryRun {
+						gpuSpecs, err = h.GpuManager.GetAndAssignAvailableGPUs(numGpusRequestedInt, containerName)
+						if err != nil {
+							return nil, fmt.Errorf("An error occurred during request of get and assign of an available GPU: %w", err)
+						}
 					}
 
 					var gpuUUIDs string = ""
@@ -123,7 +124,7 @@ func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, w
 						}
 					}
 
-					additionalGpuArgs = append(additionalGpuArgs, "--runtime=nvidia -e NVIDIA_VISIBLE_DEVICES="+gpuUUIDs)
+					gpuIndexes = gpuUUIDs
 					gpuArgs = "--runtime=nvidia -e NVIDIA_VISIBLE_DEVICES=" + gpuUUIDs
 				}
 
@@ -139,46 +140,40 @@ func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, w
 					log.G(h.Ctx).Info("\u2705 Container " + containerName + " is requesting " + strconv.Itoa(int(numFPGAsRequested)) + " FPGA(s)")
 
 					numFPGAsRequestedInt := int(numFPGAsRequested)
-					_, err := h.FPGAManager.GetAvailableFPGAs(numFPGAsRequestedInt)
+					assignedFPGAs, err := h.FPGAManager.GetAvailableFPGAs(numFPGAsRequestedInt)
 					if err != nil {
-						HandleErrorAndRemoveData(h, w, "An error occurred during the request of available FPGAs", err, podNamespace, podUID)
-						return dockerRunStructs, errors.New("An error occurred during the request of available FPGAs")
+						return nil, fmt.Errorf("An error occurred during the request of available FPGAs: %w", err)
 					}
-					assignedFPGAs, err := h.FPGAManager.GetAndAssignAvailableFPGAs(numFPGAsRequestedInt, containerName)
-					if err != nil {
-						HandleErrorAndRemoveData(h, w, "An error occurred during request of get and assign of an available GPU", err, podNamespace, podUID)
-						return dockerRunStructs, errors.New("An error occurred during request of get and assign of an available GPU")
+					if !dryRun {
+						assignedFPGAs, err = h.FPGAManager.GetAndAssignAvailableFPGAs(numFPGAsRequestedInt, containerName)
+						if err != nil {
+							return nil, fmt.Errorf("An error occurred during request of get and assign of an available FPGA: %w", err)
+						}
 					}
 					for _, fpgaSpec := range assignedFPGAs {
 						fpgaArgs += " --device=" + fpgaSpec.DeviceToMount + ":" + fpgaSpec.DeviceToMount
+						fpgaDevices = append(fpgaDevices, fpgaSpec.DeviceToMount)
 					}
 				}
 			}
 
-			var envVars string
+			spec := containerruntime.ContainerSpec{
+				Name:  containerName,
+				Image: container.Image,
+				User:  "root",
+			}
+
 			for _, envVar := range container.Env {
-				if envVar.Value != "" {
-					value := envVar.Value
-
-					// If the value starts with a double quote followed by a bracket,
-					// remove the outer double quotes before wrapping.
-					if strings.HasPrefix(value, "\"[") && strings.HasSuffix(value, "]\"") {
-						// Remove the first and last character.
-						value = value[1 : len(value)-1]
-					}
+				value := envVar.Value
 
-					// Now, if the value looks like a list (starts with '['), wrap it with single quotes.
-					if strings.HasPrefix(value, "[") {
-						envVars += " -e " + envVar.Name + "='" + value + "'"
-					} else if strings.Contains(value, " ") {
-						// For values containing spaces, wrap in double quotes.
-						envVars += " -e " + envVar.Name + "=\"" + value + "\""
-					} else {
-						envVars += " -e " + envVar.Name + "=" + value
-					}
-				} else {
-					envVars += " -e " + envVar.Name
+				// If the value starts with a double quote followed by a bracket,
+				// remove the outer double quotes.
+				if strings.HasPrefix(value, "\"[") && strings.HasSuffix(value, "]\"") {
+					// Remove the first and last character.
+					value = value[1 : len(value)-1]
 				}
+
+				spec.Env = append(spec.Env, envVar.Name+"="+value)
 			}
 
 			for _, volumeMount := range container.VolumeMounts {
@@ -187,119 +182,70 @@ func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, w
 					if _, ok := pathsOfVolumes[volumeMount.Name]; !ok {
 						continue
 					}
+					mount := containerruntime.MountSpec{Source: pathsOfVolumes[volumeMount.Name], Target: volumeMount.MountPath}
 					if volumeMount.ReadOnly {
-						envVars += " -v " + pathsOfVolumes[volumeMount.Name] + ":" + volumeMount.MountPath + ":ro"
-					} else {
-						if volumeMount.MountPropagation != nil && *volumeMount.MountPropagation == v1.MountPropagationBidirectional {
-							envVars += " -v " + pathsOfVolumes[volumeMount.Name] + ":" + volumeMount.MountPath + ":shared"
-						} else {
-							envVars += " -v " + pathsOfVolumes[volumeMount.Name] + ":" + volumeMount.MountPath
-						}
+						mount.ReadOnly = true
+					} else if volumeMount.MountPropagation != nil && *volumeMount.MountPropagation == v1.MountPropagationBidirectional {
+						mount.Propagation = "shared"
 					}
+					spec.Mounts = append(spec.Mounts, mount)
 				}
 			}
 
-			//envVars += " --network=host"
-			cmd := []string{"run", "--user", "root", "-d", "--name", containerName}
-
-			cmd = append(cmd, envVars)
-
 			if container.SecurityContext != nil && container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged {
-				cmd = append(cmd, "--privileged")
+				spec.Privileged = true
 			}
 
 			if isGpuRequested {
-				cmd = append(cmd, additionalGpuArgs...)
+				spec.Runtime = "nvidia"
+				spec.Env = append(spec.Env, "NVIDIA_VISIBLE_DEVICES="+gpuIndexes)
 			}
 
 			if isFPGARequested {
-				cmd = append(cmd, fpgaArgs)
+				spec.Devices = append(spec.Devices, fpgaDevices...)
 			}
 
-			cmd = append(cmd, "-p", "8888:8888")
-
-			// if podIp != "" {
-			// 	// add --ip flag to the docker run command
-			// 	cmd = append(cmd, "--ip", podIp)
-
-			// 	// add --net vk0
-			// 	cmd = append(cmd, "--net", "vk0")
-
-			// 	// --dns 10.96.0.10
-			// 	cmd = append(cmd, "--dns", "10.96.0.10")
-
-			// 	// add NET_ADMIN  capability
-			// 	cmd = append(cmd, "--cap-add", "NET_ADMIN")
-			// }
-
-			var additionalPortArgs []string
-
 			for _, port := range container.Ports {
 				if port.HostPort != 0 {
-					additionalPortArgs = append(additionalPortArgs, "-p", strconv.Itoa(int(port.HostPort))+":"+strconv.Itoa(int(port.ContainerPort)))
+					spec.Ports = append(spec.Ports, containerruntime.PortSpec{HostPort: port.HostPort, ContainerPort: port.ContainerPort, Protocol: string(port.Protocol)})
 				}
 			}
 
-			cmd = append(cmd, additionalPortArgs...)
-
 			mounts, err := prepareMounts(h.Ctx, h.Config, podData, container)
 			if err != nil {
-				HandleErrorAndRemoveData(h, w, "An error occurred during preparing mounts for the POD", err, podNamespace, podUID)
-				return dockerRunStructs, errors.New("An error occurred during preparing mounts for the POD")
+				return nil, fmt.Errorf("An error occurred during preparing mounts for the POD: %w", err)
 			}
 
-			cmd = append(cmd, mounts)
-
-			memoryLimitsArray := []string{}
-			cpuLimitsArray := []string{}
+			spec.Mounts = append(spec.Mounts, mounts...)
 
 			if container.Resources.Limits.Memory().Value() != 0 {
-				memoryLimitsArray = append(memoryLimitsArray, "--memory", strconv.Itoa(int(container.Resources.Limits.Memory().Value()))+"b")
+				spec.MemoryBytes = container.Resources.Limits.Memory().Value()
 			}
-			if container.Resources.Limits.Cpu().Value() != 0 {
-				cpuLimitsArray = append(cpuLimitsArray, "--cpus", strconv.FormatFloat(float64(container.Resources.Limits.Cpu().Value()), 'f', -1, 64))
+			if container.Resources.Limits.Cpu().MilliValue() != 0 {
+				spec.NanoCPUs = container.Resources.Limits.Cpu().MilliValue() * 1000000
 			}
 
-			cmd = append(cmd, memoryLimitsArray...)
-			cmd = append(cmd, cpuLimitsArray...)
-
 			containerCommands := []string{}
 			containerArgs := []string{}
-			mountFileCommand := []string{}
+			mountFileCommand := []containerruntime.MountSpec{}
 
 			// if container has a command and args, call parseContainerCommandAndReturnArgs
 			if len(container.Command) > 0 || len(container.Args) > 0 {
 				mountFileCommand, containerCommands, containerArgs, err = parseContainerCommandAndReturnArgs(h.Ctx, h.Config, podUID, podNamespace, container)
 				if err != nil {
-					HandleErrorAndRemoveData(h, w, "An error occurred during the parse of the container commands and arguments", err, podNamespace, podUID)
-					return dockerRunStructs, errors.New("An error occurred during the parse of the container commands and arguments")
-				}
-				cmd = append(cmd, mountFileCommand...)
-			}
-
-			cmd = append(cmd, container.Image)
-			cmd = append(cmd, containerCommands...)
-			cmd = append(cmd, containerArgs...)
-
-			dockerOptions := ""
-
-			if dockerFlags, ok := podData.Pod.ObjectMeta.Annotations["docker-options.vk.io/flags"]; ok {
-				parsedDockerOptions := strings.Split(dockerFlags, " ")
-				for _, option := range parsedDockerOptions {
-					dockerOptions += " " + option
+					return nil, fmt.Errorf("An error occurred during the parse of the container commands and arguments: %w", err)
 				}
+				spec.Mounts = append(spec.Mounts, mountFileCommand...)
 			}
 
-			shell := exec.ExecTask{
-				Command: "docker" + dockerOptions,
-				Args:    cmd,
-				Shell:   true,
-			}
+			spec.Command = append(spec.Command, containerCommands...)
+			spec.Command = append(spec.Command, containerArgs...)
 
 			dockerRunStructs = append(dockerRunStructs, DockerRunStruct{
 				Name:            containerName,
-				Command:         "docker " + strings.Join(shell.Args, " "),
+				Spec:            spec,
 				IsInitContainer: isInitContainer,
+				IsSidecar:       isInitContainer && isSidecar(container),
 				GpuArgs:         gpuArgs,
 				FpgaArgs:        fpgaArgs,
 			})
@@ -309,8 +255,17 @@ func (h *SidecarHandler) prepareDockerRuns(podData commonIL.RetrievedPodData, w
 	return dockerRunStructs, nil
 }
 
+// CreateHandler admits the PODs of the request to the create queue, which creates each of them in its own sandbox in background.
+// The response is the list of the admitted PODs, their creation is reported by their status. If the PODs do not fit in the queue, none of them is admitted
+// and the request is answered with 429 Too Many Requests.
 func (h *SidecarHandler) CreateHandler(w http.ResponseWriter, r *http.Request) {
 
+	// a dry run returns the execution plan of the PODs instead of creating them
+	if r.URL.Query().Get("dryRun") == "true" {
+		h.PlanHandler(w, r)
+		return
+	}
+
 	log.G(h.Ctx).Info("\u23F3 [CREATE CALL] Received create call from InterLink ")
 
 	start := time.Now().UnixMicro()
@@ -318,38 +273,8 @@ func (h *SidecarHandler) CreateHandler(w http.ResponseWriter, r *http.Request) {
 	_, span := tracer.Start(h.Ctx, "Create", trace.WithAttributes(
 		attribute.Int64("start.timestamp", start),
 	))
+	defer span.End()
 
-	// create bool variable to set if a new dind container has to be created
-	newDindContainerCreated := false
-
-	// get a dind container ID from dind manager of the sidecard handler
-	dindContainerID, err := h.DindManager.GetAvailableDind()
-	if err != nil {
-
-		log.G(h.Ctx).Info("\u2705 [POD FLOW] No available DIND container found, creating a new one")
-
-		h.DindManager.BuildDindContainers(1)
-		dindContainerID, err = h.DindManager.GetAvailableDind()
-		if err != nil {
-			HandleErrorAndRemoveData(h, w, "During creation of new DIND container, an error occurred during the request of get available DIND container", err, "", "")
-			return
-		}
-		newDindContainerCreated = true
-	}
-
-	// remove the dind container from the list of available dind containers
-	err = h.DindManager.SetDindUnavailable(dindContainerID)
-	if err != nil {
-		HandleErrorAndRemoveData(h, w, "An error occurred during the removal of the DIND container from the list of available DIND containers", err, "", "")
-		return
-	}
-
-	if !newDindContainerCreated {
-		// create a new dind container in background
-		go h.DindManager.BuildDindContainers(1)
-	}
-
-	//var execReturn exec.ExecResult
 	statusCode := http.StatusOK
 
 	bodyBytes, err := io.ReadAll(r.Body)
@@ -374,252 +299,156 @@ func (h *SidecarHandler) CreateHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.G(h.Ctx).Info("\u2705 [POD FLOW] Request data unmarshalled successfully and current working directory detected")
 
+	var podUIDs []string
 	for _, data := range req {
+		podUIDs = append(podUIDs, string(data.Pod.UID))
+	}
+	span.SetAttributes(attribute.StringSlice("podUIDs", podUIDs))
 
-		podUID := string(data.Pod.UID)
-		podNamespace := string(data.Pod.Namespace)
-
-		podDirectoryPath := filepath.Join(wd, h.Config.DataRootFolder+"/"+podNamespace+"-"+podUID)
-
-		podIpAddress := ""
-		annotations := make([]string, 0, len(data.Pod.Annotations))
-		for key, value := range data.Pod.Annotations {
-			annotations = append(annotations, key+"="+value)
-
-			// if the key is interlink.eu/pod-ip and the value is not empty, set the pod IP to the DIND container
-			if key == "interlink.eu/pod-ip" && value != "" {
-				podIpAddress = value
-			}
-		}
-		log.G(h.Ctx).Info("\u2705 [POD FLOW] Pod Annotations are: " + strings.Join(annotations, ", "))
-
-		log.G(h.Ctx).Info("\u2705 [POD FLOW] Pod IP Address is: " + podIpAddress)
-
-		// if podIpAddress is != "" then exec the command docker network connect vk0 --ip podIpAddress <container_name>
-		if podIpAddress != "" {
-			shell := exec.ExecTask{
-				Command: "docker",
-				Args:    []string{"network", "connect", "vk0", "--ip", podIpAddress, dindContainerID},
-				Shell:   true,
-			}
-
-			_, err = shell.Execute()
-			if err != nil {
-				HandleErrorAndRemoveData(h, w, "An error occurred during the connection of the DIND container to the vk0 network", err, "", "")
-				return
-			}
-
-			routeIP := strings.Split(podIpAddress, ".")
-			routeIP[3] = "251"
-			route := strings.Join(routeIP, ".")
-
-			log.G(h.Ctx).Info("\u2705 [POD FLOW] Route IP is: " + route)
-
-			// inside the dind container, add the route to the pod IP ip route add 10.0.0.0/8  via 10.244.12.251
-			shell = exec.ExecTask{
-				Command: "docker",
-				Args:    []string{"exec", dindContainerID, "ip", "route", "add", "10.0.0.0/8", "via", route},
-				Shell:   true,
-			}
+	err = h.CreateQueue.Enqueue(req, wd)
+	if errors.Is(err, ErrCreateQueueFull) {
+		stats := h.CreateQueue.Stats()
+		log.G(h.Ctx).Error("\u274C [CREATE CALL] Unable to admit " + strconv.Itoa(len(req)) + " PODs, " + strconv.Itoa(stats.Depth) + " of " + strconv.Itoa(stats.Capacity) + " PODs are already waiting to be created")
 
-			_, err = shell.Execute()
-			if err != nil {
-				HandleErrorAndRemoveData(h, w, "An error occurred during the addition of the route to the pod IP", err, "", "")
-				return
-			}
+		statusCode = http.StatusTooManyRequests
+		span.SetAttributes(attribute.String("error", err.Error()))
+		w.Header().Set("Retry-After", strconv.Itoa(int(createRetryAfter.Seconds())))
+		w.WriteHeader(statusCode)
+		w.Write([]byte("Too many PODs are waiting to be created, retry later"))
+		commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+		return
+	}
 
-			// exec the command echo "nameserver 10.96.0.10" > /etc/resolv.conf
-			// shell = exec.ExecTask{
-			// 	Command: "docker",
-			// 	Args:    []string{"exec", dindContainerID, "-u", "0", "sh", "-c", "echo 'nameserver 10.96.0.10' > /etc/resolv.conf"},
-			// 	Shell:   true,
-			// }
+	log.G(h.Ctx).Info("\u2705 [CREATE CALL] " + strconv.Itoa(len(req)) + " PODs admitted to the create queue")
 
-			// log.G(h.Ctx).Info("\u2705 [POD FLOW] Executing command to add nameserver to resolv.conf file")
-			// log.G(h.Ctx).Info("\u2705 [POD FLOW] Command: " + "docker " + strings.Join(shell.Args, " "))
+	createResponses := []CreateStruct{}
+	for _, data := range req {
+		createResponses = append(createResponses, CreateStruct{PodUID: string(data.Pod.UID)})
+	}
+	span.SetAttributes(attribute.Int("queue.depth", h.CreateQueue.Stats().Depth))
 
-			// _, err = shell.Execute()
-			// if err != nil {
-			// 	HandleErrorAndRemoveData(h, w, "An error occurred during the addition of the nameserver to the resolv.conf file", err, "", "")
-			// 	return
-			// }
+	createResponsesBytes, err := json.Marshal(createResponses)
+	if err != nil {
+		HandleErrorAndRemoveData(h, w, "An error occurred during the json marshal of the returned JIDs", err, "", "")
+		return
+	}
 
-			// log.G(h.Ctx).Info("\u2705 [POD FLOW] Nameserver added to resolv.conf file")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(createResponsesBytes)
 
-		}
+	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+}
 
-		// if the podDirectoryPath does not exist, create it
-		if _, err := os.Stat(podDirectoryPath); os.IsNotExist(err) {
-			err = os.MkdirAll(podDirectoryPath, os.ModePerm)
-			if err != nil {
-				HandleErrorAndRemoveData(h, w, "An error occurred during the creation of the pod directory", err, "", "")
-				return
-			}
-		}
+// NewCreateQueue returns the queue creating the PODs of the create requests in background, with the given number of workers
+func (h *SidecarHandler) NewCreateQueue(workers int, size int) *CreateQueue {
+	return NewCreateQueue(h.Ctx, workers, size, func(data commonIL.RetrievedPodData, wd string, enqueued time.Time) error {
+		h.Metrics.ObserveCreateStage(createStageQueue, enqueued)
 
-		// call prepareDockerRuns to get the DockerRunStruct array
-		dockerRunStructs, err := h.prepareDockerRuns(data, w, podIpAddress)
+		createResponse, err := h.createPod(data, wd)
 		if err != nil {
-			HandleErrorAndRemoveData(h, w, "An error occurred during preparing of docker run commmands", err, "", "")
-			return
+			log.G(h.Ctx).Error("\u274C [CREATE CALL] Error creating POD " + string(data.Pod.UID) + ": " + err.Error())
+			return err
 		}
+		log.G(h.Ctx).Info("\u2705 [CREATE CALL] POD " + createResponse.PodUID + " created in sandbox " + createResponse.PodJID)
+		return nil
+	})
+}
 
-		log.G(h.Ctx).Info("\u2705 [POD FLOW] Docker run commands prepared successfully")
+// CreateQueueHandler returns the occupation of the create queue
+func (h *SidecarHandler) CreateQueueHandler(w http.ResponseWriter, r *http.Request) {
+	bodyBytes, err := json.Marshal(h.CreateQueue.Stats())
+	if err != nil {
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-		// from dockerRunStructs, create two arrays: one for initContainers and one for containers
-		var initContainers []DockerRunStruct
-		var containers []DockerRunStruct
-		//var gpuArgs string
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bodyBytes)
+}
 
-		for _, dockerRunStruct := range dockerRunStructs {
-			if dockerRunStruct.IsInitContainer {
-				initContainers = append(initContainers, dockerRunStruct)
-			} else {
-				containers = append(containers, dockerRunStruct)
-			}
-		}
+// createPod prepares the containers of the POD, creates its sandbox and hands the containers over to the supervisor of the POD.
+// If the POD cannot be created, the accelerators, the files and the sandbox allocated for it are released.
+func (h *SidecarHandler) createPod(data commonIL.RetrievedPodData, wd string) (CreateStruct, error) {
 
-		// set the podUID to the dind container
-		err = h.DindManager.SetPodUIDToDind(dindContainerID, podUID)
-		if err != nil {
-			HandleErrorAndRemoveData(h, w, "An error occurred during the setting of the pod UID to the DIND container", err, "", "")
-			return
-		}
+	podUID := string(data.Pod.UID)
+	podNamespace := string(data.Pod.Namespace)
+	createResponse := CreateStruct{PodUID: podUID}
 
-		// run the docker command to rename the container to the pod UID
-		shell := exec.ExecTask{
-			Command: "docker",
-			Args:    []string{"rename", dindContainerID, string(data.Pod.UID) + "_dind"},
-			Shell:   true,
-		}
+	podDirectoryPath := filepath.Join(wd, h.Config.DataRootFolder+"/"+podNamespace+"-"+podUID)
 
-		_, err = shell.Execute()
-		if err != nil {
-			HandleErrorAndRemoveData(h, w, "An error occurred during the rename of the DIND container", err, "", "")
-			return
-		}
+	annotations := make([]string, 0, len(data.Pod.Annotations))
+	for key, value := range data.Pod.Annotations {
+		annotations = append(annotations, key+"="+value)
+	}
+	log.G(h.Ctx).Info("\u2705 [POD FLOW] Pod Annotations are: " + strings.Join(annotations, ", "))
 
-		createResponse := CreateStruct{PodUID: string(data.Pod.UID), PodJID: dindContainerID}
-		createResponseBytes, err := json.Marshal(createResponse)
+	// if the podDirectoryPath does not exist, create it
+	if _, err := os.Stat(podDirectoryPath); os.IsNotExist(err) {
+		err = os.MkdirAll(podDirectoryPath, os.ModePerm)
 		if err != nil {
-			statusCode = http.StatusInternalServerError
-			HandleErrorAndRemoveData(h, w, "An error occurred during the json marshal of the returned JID", err, "", "")
-			return
+			return createResponse, fmt.Errorf("An error occurred during the creation of the pod directory: %w", err)
 		}
+	}
+
+	// call prepareDockerRuns to get the DockerRunStruct array
+	prepareStart := time.Now()
+	dockerRunStructs, err := h.prepareDockerRuns(data, false)
+	h.Metrics.ObserveCreateStage(createStagePrepare, prepareStart)
+	if err != nil {
+		h.removePodData(data.Pod, podDirectoryPath)
+		return createResponse, fmt.Errorf("An error occurred during preparing of docker run commmands: %w", err)
+	}
 
-		span.SetAttributes(attribute.String("podUID", string(data.Pod.UID)))
-		span.SetAttributes(attribute.String("podJID", dindContainerID))
-		span.SetAttributes(attribute.String("podNamespace", string(data.Pod.Namespace)))
-		span.SetAttributes(attribute.String("podName", string(data.Pod.Name)))
+	log.G(h.Ctx).Info("\u2705 [POD FLOW] Docker run commands prepared successfully")
 
-		w.WriteHeader(statusCode)
+	// from dockerRunStructs, create two arrays: one for initContainers and one for containers
+	var initContainers []DockerRunStruct
+	var containers []DockerRunStruct
 
-		if statusCode != http.StatusOK {
-			w.Write([]byte("Some errors occurred while creating containers. Check Docker Sidecar's logs"))
+	for _, dockerRunStruct := range dockerRunStructs {
+		if dockerRunStruct.IsInitContainer {
+			initContainers = append(initContainers, dockerRunStruct)
 		} else {
-			w.Write(createResponseBytes)
-		}
-
-		if err != nil {
-			span.SetAttributes(attribute.String("error", err.Error()))
+			containers = append(containers, dockerRunStruct)
 		}
-		commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
-		span.End()
-
-		go func() {
-
-			if len(initContainers) > 0 {
-
-				log.G(h.Ctx).Info("\u2705 [POD FLOW] Start creating init containers")
-
-				// Create a list to hold the docker run commands
-				var initContainerCommands []string
-
-				// Build the docker run commands for each init container
-				for _, initContainer := range initContainers {
-					initContainerCommands = append(initContainerCommands, initContainer.Command+"\n")
-				}
-
-				// Log the init container commands
-				log.G(h.Ctx).Info("\u2705 [POD FLOW] Init containers command list: " + strings.Join(initContainerCommands, ", "))
-
-				// Run init containers sequentially
-				for _, initContainer := range initContainers {
-					log.G(h.Ctx).Info("\u2705 [POD FLOW] Executing init container: " + initContainer.Name)
-
-					// Execute the docker command for the current init container
-					shell := exec.ExecTask{
-						Command: "docker",
-						Args:    []string{"exec", string(data.Pod.UID) + "_dind", "/bin/sh", "-c", initContainer.Command},
-					}
-
-					_, err := shell.Execute()
-					if err != nil {
-						HandleErrorAndRemoveData(h, w, "An error occurred during the exec of the init container command", err, "", "")
-						return
-					}
-
-					// Poll the container status until it exits
-					for {
-						shell = exec.ExecTask{
-							Command: "docker",
-							Args:    []string{"exec", string(data.Pod.UID) + "_dind", "docker", "inspect", "--format='{{.State.Status}}'", initContainer.Name},
-						}
-
-						statusReturn, err := shell.Execute()
-						if err != nil {
-							HandleErrorAndRemoveData(h, w, "An error occurred during inspect of init container", err, "", "")
-							return
-						}
-
-						status := strings.Trim(statusReturn.Stdout, "'\n")
-						if status == "exited" {
-							log.G(h.Ctx).Info("\u2705 [POD FLOW] Init container " + initContainer.Name + " has completed")
-							break
-						} else {
-							time.Sleep(1 * time.Second) // Wait for a second before polling again
-						}
-					}
-				}
-
-				log.G(h.Ctx).Info("\u2705 [POD FLOW] All init containers created and executed successfully")
-			}
-
-			// create a file called containers_command.sh and write the containers commands to it, use WriteFile function
-			containersCommand := "#!/bin/sh\n"
+	}
 
-			// if podIpAddress is != "" , add the echo "nameserver 10.0 " > /etc/resolv.conf command to the containers_command.sh
-			if podIpAddress != "" {
-				containersCommand += "echo 'nameserver 10.96.0.10' > /etc/resolv.conf" + "\n"
-			}
+	sandboxStart := time.Now()
+	sandbox, err := h.Sandboxes.Create(h.Ctx, data.Pod)
+	h.Metrics.ObserveCreateStage(createStageSandbox, sandboxStart)
+	if err != nil {
+		h.removePodData(data.Pod, podDirectoryPath)
+		return createResponse, fmt.Errorf("An error occurred during the creation of the sandbox of the pod: %w", err)
+	}
 
-			for _, container := range containers {
-				containersCommand += container.Command + "\n"
-			}
-			err = os.WriteFile(podDirectoryPath+"/containers_command.sh", []byte(containersCommand), 0644)
-			if err != nil {
-				HandleErrorAndRemoveData(h, w, "An error occurred during the creation of the container commands script.", err, "", "")
-				return
-			}
+	createResponse.PodJID = sandbox.ID
 
-			log.G(h.Ctx).Info("\u2705 [POD FLOW] Containers commands written to the script file")
+	h.Supervisors.Start(sandbox, data.Pod, initContainers, containers)
 
-			shell = exec.ExecTask{
-				Command: "docker",
-				Args:    []string{"exec", string(data.Pod.UID) + "_dind", "/bin/sh", podDirectoryPath + "/containers_command.sh"},
-			}
+	return createResponse, nil
+}
 
-			_, err = shell.Execute()
-			if err != nil {
-				HandleErrorAndRemoveData(h, w, "An error occurred during the execution of the container command script", err, "", "")
-				return
-			}
+// runContainer creates and starts a container of the POD inside its sandbox
+func runContainer(ctx context.Context, sandbox Sandbox, dockerRunStruct DockerRunStruct) error {
+	containerID, err := sandbox.Runtime.Create(ctx, sandbox.prepareContainerSpec(dockerRunStruct.Spec))
+	if err != nil {
+		return err
+	}
+	return sandbox.Runtime.Start(ctx, containerID)
+}
 
-			log.G(h.Ctx).Info("\u2705 [POD FLOW] Containers created successfully")
-		}()
+// removePodData releases the accelerators, the files and the sandbox of a POD whose creation failed
+func (h *SidecarHandler) removePodData(pod v1.Pod, podDirectoryPath string) {
+	h.releaseAccelerators(pod)
+	os.RemoveAll(podDirectoryPath)
 
+	err := h.Sandboxes.Remove(h.Ctx, string(pod.UID))
+	if err != nil && !containerruntime.IsNotFound(err) {
+		log.G(h.Ctx).Error("\u274C [CREATE CALL] Error removing the sandbox of the pod " + string(pod.UID))
 	}
-
+	h.StatusCache.Forget(string(pod.UID))
 }
 
 func HandleErrorAndRemoveData(h *SidecarHandler, w http.ResponseWriter, s string, err error, podNamespace string, podUID string) {
@@ -631,34 +460,10 @@ func HandleErrorAndRemoveData(h *SidecarHandler, w http.ResponseWriter, s string
 	if podNamespace != "" && podUID != "" {
 		os.RemoveAll(h.Config.DataRootFolder + podNamespace + "-" + podUID)
 	}
-	dindSpec := dindmanager.DindSpecs{}
-	dindSpec, err = h.DindManager.GetDindFromPodUID(podUID)
-
-	if err != nil {
-		log.G(h.Ctx).Error("\u274C [CREATE CALL] Error retrieving DindSpecs, maybe the Dind container has already been deleted")
-	} else {
-		log.G(h.Ctx).Info("\u2705 [CREATE CALL] Retrieved DindSpecs: " + dindSpec.DindID + " " + dindSpec.PodUID + " " + dindSpec.DindNetworkID + " ")
-
-		// log the retrieved dindSpec
-		log.G(h.Ctx).Info("\u2705 [CREATE CALL] Retrieved DindSpecs: " + dindSpec.DindID + " " + dindSpec.PodUID + " " + dindSpec.DindNetworkID + " ")
-
-		cmd := []string{"network", "rm", dindSpec.DindNetworkID}
-		shell := exec.ExecTask{
-			Command: "docker",
-			Args:    cmd,
-			Shell:   true,
-		}
-		execReturn, _ := shell.Execute()
-		execReturn.Stdout = strings.ReplaceAll(execReturn.Stdout, "\n", "")
-		if execReturn.Stderr != "" {
-			log.G(h.Ctx).Error("\u274C [CREATE CALL] Error deleting network " + dindSpec.DindNetworkID)
-		} else {
-			log.G(h.Ctx).Info("\u2705 [CREATE CALL] Deleted network " + dindSpec.DindNetworkID)
-		}
-		// set the dind available again
-		err = h.DindManager.RemoveDindFromList(dindSpec.PodUID)
-		if err != nil {
-			log.G(h.Ctx).Error("\u274C [CREATE CALL] Error setting DIND container available")
+	if podUID != "" {
+		err = h.Sandboxes.Remove(h.Ctx, podUID)
+		if err != nil && !containerruntime.IsNotFound(err) {
+			log.G(h.Ctx).Error("\u274C [CREATE CALL] Error removing the sandbox of the pod " + podUID)
 		}
 	}
 }
@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+)
+
+const (
+	// DefaultCreateWorkers is the number of PODs created at the same time, unless configured otherwise
+	DefaultCreateWorkers = 4
+	// DefaultCreateQueueSize is the number of PODs waiting to be created, unless configured otherwise
+	DefaultCreateQueueSize = 100
+	// createRetryAfter is suggested to the clients whose PODs do not fit in the queue
+	createRetryAfter = 10 * time.Second
+)
+
+// ErrCreateQueueFull is returned when the PODs of a create request do not fit in the queue
+var ErrCreateQueueFull = errors.New("the create queue is full")
+
+// createJob is a POD admitted to the create queue
+type createJob struct {
+	data commonIL.RetrievedPodData
+	wd   string
+	// enqueued is the time the POD has been admitted to the queue
+	enqueued time.Time
+	// canceled is set when the POD is deleted before being created
+	canceled bool
+	// done is closed once the job has been either created or dropped
+	done chan struct{}
+}
+
+// CreateQueue admits the PODs to create right away and creates them in background with a bounded number of workers.
+// The PODs are reported as pending until their creation has completed.
+type CreateQueue struct {
+	Ctx context.Context
+
+	workers int
+	size    int
+	jobs    chan *createJob
+	create  func(data commonIL.RetrievedPodData, wd string, enqueued time.Time) error
+
+	mu sync.Mutex
+	// queued is the number of PODs waiting for a worker
+	queued int
+	// creating is the number of PODs being created
+	creating int
+	// pending holds the PODs queued or being created, by POD UID
+	pending map[string]*createJob
+	// failures holds the errors of the PODs whose creation failed, by POD UID, until they are deleted
+	failures map[string]string
+}
+
+// NewCreateQueue starts the workers creating the PODs of the queue with the create function, until the context is done.
+// The create function also receives the time each POD has been admitted to the queue.
+func NewCreateQueue(ctx context.Context, workers int, size int, create func(data commonIL.RetrievedPodData, wd string, enqueued time.Time) error) *CreateQueue {
+	if workers <= 0 {
+		workers = DefaultCreateWorkers
+	}
+	if size <= 0 {
+		size = DefaultCreateQueueSize
+	}
+
+	q := &CreateQueue{
+		Ctx:      ctx,
+		workers:  workers,
+		size:     size,
+		jobs:     make(chan *createJob, size),
+		create:   create,
+		pending:  make(map[string]*createJob),
+		failures: make(map[string]string),
+	}
+
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+
+	return q
+}
+
+// Enqueue admits all the PODs to the queue, or none of them if they do not fit
+func (q *CreateQueue) Enqueue(pods []commonIL.RetrievedPodData, wd string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.queued+len(pods) > q.size {
+		return ErrCreateQueueFull
+	}
+
+	for _, data := range pods {
+		podUID := string(data.Pod.UID)
+		if _, ok := q.pending[podUID]; ok {
+			// the POD is already going to be created
+			continue
+		}
+		job := &createJob{data: data, wd: wd, enqueued: time.Now(), done: make(chan struct{})}
+
+		// a POD submitted again replaces its previous failure
+		delete(q.failures, podUID)
+		q.pending[podUID] = job
+		q.queued++
+		q.jobs <- job
+	}
+
+	return nil
+}
+
+func (q *CreateQueue) work() {
+	for {
+		select {
+		case <-q.Ctx.Done():
+			return
+		case job := <-q.jobs:
+			q.run(job)
+		}
+	}
+}
+
+func (q *CreateQueue) run(job *createJob) {
+	podUID := string(job.data.Pod.UID)
+	defer close(job.done)
+
+	q.mu.Lock()
+	q.queued--
+	canceled := job.canceled
+	if !canceled {
+		q.creating++
+	}
+	q.mu.Unlock()
+
+	if canceled {
+		log.G(q.Ctx).Info("\u2705 [CREATE CALL] POD " + podUID + " has been deleted before being created")
+		return
+	}
+
+	err := q.create(job.data, job.wd, job.enqueued)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.creating--
+	if q.pending[podUID] == job {
+		delete(q.pending, podUID)
+		if err != nil && !job.canceled {
+			q.failures[podUID] = err.Error()
+		}
+	}
+}
+
+// Pending reports whether the POD is waiting to be created or being created
+func (q *CreateQueue) Pending(podUID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.pending[podUID]
+	return ok
+}
+
+// Failure returns the error of the creation of the POD, if it failed
+func (q *CreateQueue) Failure(podUID string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	message, ok := q.failures[podUID]
+	return message, ok
+}
+
+// Cancel forgets the POD, which is being deleted. A POD still in the queue is dropped, the returned channel is closed once
+// the POD is not being created anymore, so that its deletion does not race with its creation.
+func (q *CreateQueue) Cancel(podUID string) <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.failures, podUID)
+
+	job, ok := q.pending[podUID]
+	if !ok {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+
+	job.canceled = true
+	delete(q.pending, podUID)
+	return job.done
+}
+
+// CreateQueueStats is the occupation of the create queue
+type CreateQueueStats struct {
+	// Depth is the number of PODs waiting for a worker
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+	// Creating is the number of PODs being created
+	Creating int `json:"creating"`
+	Workers  int `json:"workers"`
+}
+
+// Stats returns the occupation of the create queue
+func (q *CreateQueue) Stats() CreateQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return CreateQueueStats{Depth: q.queued, Capacity: q.size, Creating: q.creating, Workers: q.workers}
+}
@@ -0,0 +1,184 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+)
+
+func podData(podUIDs ...string) []commonIL.RetrievedPodData {
+	var pods []commonIL.RetrievedPodData
+	for _, podUID := range podUIDs {
+		pods = append(pods, commonIL.RetrievedPodData{Pod: v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(podUID)}}})
+	}
+	return pods
+}
+
+// newTestCreateQueue returns a queue with a single worker, whose creations report their POD UID on started and return the errors sent on results
+func newTestCreateQueue(t *testing.T, size int) (queue *CreateQueue, started chan string, results chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	started = make(chan string)
+	results = make(chan error)
+	queue = NewCreateQueue(ctx, 1, size, func(data commonIL.RetrievedPodData, wd string, enqueued time.Time) error {
+		started <- string(data.Pod.UID)
+		return <-results
+	})
+	return queue, started, results
+}
+
+func TestCreateQueue(t *testing.T) {
+	queue, started, results := newTestCreateQueue(t, 2)
+
+	if err := queue.Enqueue(podData("a"), "/wd"); err != nil {
+		t.Fatal(err)
+	}
+	if podUID := <-started; podUID != "a" {
+		t.Fatalf("got %s created first, want a", podUID)
+	}
+
+	// the PODs are admitted all together or not at all
+	if err := queue.Enqueue(podData("b", "c"), "/wd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Enqueue(podData("d"), "/wd"); !errors.Is(err, ErrCreateQueueFull) {
+		t.Fatalf("got %v, want ErrCreateQueueFull", err)
+	}
+	if stats := queue.Stats(); stats != (CreateQueueStats{Depth: 2, Capacity: 2, Creating: 1, Workers: 1}) {
+		t.Fatalf("got %+v", stats)
+	}
+	for _, podUID := range []string{"a", "b", "c"} {
+		if !queue.Pending(podUID) {
+			t.Fatalf("POD %s is not pending", podUID)
+		}
+	}
+	if queue.Pending("d") {
+		t.Fatal("the POD not admitted is pending")
+	}
+
+	// a queued POD deleted before its creation is dropped
+	canceled := queue.Cancel("b")
+	if queue.Pending("b") {
+		t.Fatal("the canceled POD is still pending")
+	}
+
+	results <- nil
+	if podUID := <-started; podUID != "c" {
+		t.Fatalf("got %s created, want c", podUID)
+	}
+	<-canceled
+	if queue.Pending("a") {
+		t.Fatal("the created POD is still pending")
+	}
+
+	// a failed creation is reported until the POD is deleted or submitted again
+	results <- errors.New("no such image")
+	for queue.Pending("c") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if message, failed := queue.Failure("c"); !failed || message != "no such image" {
+		t.Fatalf("got %q, %t, want the error of the creation", message, failed)
+	}
+	if _, failed := queue.Failure("a"); failed {
+		t.Fatal("the created POD has failed")
+	}
+
+	if err := queue.Enqueue(podData("c"), "/wd"); err != nil {
+		t.Fatal(err)
+	}
+	if _, failed := queue.Failure("c"); failed {
+		t.Fatal("the failure has not been replaced by the new submission")
+	}
+	<-started
+	results <- errors.New("no such image")
+	for queue.Pending("c") {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	<-queue.Cancel("c")
+	if _, failed := queue.Failure("c"); failed {
+		t.Fatal("the failure of the deleted POD is still reported")
+	}
+}
+
+func TestCreateQueueCancelCreating(t *testing.T) {
+	queue, started, results := newTestCreateQueue(t, 1)
+
+	if err := queue.Enqueue(podData("a"), "/wd"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// the deletion waits for the creation in progress
+	canceled := queue.Cancel("a")
+	select {
+	case <-canceled:
+		t.Fatal("the cancel has not waited for the creation")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	results <- errors.New("canceled")
+	<-canceled
+	if _, failed := queue.Failure("a"); failed {
+		t.Fatal("the failure of the deleted POD is reported")
+	}
+}
+
+func TestCreateHandlerQueueFull(t *testing.T) {
+	queue, _, _ := newTestCreateQueue(t, 1)
+	handler := &SidecarHandler{Ctx: context.Background(), CreateQueue: queue}
+
+	body, err := json.Marshal(podData("a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.CreateHandler(recorder, httptest.NewRequest(http.MethodPost, "/create", bytes.NewReader(body)))
+
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "10" {
+		t.Fatalf("got %d with Retry-After %q, want 429 with Retry-After 10", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+	if queue.Pending("a") || queue.Pending("b") {
+		t.Fatal("the PODs have been admitted")
+	}
+}
+
+func TestCreateHandlerAdmitted(t *testing.T) {
+	queue, started, results := newTestCreateQueue(t, 2)
+	handler := &SidecarHandler{Ctx: context.Background(), CreateQueue: queue}
+
+	body, err := json.Marshal(podData("a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.CreateHandler(recorder, httptest.NewRequest(http.MethodPost, "/create", bytes.NewReader(body)))
+
+	// the PODs are created after the response, which cannot hold their errors
+	want := `[{"PodUID":"a","PodJID":""},{"PodUID":"b","PodJID":""}]`
+	if recorder.Code != http.StatusOK || recorder.Body.String() != want {
+		t.Fatalf("got %d %s, want 200 %s", recorder.Code, recorder.Body.String(), want)
+	}
+
+	// the failure of a POD is reported afterwards, through its status
+	<-started
+	results <- errors.New("no such image")
+	<-started
+	results <- nil
+	<-queue.Cancel("b")
+	if message, failed := queue.Failure("a"); !failed || message != "no such image" {
+		t.Fatalf("got failure %q, %t, want no such image", message, failed)
+	}
+}
@@ -1,17 +1,16 @@
 package docker
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
-	exec "github.com/alexellis/go-execute/pkg/v1"
 	"github.com/containerd/containerd/log"
 	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
-	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/dindmanager"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	trace "go.opentelemetry.io/otel/trace"
@@ -20,7 +19,7 @@ import (
 	"path/filepath"
 )
 
-// DeleteHandler stops and deletes Docker containers from provided data
+// DeleteHandler starts the deletion of the POD in background: its containers are stopped gracefully, then its sandbox and its files are removed
 func (h *SidecarHandler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	log.G(h.Ctx).Info("\u23F3 [DELETE CALL] Received delete call from Interlink")
 
@@ -30,7 +29,6 @@ func (h *SidecarHandler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		attribute.Int64("start.timestamp", start),
 	))
 
-	var execReturn exec.ExecResult
 	statusCode := http.StatusOK
 	bodyBytes, err := io.ReadAll(r.Body)
 
@@ -55,79 +53,119 @@ func (h *SidecarHandler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	podUID := string(pod.UID)
 	podNamespace := string(pod.Namespace)
 
-	for _, container := range pod.Spec.Containers {
-		containerName := podNamespace + "-" + podUID + "-" + container.Name
-		h.GpuManager.Release(containerName)
+	wd, err := os.Getwd()
+	if err != nil {
+		HandleErrorAndRemoveData(h, w, "Unable to get current working directory", err, "", "")
+		return
 	}
+	podDirectoryPathToDelete := filepath.Join(wd, h.Config.DataRootFolder+"/"+podNamespace+"-"+podUID)
 
-	log.G(h.Ctx).Debug("\u2705 [DELETE CALL] Deleting POD " + podUID + "_dind")
-
-	cmd := []string{"rm", "-f", podUID + "_dind"}
-	shell := exec.ExecTask{
-		Command: "docker",
-		Args:    cmd,
-		Shell:   true,
+	// the POD is deleted in background, its status is reported as terminating in the meantime
+	if h.Supervisors.StartTermination(podUID) {
+		go h.terminatePod(pod, podDirectoryPathToDelete)
+	} else {
+		log.G(h.Ctx).Info("\u2705 [DELETE CALL] POD " + podUID + " is already being deleted")
 	}
-	execReturn, _ = shell.Execute()
-	execReturn.Stdout = strings.ReplaceAll(execReturn.Stdout, "\n", "")
 
-	if execReturn.Stderr != "" {
-		log.G(h.Ctx).Error("\u274C [DELETE CALL] Error deleting container " + podUID + "_dind")
-		statusCode = http.StatusInternalServerError
-	} else {
-		log.G(h.Ctx).Info("\u2705 [DELETE CALL] Deleted container " + podUID + "_dind")
+	span.SetAttributes(attribute.String("podUID", podUID))
+
+	w.WriteHeader(statusCode)
+	w.Write([]byte("Deletion of the submitted Pods has started"))
+
+	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+	span.End()
+}
+
+// terminatePod stops the containers of the POD within its termination grace period, and then removes its sandbox and its files.
+// The logs of its containers are kept until the retention time of the log archive has expired.
+func (h *SidecarHandler) terminatePod(pod v1.Pod, podDirectoryPath string) {
+	podUID := string(pod.UID)
+	defer h.Supervisors.EndTermination(podUID)
+
+	// a POD still in the create queue is dropped, while the creation in progress of a POD is completed before deleting it
+	<-h.CreateQueue.Cancel(podUID)
+
+	sandbox, err := h.Sandboxes.Get(h.Ctx, podUID)
+	if err == nil {
+		// the failures of the preStop hooks are recorded by the stopped supervisor of the POD, which reports them in the status
+		supervisor, _ := h.Supervisors.Get(podUID)
+		stopPodContainers(h.Ctx, sandbox, pod, supervisor)
+	} else if !containerruntime.IsNotFound(err) {
+		log.G(h.Ctx).Error("\u274C [DELETE CALL] Error retrieving the sandbox of POD " + podUID + ": " + err.Error())
 	}
 
-	dindSpec := dindmanager.DindSpecs{}
-	dindSpec, err = h.DindManager.GetDindFromPodUID(podUID)
+	// the last lines written by the containers are archived before their removal
+	h.Logs.Stop(podUID)
+
+	h.releaseAccelerators(pod)
 
+	err = h.Sandboxes.Remove(h.Ctx, podUID)
 	if err != nil {
-		log.G(h.Ctx).Error("\u274C [DELETE CALL] Error retrieving DindSpecs, maybe the Dind container has already been deleted")
-	} else {
-		log.G(h.Ctx).Info("\u2705 [DELETE CALL] Retrieved DindSpecs: " + dindSpec.DindID + " " + dindSpec.PodUID + " " + dindSpec.DindNetworkID + " ")
+		log.G(h.Ctx).Error("\u274C [DELETE CALL] Error removing the sandbox of POD " + podUID + ": " + err.Error())
+	}
+	h.StatusCache.Forget(podUID)
+	h.Metrics.ForgetPod(podUID)
 
-		// log the retrieved dindSpec
-		log.G(h.Ctx).Info("\u2705 [DELETE CALL] Retrieved DindSpecs: " + dindSpec.DindID + " " + dindSpec.PodUID + " " + dindSpec.DindNetworkID + " ")
+	log.G(h.Ctx).Info("\u2705 [DELETE CALL] Deleting directory " + podDirectoryPath)
+	err = h.Logs.RemovePodDirectory(podDirectoryPath)
+	if err != nil {
+		log.G(h.Ctx).Error(err)
+	}
 
-		cmd = []string{"network", "rm", dindSpec.DindNetworkID}
-		shell = exec.ExecTask{
-			Command: "docker",
-			Args:    cmd,
-			Shell:   true,
-		}
-		execReturn, _ = shell.Execute()
-		execReturn.Stdout = strings.ReplaceAll(execReturn.Stdout, "\n", "")
-		if execReturn.Stderr != "" {
-			log.G(h.Ctx).Error("\u274C [DELETE CALL] Error deleting network " + dindSpec.DindNetworkID)
+	log.G(h.Ctx).Info("\u2705 [DELETE CALL] POD " + podUID + " deleted")
+}
+
+// stopPodContainers stops the containers of the POD within its termination grace period, in the reverse order of their start: the containers and the init containers still running,
+// then the sidecars. Each container receives SIGTERM, after its preStop hook if any, and is killed if it is still running at the deadline shared by all of them.
+func stopPodContainers(ctx context.Context, sandbox Sandbox, pod v1.Pod, supervisor *PodSupervisor) {
+	deadline := time.Now().Add(terminationGracePeriod(pod))
+
+	var containers, sidecars []v1.Container
+	for _, container := range pod.Spec.InitContainers {
+		if isSidecar(container) {
+			sidecars = append(sidecars, container)
 		} else {
-			log.G(h.Ctx).Info("\u2705 [DELETE CALL] Deleted network " + dindSpec.DindNetworkID)
-		}
-		// set the dind available again
-		err = h.DindManager.RemoveDindFromList(dindSpec.PodUID)
-		if err != nil {
-			log.G(h.Ctx).Error("\u274C [DELETE CALL] Error setting DIND container available")
+			containers = append(containers, container)
 		}
 	}
-	wd, err := os.Getwd()
-	if err != nil {
-		HandleErrorAndRemoveData(h, w, "Unable to get current working directory", err, "", "")
-		return
+	containers = append(containers, pod.Spec.Containers...)
+
+	for i := len(containers) - 1; i >= 0; i-- {
+		stopPodContainer(ctx, sandbox, pod, containers[i], deadline, supervisor)
 	}
-	podDirectoryPathToDelete := filepath.Join(wd, h.Config.DataRootFolder+"/"+podNamespace+"-"+podUID)
-	log.G(h.Ctx).Info("\u2705 [DELETE CALL] Deleting directory " + podDirectoryPathToDelete)
 
-	err = os.RemoveAll(podDirectoryPathToDelete)
+	// the sidecars keep serving the containers until they have stopped
+	for i := len(sidecars) - 1; i >= 0; i-- {
+		stopPodContainer(ctx, sandbox, pod, sidecars[i], deadline, supervisor)
+	}
+}
+
+// stopPodContainer stops a container of the POD if it is running, before the deadline
+func stopPodContainer(ctx context.Context, sandbox Sandbox, pod v1.Pod, container v1.Container, deadline time.Time, supervisor *PodSupervisor) {
+	containerName := pod.Namespace + "-" + string(pod.UID) + "-" + container.Name
 
-	w.WriteHeader(statusCode)
-	if statusCode != http.StatusOK {
-		w.Write([]byte("Some errors occurred deleting containers. Check Docker Sidecar's logs"))
-	} else {
-		w.Write([]byte("All containers for submitted Pods have been deleted"))
+	containerInfo, err := sandbox.Runtime.Inspect(ctx, containerName)
+	if err != nil || !containerInfo.State.Running {
+		return
 	}
 
+	log.G(ctx).Info("\u2705 [DELETE CALL] Stopping container " + containerName)
+
+	err = stopContainer(ctx, sandbox, containerName, container, time.Until(deadline), supervisor)
 	if err != nil {
-		span.SetAttributes(attribute.String("error", err.Error()))
+		log.G(ctx).Error("\u274C [DELETE CALL] Error stopping container " + containerName + ": " + err.Error())
+	}
+}
+
+// releaseAccelerators releases the GPUs and FPGAs assigned to the containers of the POD
+func (h *SidecarHandler) releaseAccelerators(pod v1.Pod) {
+	containers := make([]v1.Container, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	containers = append(append(containers, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		containerName := pod.Namespace + "-" + string(pod.UID) + "-" + container.Name
+		h.GpuManager.Release(containerName)
+		if h.FPGAManager != nil {
+			h.FPGAManager.Release(containerName)
+		}
 	}
-	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
-	span.End()
 }
@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+func TestStopPodContainers(t *testing.T) {
+	always := v1.ContainerRestartPolicyAlways
+	gracePeriod := int64(30)
+	pod := v1.Pod{Spec: v1.PodSpec{
+		TerminationGracePeriodSeconds: &gracePeriod,
+		InitContainers:                []v1.Container{{Name: "init"}, {Name: "proxy", RestartPolicy: &always}, {Name: "logger", RestartPolicy: &always}},
+		Containers:                    []v1.Container{{Name: "app"}, {Name: "worker"}, {Name: "completed"}},
+	}}
+	pod.Namespace = "ns"
+	pod.UID = "uid"
+
+	running := containerruntime.ContainerState{Status: "running", Running: true}
+	runtime := newFakeRuntime(
+		containerruntime.ContainerInfo{Name: "ns-uid-init", State: exitedState(0, time.Second)},
+		containerruntime.ContainerInfo{Name: "ns-uid-proxy", State: running},
+		containerruntime.ContainerInfo{Name: "ns-uid-logger", State: running},
+		containerruntime.ContainerInfo{Name: "ns-uid-app", State: running},
+		containerruntime.ContainerInfo{Name: "ns-uid-worker", State: running},
+		containerruntime.ContainerInfo{Name: "ns-uid-completed", State: exitedState(0, time.Second)},
+	)
+	runtime.stopDelay = 20 * time.Millisecond
+	stopPodContainers(context.Background(), Sandbox{Runtime: runtime}, pod, nil)
+
+	// the containers are stopped one after the other in the reverse order of their start, then the sidecars
+	want := []string{"ns-uid-worker", "ns-uid-app", "ns-uid-logger", "ns-uid-proxy"}
+	if !reflect.DeepEqual(runtime.stops, want) {
+		t.Fatalf("got stops %v, want %v", runtime.stops, want)
+	}
+	for container, timeout := range runtime.stopTimeouts {
+		if timeout <= 0 || timeout > 30*time.Second {
+			t.Fatalf("container %s stopped with timeout %s, want at most the grace period", container, timeout)
+		}
+	}
+	// the grace period is shared: the time spent stopping a container is not given again to the next ones
+	if runtime.stopTimeouts["ns-uid-proxy"] > runtime.stopTimeouts["ns-uid-worker"]-3*runtime.stopDelay {
+		t.Fatalf("got timeouts %v, want the grace period shared by the containers", runtime.stopTimeouts)
+	}
+}
+
+func TestStopPodContainersDeadline(t *testing.T) {
+	gracePeriod := int64(0)
+	pod := v1.Pod{Spec: v1.PodSpec{
+		TerminationGracePeriodSeconds: &gracePeriod,
+		Containers:                    []v1.Container{{Name: "app"}},
+	}}
+	pod.Namespace = "ns"
+	pod.UID = "uid"
+
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "ns-uid-app", State: containerruntime.ContainerState{Status: "running", Running: true}})
+	stopPodContainers(context.Background(), Sandbox{Runtime: runtime}, pod, nil)
+
+	// the grace period is not extended beyond the deadline
+	if timeout, ok := runtime.stopTimeouts["ns-uid-app"]; !ok || timeout != 0 {
+		t.Fatalf("got timeout %s, want the container killed right away", timeout)
+	}
+}
+
+func TestStopPodContainersPreStopHookError(t *testing.T) {
+	container := v1.Container{Name: "app", Lifecycle: &v1.Lifecycle{PreStop: &v1.LifecycleHandler{Exec: &v1.ExecAction{Command: []string{"drain"}}}}}
+	pod := v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{container}}}
+	pod.Namespace = "ns"
+	pod.UID = "uid"
+
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "ns-uid-app", State: containerruntime.ContainerState{Status: "running", Running: true}})
+	runtime.execResults["drain"] = containerruntime.ExecResult{ExitCode: 1, Stderr: "connection refused\n"}
+
+	// the supervisor of the POD being deleted has already been stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	supervisor := &PodSupervisor{
+		Sandbox:    Sandbox{PodUID: "uid", Runtime: runtime},
+		PodName:    "pod",
+		Ctx:        ctx,
+		containers: map[string]*supervisedContainer{"ns-uid-app": {name: "app", restartPolicy: v1.RestartPolicyAlways, spec: container}},
+	}
+	supervisor.containers["ns-uid-app"].restartAt = time.Now().Add(time.Minute)
+
+	stopPodContainers(context.Background(), supervisor.Sandbox, pod, supervisor)
+
+	// the container is stopped anyway
+	if len(runtime.stops) != 1 {
+		t.Fatalf("got stops %v, want the container stopped despite its hook", runtime.stops)
+	}
+
+	containerInfo, err := runtime.Inspect(context.Background(), "ns-uid-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := v1.ContainerStatus{Name: "app", State: v1.ContainerState{Terminated: terminatedState(containerInfo.State)}}
+	supervisor.containerStatus("ns-uid-app", &status)
+
+	terminated := status.State.Terminated
+	if terminated == nil || terminated.Reason != "PreStopHookError" || terminated.Message != "command exited with code 1: connection refused" {
+		t.Fatalf("got state %+v, want terminated with PreStopHookError", status.State)
+	}
+
+	// the status of a container removed with the sandbox keeps the failure
+	status = terminatedContainerStatus(supervisor, "ns-uid-app", "app")
+	if terminated := status.State.Terminated; terminated == nil || terminated.Reason != "PreStopHookError" {
+		t.Fatalf("got state %+v, want terminated with PreStopHookError", status.State)
+	}
+}
@@ -0,0 +1,162 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/log"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/dindmanager"
+)
+
+// DindSandboxManager runs every POD inside a dedicated DIND container taken from the pool of the DindManager
+type DindSandboxManager struct {
+	DindManager dindmanager.DindManagerInterface
+	// Runtime is the runtime of the host, running the DIND containers
+	Runtime containerruntime.ContainerRuntime
+	Ctx     context.Context
+
+	// runtimes caches the runtimes connected to the Docker daemons of the DIND containers, by POD UID
+	runtimes sync.Map
+}
+
+func (m *DindSandboxManager) Create(ctx context.Context, pod v1.Pod) (Sandbox, error) {
+	podUID := string(pod.UID)
+
+	// take a dind container from dind manager and assign it to the pod
+	dindContainerID, err := m.DindManager.AcquireDind(podUID)
+	if err != nil {
+		log.G(m.Ctx).Info("\u2705 [POD FLOW] No available DIND container found, creating a new one")
+
+		dindContainerID, err = m.DindManager.BuildDindForPod(podUID)
+		if err != nil {
+			return Sandbox{}, fmt.Errorf("unable to build a DIND container: %w", err)
+		}
+	}
+
+	// replace the DIND container taken from the pool
+	m.DindManager.RefillPool()
+
+	// if the key is interlink.eu/pod-ip and the value is not empty, set the pod IP to the DIND container
+	podIpAddress := pod.Annotations["interlink.eu/pod-ip"]
+
+	log.G(m.Ctx).Info("\u2705 [POD FLOW] Pod IP Address is: " + podIpAddress)
+
+	if podIpAddress != "" {
+		err = m.connectToClusterNetwork(ctx, dindContainerID, podIpAddress)
+		if err != nil {
+			return Sandbox{}, err
+		}
+	}
+
+	// rename the container to the pod UID
+	err = m.Runtime.Rename(ctx, dindContainerID, podUID+"_dind")
+	if err != nil {
+		return Sandbox{}, err
+	}
+
+	return m.Get(ctx, podUID)
+}
+
+// connectToClusterNetwork connects the DIND container to the vk0 network with the pod IP, and sets the route and the nameserver of the cluster
+func (m *DindSandboxManager) connectToClusterNetwork(ctx context.Context, dindContainerID string, podIpAddress string) error {
+	err := m.Runtime.ConnectNetwork(ctx, "vk0", dindContainerID, podIpAddress)
+	if err != nil {
+		return err
+	}
+
+	routeIP := strings.Split(podIpAddress, ".")
+	routeIP[3] = "251"
+	route := strings.Join(routeIP, ".")
+
+	log.G(m.Ctx).Info("\u2705 [POD FLOW] Route IP is: " + route)
+
+	// inside the dind container, add the route to the pod IP ip route add 10.0.0.0/8  via 10.244.12.251
+	_, err = m.Runtime.Exec(ctx, dindContainerID, []string{"ip", "route", "add", "10.0.0.0/8", "via", route})
+	if err != nil {
+		return err
+	}
+
+	_, err = m.Runtime.Exec(ctx, dindContainerID, []string{"/bin/sh", "-c", "echo 'nameserver 10.96.0.10' > /etc/resolv.conf"})
+	return err
+}
+
+func (m *DindSandboxManager) Get(ctx context.Context, podUID string) (Sandbox, error) {
+	dindInfo, err := m.Runtime.Inspect(ctx, podUID+"_dind")
+	if err != nil {
+		return Sandbox{}, err
+	}
+
+	if podRuntime, ok := m.runtimes.Load(podUID); ok {
+		return Sandbox{ID: dindInfo.ID, PodUID: podUID, Runtime: podRuntime.(containerruntime.ContainerRuntime), Dial: execDialer(m.Runtime, dindInfo.ID), HostRuntime: m.Runtime}, nil
+	}
+
+	socketPath, ok := dindInfo.Labels[dindmanager.DindSocketLabel]
+	if !ok {
+		return Sandbox{}, errors.New("DIND container " + dindInfo.Name + " does not expose the socket of its Docker daemon")
+	}
+
+	podRuntime, err := dindmanager.NewDindRuntime(socketPath)
+	if err != nil {
+		return Sandbox{}, err
+	}
+
+	cachedRuntime, loaded := m.runtimes.LoadOrStore(podUID, podRuntime)
+	if loaded {
+		podRuntime.Close()
+	}
+
+	// the containers of the POD are attached to a network that exists only inside the DIND container
+	return Sandbox{ID: dindInfo.ID, PodUID: podUID, Runtime: cachedRuntime.(containerruntime.ContainerRuntime), Dial: execDialer(m.Runtime, dindInfo.ID), HostRuntime: m.Runtime}, nil
+}
+
+func (m *DindSandboxManager) Remove(ctx context.Context, podUID string) error {
+	log.G(m.Ctx).Debug("\u2705 [DELETE CALL] Deleting POD " + podUID + "_dind")
+
+	dindSpec, dindErr := m.DindManager.GetDindFromPodUID(podUID)
+
+	err := m.Runtime.Remove(ctx, podUID+"_dind", true)
+	if containerruntime.IsNotFound(err) && dindErr == nil {
+		// the pod failed before the DIND container was renamed
+		err = m.Runtime.Remove(ctx, dindSpec.DindID, true)
+	}
+	if err != nil {
+		log.G(m.Ctx).Error("\u274C [DELETE CALL] Error deleting container " + podUID + "_dind")
+	} else {
+		log.G(m.Ctx).Info("\u2705 [DELETE CALL] Deleted container " + podUID + "_dind")
+	}
+
+	if podRuntime, ok := m.runtimes.LoadAndDelete(podUID); ok {
+		podRuntime.(containerruntime.ContainerRuntime).Close()
+	}
+
+	if dindErr != nil {
+		log.G(m.Ctx).Error("\u274C [DELETE CALL] Error retrieving DindSpecs, maybe the Dind container has already been deleted")
+		return err
+	}
+
+	log.G(m.Ctx).Info("\u2705 [DELETE CALL] Retrieved DindSpecs: " + dindSpec.DindID + " " + dindSpec.PodUID + " " + dindSpec.DindNetworkID + " ")
+
+	networkErr := m.Runtime.RemoveNetwork(ctx, dindSpec.DindNetworkID)
+	if networkErr != nil {
+		log.G(m.Ctx).Error("\u274C [DELETE CALL] Error deleting network " + dindSpec.DindNetworkID)
+	} else {
+		log.G(m.Ctx).Info("\u2705 [DELETE CALL] Deleted network " + dindSpec.DindNetworkID)
+	}
+	os.RemoveAll(filepath.Dir(dindSpec.SocketPath))
+
+	// remove the dind from the list of dind containers
+	dindErr = m.DindManager.RemoveDindFromList(dindSpec.PodUID)
+	if dindErr != nil {
+		log.G(m.Ctx).Error("\u274C [DELETE CALL] Error removing DIND container from the list")
+	}
+
+	return err
+}
@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/log"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// DirectSandboxManager runs the containers of every POD on the host daemon, attached to a bridge network dedicated to the POD.
+// It avoids the overhead of the DIND containers and is meant for trusted single-tenant nodes.
+type DirectSandboxManager struct {
+	// Runtime is the runtime of the host, running the containers of the PODs
+	Runtime containerruntime.ContainerRuntime
+	Ctx     context.Context
+}
+
+func directNetworkName(podUID string) string {
+	return podUID + "_network"
+}
+
+func (m *DirectSandboxManager) Create(ctx context.Context, pod v1.Pod) (Sandbox, error) {
+	podUID := string(pod.UID)
+
+	if pod.Annotations["interlink.eu/pod-ip"] != "" {
+		log.G(m.Ctx).Warn("\u26A0 [POD FLOW] The interlink.eu/pod-ip annotation is not supported in direct mode and is ignored for POD " + podUID)
+	}
+
+	networkID, err := m.Runtime.CreateNetwork(ctx, directNetworkName(podUID))
+	if err != nil {
+		return Sandbox{}, err
+	}
+
+	log.G(m.Ctx).Info("\u2705 [POD FLOW] Network " + directNetworkName(podUID) + " created")
+
+	return Sandbox{ID: networkID, PodUID: podUID, Network: directNetworkName(podUID), Runtime: m.Runtime}, nil
+}
+
+func (m *DirectSandboxManager) Get(ctx context.Context, podUID string) (Sandbox, error) {
+	networks, err := m.Runtime.ListNetworks(ctx, "^"+directNetworkName(podUID)+"$")
+	if err != nil {
+		return Sandbox{}, err
+	}
+	if len(networks) == 0 {
+		return Sandbox{}, fmt.Errorf("%w: network %s", containerruntime.ErrNotFound, directNetworkName(podUID))
+	}
+
+	return Sandbox{ID: networks[0].ID, PodUID: podUID, Network: networks[0].Name, Runtime: m.Runtime}, nil
+}
+
+func (m *DirectSandboxManager) Remove(ctx context.Context, podUID string) error {
+	log.G(m.Ctx).Debug("\u2705 [DELETE CALL] Deleting containers of POD " + podUID)
+
+	containers, err := m.Runtime.List(ctx, containerruntime.ListOptions{All: true, Labels: map[string]string{PodUIDLabel: podUID}})
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		err = m.Runtime.Remove(ctx, container.ID, true)
+		if err != nil && !containerruntime.IsNotFound(err) {
+			log.G(m.Ctx).Error("\u274C [DELETE CALL] Error deleting container " + container.Name)
+			return err
+		}
+		log.G(m.Ctx).Info("\u2705 [DELETE CALL] Deleted container " + container.Name)
+	}
+
+	// the network is already gone when the POD is deleted again
+	err = m.Runtime.RemoveNetwork(ctx, directNetworkName(podUID))
+	if err != nil && !containerruntime.IsNotFound(err) {
+		log.G(m.Ctx).Error("\u274C [DELETE CALL] Error deleting network " + directNetworkName(podUID))
+		return err
+	}
+
+	log.G(m.Ctx).Info("\u2705 [DELETE CALL] Deleted network " + directNetworkName(podUID))
+	return nil
+}
@@ -0,0 +1,290 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	trace "go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream/wsstream"
+	"k8s.io/apimachinery/pkg/util/remotecommand"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// the WebSocket subprotocols of the exec streams, as accepted by the kubelet
+const (
+	execBase64Protocol   = wsstream.Base64ChannelWebSocketProtocol
+	execV4BinaryProtocol = remotecommand.StreamProtocolV4Name
+	execV4Base64Protocol = "v4." + wsstream.Base64ChannelWebSocketProtocol
+)
+
+// execRequest is a command to run inside a container of a POD, read from the query of the exec request
+type execRequest struct {
+	Namespace     string
+	PodUID        string
+	ContainerName string
+	Command       []string
+	Stdin         bool
+	Tty           bool
+	// Width and Height are the initial size of the terminal, if any
+	Width  uint
+	Height uint
+}
+
+// terminalSize is a resize of the terminal, sent by the client on the resize channel
+type terminalSize struct {
+	Width  uint16
+	Height uint16
+}
+
+// ExecHandler runs a command inside a container of a POD, as kubectl exec does. The standard streams of the command are relayed over a WebSocket
+// with the channel framing of the kubelet: stdin, stdout, stderr, error and resize on the channels 0 to 4. The exit status of the command is sent on the error channel.
+func (h *SidecarHandler) ExecHandler(w http.ResponseWriter, r *http.Request) {
+	log.G(h.Ctx).Info("\u23F3 [EXEC CALL] Received exec call")
+
+	start := time.Now().UnixMicro()
+	tracer := otel.Tracer("interlink-API")
+	_, span := tracer.Start(h.Ctx, "Exec", trace.WithAttributes(
+		attribute.Int64("start.timestamp", start),
+	))
+
+	statusCode := http.StatusOK
+
+	req, err := parseExecRequest(r)
+	if err == nil && !wsstream.IsWebSocketRequest(r) {
+		err = errors.New("the exec streams require a WebSocket upgrade")
+	}
+	if err != nil {
+		statusCode = http.StatusBadRequest
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(statusCode)
+		w.Write([]byte(err.Error()))
+		handleError(span, err, statusCode, start)
+		return
+	}
+
+	containerName := req.Namespace + "-" + req.PodUID + "-" + req.ContainerName
+	span.SetAttributes(attribute.String("podUID", req.PodUID), attribute.String("container", containerName))
+
+	sandbox, err := h.Sandboxes.Get(h.Ctx, req.PodUID)
+	var containerInfo containerruntime.ContainerInfo
+	if err == nil {
+		containerInfo, err = sandbox.Runtime.Inspect(h.Ctx, containerName)
+	}
+	if err == nil && !containerInfo.State.Running {
+		err = fmt.Errorf("%w: container %s is not running", containerruntime.ErrNotFound, containerName)
+	}
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		if containerruntime.IsNotFound(err) {
+			statusCode = http.StatusNotFound
+		}
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(statusCode)
+		w.Write([]byte(err.Error()))
+		handleError(span, err, statusCode, start)
+		return
+	}
+
+	session, err := sandbox.Runtime.ExecAttach(h.Ctx, containerName, containerruntime.ExecOptions{Cmd: req.Command, Stdin: req.Stdin, Tty: req.Tty})
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(statusCode)
+		w.Write([]byte(err.Error()))
+		handleError(span, err, statusCode, start)
+		return
+	}
+	defer session.Close()
+
+	if req.Tty && req.Width > 0 && req.Height > 0 {
+		err = session.Resize(h.Ctx, req.Height, req.Width)
+		if err != nil {
+			log.G(h.Ctx).Error("\u274C [EXEC CALL] Error resizing the terminal of the exec in container " + containerName + ": " + err.Error())
+		}
+	}
+
+	conn := wsstream.NewConn(execChannelProtocols(req))
+	protocol, streams, err := conn.Open(w, r)
+	if err != nil {
+		// the handshake has already answered the client
+		log.G(h.Ctx).Error("\u274C [EXEC CALL] Error opening the exec streams: " + err.Error())
+		handleError(span, err, http.StatusBadRequest, start)
+		return
+	}
+	defer conn.Close()
+
+	log.G(h.Ctx).Info("\u2705 [EXEC CALL] Running " + fmt.Sprint(req.Command) + " in container " + containerName)
+
+	exitCode, err := relayExecStreams(h, session, streams, req)
+
+	writeExecStatus(streams[remotecommand.StreamErr], protocol, exitCode, err)
+
+	if err != nil {
+		log.G(h.Ctx).Error("\u274C [EXEC CALL] The exec in container " + containerName + " has failed: " + err.Error())
+		span.SetAttributes(attribute.String("error", err.Error()))
+	} else {
+		log.G(h.Ctx).Info("\u2705 [EXEC CALL] The exec in container " + containerName + " has exited with code " + strconv.Itoa(exitCode))
+		span.SetAttributes(attribute.Int("exec.exitCode", exitCode))
+	}
+	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(http.StatusSwitchingProtocols))
+	span.End()
+}
+
+// parseExecRequest reads the command to run from the query of the request: namespace, podUID, container,
+// command (repeated for every argument), stdin, tty, and the initial size of the terminal as width and height
+func parseExecRequest(r *http.Request) (execRequest, error) {
+	query := r.URL.Query()
+
+	req := execRequest{
+		Namespace:     query.Get("namespace"),
+		PodUID:        query.Get("podUID"),
+		ContainerName: query.Get("container"),
+		Command:       query["command"],
+	}
+	if req.Namespace == "" || req.PodUID == "" || req.ContainerName == "" {
+		return req, errors.New("the namespace, the podUID and the container of the exec are required")
+	}
+	if len(req.Command) == 0 {
+		return req, errors.New("the command of the exec is required")
+	}
+
+	var err error
+	for name, flag := range map[string]*bool{"stdin": &req.Stdin, "tty": &req.Tty} {
+		if value := query.Get(name); value != "" {
+			*flag, err = strconv.ParseBool(value)
+			if err != nil {
+				return req, fmt.Errorf("invalid %s %s: %w", name, value, err)
+			}
+		}
+	}
+	for name, size := range map[string]*uint{"width": &req.Width, "height": &req.Height} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return req, fmt.Errorf("invalid %s %s: %w", name, value, err)
+			}
+			*size = uint(parsed)
+		}
+	}
+
+	return req, nil
+}
+
+// execChannelProtocols returns the subprotocols accepted for the exec streams, with the channels opened for the exec.
+// The standard error is merged into the standard output when a terminal is allocated, and the resize channel is only read then.
+func execChannelProtocols(req execRequest) map[string]wsstream.ChannelProtocolConfig {
+	channels := make([]wsstream.ChannelType, remotecommand.StreamResize+1)
+	if req.Stdin {
+		channels[remotecommand.StreamStdIn] = wsstream.ReadChannel
+	}
+	channels[remotecommand.StreamStdOut] = wsstream.WriteChannel
+	if !req.Tty {
+		channels[remotecommand.StreamStdErr] = wsstream.WriteChannel
+	}
+	channels[remotecommand.StreamErr] = wsstream.WriteChannel
+	if req.Tty {
+		channels[remotecommand.StreamResize] = wsstream.ReadChannel
+	}
+
+	return map[string]wsstream.ChannelProtocolConfig{
+		"":                                 {Binary: true, Channels: channels},
+		remotecommand.StreamProtocolV1Name: {Binary: true, Channels: channels},
+		execBase64Protocol:                 {Binary: false, Channels: channels},
+		execV4BinaryProtocol:               {Binary: true, Channels: channels},
+		execV4Base64Protocol:               {Binary: false, Channels: channels},
+		remotecommand.StreamProtocolV5Name: {Binary: true, Channels: channels},
+	}
+}
+
+// relayExecStreams copies the standard streams between the exec and the WebSocket, applies the resizes of the terminal,
+// and returns the exit code of the command once its output has been entirely sent
+func relayExecStreams(h *SidecarHandler, session containerruntime.ExecSession, streams []io.ReadWriteCloser, req execRequest) (int, error) {
+	if req.Stdin {
+		go func() {
+			// the standard input is closed when the client closes it, or when the WebSocket is closed
+			io.Copy(session.Stdin(), streams[remotecommand.StreamStdIn])
+			session.Stdin().Close()
+		}()
+	}
+
+	if req.Tty {
+		go func() {
+			decoder := json.NewDecoder(streams[remotecommand.StreamResize])
+			for {
+				var size terminalSize
+				if decoder.Decode(&size) != nil {
+					return
+				}
+				err := session.Resize(h.Ctx, uint(size.Height), uint(size.Width))
+				if err != nil {
+					log.G(h.Ctx).Error("\u274C [EXEC CALL] Error resizing the terminal of the exec: " + err.Error())
+				}
+			}
+		}()
+	}
+
+	// both outputs are drained for the command to make progress, the standard error is empty when a terminal is allocated
+	var wg sync.WaitGroup
+	var stdoutErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, stdoutErr = io.Copy(streams[remotecommand.StreamStdOut], session.Stdout())
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(streams[remotecommand.StreamStdErr], session.Stderr())
+	}()
+	wg.Wait()
+
+	if stdoutErr != nil {
+		return 0, stdoutErr
+	}
+
+	return session.Wait(h.Ctx)
+}
+
+// writeExecStatus sends the outcome of the exec on the error channel: a Status object since the v4 subprotocol, a plain message before it
+func writeExecStatus(errorStream io.Writer, protocol string, exitCode int, err error) {
+	switch protocol {
+	case execV4BinaryProtocol, execV4Base64Protocol, remotecommand.StreamProtocolV5Name:
+	default:
+		if err != nil {
+			errorStream.Write([]byte(err.Error()))
+		} else if exitCode != 0 {
+			errorStream.Write([]byte("command terminated with non-zero exit code: " + strconv.Itoa(exitCode)))
+		}
+		return
+	}
+
+	status := metav1.Status{Status: metav1.StatusSuccess}
+	if err != nil {
+		status = metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonInternalError, Message: err.Error()}
+	} else if exitCode != 0 {
+		status = metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  remotecommand.NonZeroExitCodeReason,
+			Message: "command terminated with non-zero exit code: " + strconv.Itoa(exitCode),
+			Details: &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{{Type: remotecommand.ExitCodeCauseType, Message: strconv.Itoa(exitCode)}},
+			},
+		}
+	}
+
+	bytes, err := json.Marshal(status)
+	if err == nil {
+		errorStream.Write(bytes)
+	}
+}
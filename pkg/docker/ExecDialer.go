@@ -0,0 +1,168 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// execDialer returns a dialer opening TCP connections from inside a container, relayed by netcat through an exec.
+// It reaches the networks that only exist inside the container, e.g. the network of the containers running in a DIND container.
+func execDialer(runtime containerruntime.ContainerRuntime, containerID string) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil, fmt.Errorf("network %s is not supported", network)
+		}
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		// with -v, nc reports on its standard error whether it has connected, before relaying any data
+		session, err := runtime.ExecAttach(ctx, containerID, containerruntime.ExecOptions{Cmd: []string{"nc", "-v", host, port}, Stdin: true})
+		if err != nil {
+			return nil, err
+		}
+
+		connected := make(chan error, 1)
+		go func() {
+			connected <- waitNetcatConnected(session.Stderr())
+		}()
+
+		select {
+		case err = <-connected:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			session.Close()
+			return nil, &net.OpError{Op: "dial", Net: network, Addr: execAddr(address), Err: err}
+		}
+
+		return &execConn{session: session, address: execAddr(address)}, nil
+	}
+}
+
+// waitNetcatConnected reads the standard error of nc -v until it reports the connection, which is then drained in background.
+// It returns the messages of nc if it exits without connecting.
+func waitNetcatConnected(stderr io.Reader) error {
+	reader := bufio.NewReader(stderr)
+	var messages []string
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.Contains(line, "succeeded!") {
+			go io.Copy(io.Discard, reader)
+			return nil
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			messages = append(messages, line)
+		}
+		if err != nil {
+			if len(messages) == 0 {
+				return errors.New("connection failed")
+			}
+			return errors.New(strings.Join(messages, "; "))
+		}
+	}
+}
+
+// execConn is a net.Conn relayed by a command running inside a container. The command is closed once a deadline expires, so the connection cannot be used afterwards.
+type execConn struct {
+	session containerruntime.ExecSession
+	address execAddr
+
+	mu       sync.Mutex
+	deadline *time.Timer
+	expired  bool
+}
+
+func (c *execConn) Read(b []byte) (int, error) {
+	n, err := c.session.Stdout().Read(b)
+	if err != nil && c.deadlineExpired() {
+		return n, os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *execConn) Write(b []byte) (int, error) {
+	n, err := c.session.Stdin().Write(b)
+	if err != nil && c.deadlineExpired() {
+		return n, os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+// CloseWrite closes the standard input of the command, so that the connection is half-closed while its responses are still read
+func (c *execConn) CloseWrite() error {
+	return c.session.Stdin().Close()
+}
+
+func (c *execConn) Close() error {
+	c.SetDeadline(time.Time{})
+	return errors.Join(c.session.Stdin().Close(), c.session.Close())
+}
+
+func (c *execConn) deadlineExpired() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.expired
+}
+
+func (c *execConn) LocalAddr() net.Addr {
+	return execAddr("exec")
+}
+
+func (c *execConn) RemoteAddr() net.Addr {
+	return c.address
+}
+
+// SetDeadline closes the connection at t, the reads and the writes pending at that time fail with os.ErrDeadlineExceeded. A zero t cancels the deadline.
+func (c *execConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.deadline != nil {
+		c.deadline.Stop()
+		c.deadline = nil
+	}
+	if t.IsZero() || c.expired {
+		return nil
+	}
+
+	c.deadline = time.AfterFunc(time.Until(t), func() {
+		c.mu.Lock()
+		c.expired = true
+		c.mu.Unlock()
+		c.session.Close()
+	})
+	return nil
+}
+
+func (c *execConn) SetReadDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+func (c *execConn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+// execAddr is the address of an execConn
+type execAddr string
+
+func (a execAddr) Network() string {
+	return "tcp"
+}
+
+func (a execAddr) String() string {
+	return string(a)
+}
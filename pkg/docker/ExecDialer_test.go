@@ -0,0 +1,119 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// fakeNetcat behaves as nc -v: it reports the connection on its standard error, then echoes its standard input, or it reports the failure and exits
+func fakeNetcat(connected bool) func(cmd []string) (containerruntime.ExecSession, error) {
+	return func(cmd []string) (containerruntime.ExecSession, error) {
+		session := newFakeExecSession()
+		go func() {
+			if !connected {
+				io.WriteString(session.commandStderr, "nc: connect to "+cmd[2]+" port "+cmd[3]+" (tcp) failed: Connection refused\n")
+				session.commandStderr.Close()
+				session.commandStdout.Close()
+				return
+			}
+			io.WriteString(session.commandStderr, "Connection to "+cmd[2]+" "+cmd[3]+" port [tcp/*] succeeded!\n")
+			io.Copy(session.commandStdout, session.commandStdin)
+			session.commandStdout.Close()
+		}()
+		return session, nil
+	}
+}
+
+func TestExecDialer(t *testing.T) {
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "dind", State: containerruntime.ContainerState{Running: true}})
+	runtime.execAttach = fakeNetcat(true)
+	dial := execDialer(runtime, "dind")
+
+	conn, err := dial(context.Background(), "tcp", "10.0.0.2:8888")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a single connection is opened, without a preliminary check
+	if want := [][]string{{"nc", "-v", "10.0.0.2", "8888"}}; !reflect.DeepEqual(runtime.execs, want) {
+		t.Fatalf("got commands %v, want %v", runtime.execs, want)
+	}
+
+	// the response is still read once the connection is half-closed
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.(*execConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	response, err := io.ReadAll(conn)
+	if err != nil || string(response) != "ping" {
+		t.Fatalf("got %q, %v, want ping", response, err)
+	}
+	conn.Close()
+}
+
+func TestExecDialerFailure(t *testing.T) {
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "dind", State: containerruntime.ContainerState{Running: true}})
+	runtime.execAttach = fakeNetcat(false)
+
+	_, err := execDialer(runtime, "dind")(context.Background(), "tcp", "10.0.0.2:8888")
+	if err == nil || !strings.Contains(err.Error(), "Connection refused") {
+		t.Fatalf("got %v, want the error of nc", err)
+	}
+
+	if _, err := execDialer(runtime, "dind")(context.Background(), "udp", "10.0.0.2:53"); err == nil {
+		t.Fatal("udp: got no error")
+	}
+}
+
+func TestExecDialerTimeout(t *testing.T) {
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "dind", State: containerruntime.ContainerState{Running: true}})
+	// nc never reports the connection
+	runtime.execAttach = func(cmd []string) (containerruntime.ExecSession, error) {
+		return newFakeExecSession(), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := execDialer(runtime, "dind")(ctx, "tcp", "10.0.0.2:8888")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the deadline of the context", err)
+	}
+}
+
+func TestExecConnDeadline(t *testing.T) {
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "dind", State: containerruntime.ContainerState{Running: true}})
+	runtime.execAttach = fakeNetcat(true)
+
+	conn, err := execDialer(runtime, "dind")(context.Background(), "tcp", "10.0.0.2:8888")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a cancelled deadline does not close the connection
+	conn.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	conn.SetDeadline(time.Time{})
+	time.Sleep(30 * time.Millisecond)
+	if _, err := conn.Write([]byte("a")); err != nil {
+		t.Fatalf("write after a cancelled deadline: %v", err)
+	}
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("read after a cancelled deadline: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := conn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want os.ErrDeadlineExceeded", err)
+	}
+}
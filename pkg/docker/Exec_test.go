@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/httpstream/wsstream"
+	"k8s.io/apimachinery/pkg/util/remotecommand"
+)
+
+func TestParseExecRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    execRequest
+		wantErr bool
+	}{
+		{name: "command", query: "namespace=ns&podUID=uid&container=web&command=ls&command=-l", want: execRequest{Namespace: "ns", PodUID: "uid", ContainerName: "web", Command: []string{"ls", "-l"}}},
+		{name: "terminal", query: "namespace=ns&podUID=uid&container=web&command=sh&stdin=true&tty=1&width=120&height=40", want: execRequest{Namespace: "ns", PodUID: "uid", ContainerName: "web", Command: []string{"sh"}, Stdin: true, Tty: true, Width: 120, Height: 40}},
+		{name: "flags unset", query: "namespace=ns&podUID=uid&container=web&command=sh&stdin=false", want: execRequest{Namespace: "ns", PodUID: "uid", ContainerName: "web", Command: []string{"sh"}}},
+		{name: "missing container", query: "namespace=ns&podUID=uid&command=sh", wantErr: true},
+		{name: "missing POD", query: "namespace=ns&container=web&command=sh", wantErr: true},
+		{name: "missing command", query: "namespace=ns&podUID=uid&container=web", wantErr: true},
+		{name: "invalid flag", query: "namespace=ns&podUID=uid&container=web&command=sh&tty=yes", wantErr: true},
+		{name: "invalid size", query: "namespace=ns&podUID=uid&container=web&command=sh&width=-1", wantErr: true},
+		{name: "size out of range", query: "namespace=ns&podUID=uid&container=web&command=sh&height=70000", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseExecRequest(httptest.NewRequest("GET", "/exec?"+test.query, nil))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExecChannelProtocols(t *testing.T) {
+	tests := []struct {
+		name string
+		req  execRequest
+		want []wsstream.ChannelType
+	}{
+		{
+			name: "output only",
+			req:  execRequest{},
+			want: []wsstream.ChannelType{wsstream.IgnoreChannel, wsstream.WriteChannel, wsstream.WriteChannel, wsstream.WriteChannel, wsstream.IgnoreChannel},
+		},
+		{
+			name: "stdin",
+			req:  execRequest{Stdin: true},
+			want: []wsstream.ChannelType{wsstream.ReadChannel, wsstream.WriteChannel, wsstream.WriteChannel, wsstream.WriteChannel, wsstream.IgnoreChannel},
+		},
+		{
+			name: "terminal",
+			req:  execRequest{Stdin: true, Tty: true},
+			want: []wsstream.ChannelType{wsstream.ReadChannel, wsstream.WriteChannel, wsstream.IgnoreChannel, wsstream.WriteChannel, wsstream.ReadChannel},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			protocols := execChannelProtocols(test.req)
+			for _, protocol := range []string{"", remotecommand.StreamProtocolV5Name, execV4Base64Protocol} {
+				config, ok := protocols[protocol]
+				if !ok {
+					t.Fatalf("protocol %q not accepted", protocol)
+				}
+				if !reflect.DeepEqual(config.Channels, test.want) {
+					t.Fatalf("protocol %q: got channels %v, want %v", protocol, config.Channels, test.want)
+				}
+			}
+			if protocols[execV4Base64Protocol].Binary || !protocols[remotecommand.StreamProtocolV5Name].Binary {
+				t.Fatal("the base64 protocols must not be binary")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// ExecutionModeAnnotation selects the execution mode of a POD, overriding the ExecutionMode of the node
+const ExecutionModeAnnotation = "interlink.eu/execution-mode"
+
+const (
+	// ExecutionModeDind runs every POD inside a dedicated DIND container
+	ExecutionModeDind = "dind"
+	// ExecutionModeDirect runs the containers of every POD on the host daemon
+	ExecutionModeDirect = "direct"
+)
+
+// ExecutionModeSandboxManager dispatches every POD to the SandboxManager of its execution mode
+type ExecutionModeSandboxManager struct {
+	// DefaultMode is used for the PODs without the ExecutionModeAnnotation
+	DefaultMode string
+	Managers    map[string]SandboxManager
+}
+
+func (m *ExecutionModeSandboxManager) Create(ctx context.Context, pod v1.Pod) (Sandbox, error) {
+	mode := m.DefaultMode
+	if annotation := pod.Annotations[ExecutionModeAnnotation]; annotation != "" {
+		mode = annotation
+	}
+
+	manager, ok := m.Managers[mode]
+	if !ok {
+		return Sandbox{}, fmt.Errorf("unknown execution mode %s", mode)
+	}
+
+	return manager.Create(ctx, pod)
+}
+
+// owner returns the SandboxManager holding the sandbox of the POD, the mode of a POD being known only at creation time
+func (m *ExecutionModeSandboxManager) owner(ctx context.Context, podUID string) (SandboxManager, Sandbox, error) {
+	modes := []string{m.DefaultMode}
+	for mode := range m.Managers {
+		if mode != m.DefaultMode {
+			modes = append(modes, mode)
+		}
+	}
+
+	for _, mode := range modes {
+		manager, ok := m.Managers[mode]
+		if !ok {
+			continue
+		}
+		sandbox, err := manager.Get(ctx, podUID)
+		if err == nil {
+			return manager, sandbox, nil
+		} else if !containerruntime.IsNotFound(err) {
+			return nil, Sandbox{}, err
+		}
+	}
+
+	return nil, Sandbox{}, fmt.Errorf("%w: sandbox of POD %s", containerruntime.ErrNotFound, podUID)
+}
+
+func (m *ExecutionModeSandboxManager) Get(ctx context.Context, podUID string) (Sandbox, error) {
+	_, sandbox, err := m.owner(ctx, podUID)
+	return sandbox, err
+}
+
+func (m *ExecutionModeSandboxManager) Remove(ctx context.Context, podUID string) error {
+	manager, _, err := m.owner(ctx, podUID)
+	if containerruntime.IsNotFound(err) {
+		// the sandbox may have been only partially created, let the default manager clean up what is left
+		manager, ok := m.Managers[m.DefaultMode]
+		if !ok {
+			return err
+		}
+		return manager.Remove(ctx, podUID)
+	} else if err != nil {
+		return err
+	}
+
+	return manager.Remove(ctx, podUID)
+}
@@ -0,0 +1,280 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// fakeRuntime is an in-memory ContainerRuntime holding containers indexed by name, the methods it does not implement panic
+type fakeRuntime struct {
+	containerruntime.ContainerRuntime
+
+	mu         sync.Mutex
+	containers map[string]*containerruntime.ContainerInfo
+	stats      map[string]containerruntime.ContainerStats
+	// execResults are returned by Exec, by the first argument of the command
+	execResults map[string]containerruntime.ExecResult
+	// execAttach runs the commands of ExecAttach
+	execAttach func(cmd []string) (containerruntime.ExecSession, error)
+	// execs records the commands run by Exec and ExecAttach
+	execs [][]string
+	// inspections, starts, stops and removals record the names of the containers, in order
+	inspections []string
+	starts      []string
+	stops       []string
+	removals    []string
+	// stopTimeouts records the timeout every container has been stopped with
+	stopTimeouts map[string]time.Duration
+	// stopDelay is the time Stop takes to return
+	stopDelay time.Duration
+	// statsDelay is the time Stats takes to return, maxStatsInFlight the most calls to Stats seen at the same time
+	statsDelay       time.Duration
+	statsInFlight    int
+	maxStatsInFlight int
+	// sizes records the names of the containers whose writable layer has been measured, in order
+	sizes []string
+}
+
+func newFakeRuntime(containers ...containerruntime.ContainerInfo) *fakeRuntime {
+	f := &fakeRuntime{
+		containers:   make(map[string]*containerruntime.ContainerInfo),
+		stats:        make(map[string]containerruntime.ContainerStats),
+		execResults:  make(map[string]containerruntime.ExecResult),
+		stopTimeouts: make(map[string]time.Duration),
+	}
+	for _, container := range containers {
+		f.set(container)
+	}
+	return f
+}
+
+// set adds or replaces a container, its ID defaults to its name
+func (f *fakeRuntime) set(container containerruntime.ContainerInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if container.ID == "" {
+		container.ID = container.Name
+	}
+	f.containers[container.Name] = &container
+}
+
+func (f *fakeRuntime) lookup(id string) (*containerruntime.ContainerInfo, error) {
+	if container, ok := f.containers[id]; ok {
+		return container, nil
+	}
+	for _, container := range f.containers {
+		if container.ID == id {
+			return container, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: container %s", containerruntime.ErrNotFound, id)
+}
+
+func (f *fakeRuntime) Inspect(ctx context.Context, id string) (containerruntime.ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	container, err := f.lookup(id)
+	if err != nil {
+		return containerruntime.ContainerInfo{}, err
+	}
+	f.inspections = append(f.inspections, container.Name)
+	return *container, nil
+}
+
+func (f *fakeRuntime) List(ctx context.Context, opts containerruntime.ListOptions) ([]containerruntime.ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var containers []containerruntime.ContainerInfo
+	for _, container := range f.containers {
+		if !opts.All && !container.State.Running {
+			continue
+		}
+		matches := true
+		for key, value := range opts.Labels {
+			if label, ok := container.Labels[key]; !ok || (value != "" && label != value) {
+				matches = false
+			}
+		}
+		if matches {
+			containers = append(containers, *container)
+		}
+	}
+	return containers, nil
+}
+
+func (f *fakeRuntime) Create(ctx context.Context, spec containerruntime.ContainerSpec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.containers[spec.Name] = &containerruntime.ContainerInfo{ID: spec.Name, Name: spec.Name, Image: spec.Image, Labels: spec.Labels, State: containerruntime.ContainerState{Status: "created"}}
+	return spec.Name, nil
+}
+
+func (f *fakeRuntime) Start(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	container, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	container.State = containerruntime.ContainerState{Status: "running", Running: true, StartedAt: time.Now()}
+	f.starts = append(f.starts, container.Name)
+	return nil
+}
+
+func (f *fakeRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(f.stopDelay):
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	container, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	if container.State.Running {
+		container.State.Running = false
+		container.State.Status = "exited"
+		container.State.ExitCode = 143
+		container.State.FinishedAt = time.Now()
+	}
+	f.stops = append(f.stops, container.Name)
+	f.stopTimeouts[container.Name] = timeout
+	return nil
+}
+
+func (f *fakeRuntime) Remove(ctx context.Context, id string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	container, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	delete(f.containers, container.Name)
+	f.removals = append(f.removals, container.Name)
+	return nil
+}
+
+func (f *fakeRuntime) Exec(ctx context.Context, id string, cmd []string) (containerruntime.ExecResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.lookup(id); err != nil {
+		return containerruntime.ExecResult{}, err
+	}
+	f.execs = append(f.execs, cmd)
+	if len(cmd) == 0 {
+		return containerruntime.ExecResult{ExitCode: 127}, nil
+	}
+	result, ok := f.execResults[cmd[0]]
+	if !ok {
+		return containerruntime.ExecResult{ExitCode: 127, Stderr: cmd[0] + ": not found"}, nil
+	}
+	return result, nil
+}
+
+func (f *fakeRuntime) Stats(ctx context.Context, id string, opts containerruntime.StatsOptions) (containerruntime.ContainerStats, error) {
+	f.mu.Lock()
+	f.statsInFlight++
+	f.maxStatsInFlight = max(f.maxStatsInFlight, f.statsInFlight)
+	f.mu.Unlock()
+
+	time.Sleep(f.statsDelay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.statsInFlight--
+
+	container, err := f.lookup(id)
+	if err != nil {
+		return containerruntime.ContainerStats{}, err
+	}
+	stats := f.stats[container.Name]
+	if opts.Size {
+		f.sizes = append(f.sizes, container.Name)
+	} else {
+		stats.RootfsUsedBytes = 0
+	}
+	return stats, nil
+}
+
+func (f *fakeRuntime) ExecAttach(ctx context.Context, id string, opts containerruntime.ExecOptions) (containerruntime.ExecSession, error) {
+	f.mu.Lock()
+	if _, err := f.lookup(id); err != nil {
+		f.mu.Unlock()
+		return nil, err
+	}
+	f.execs = append(f.execs, opts.Cmd)
+	f.mu.Unlock()
+
+	return f.execAttach(opts.Cmd)
+}
+
+// fakeExecSession is a command attached through pipes, its side of the pipes is driven by the test
+type fakeExecSession struct {
+	stdin  *io.PipeWriter
+	stdout *io.PipeReader
+	stderr *io.PipeReader
+
+	// commandStdin, commandStdout and commandStderr are the streams of the command
+	commandStdin  *io.PipeReader
+	commandStdout *io.PipeWriter
+	commandStderr *io.PipeWriter
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newFakeExecSession() *fakeExecSession {
+	session := &fakeExecSession{closed: make(chan struct{})}
+	session.commandStdin, session.stdin = io.Pipe()
+	session.stdout, session.commandStdout = io.Pipe()
+	session.stderr, session.commandStderr = io.Pipe()
+	return session
+}
+
+func (s *fakeExecSession) Stdin() io.WriteCloser {
+	return s.stdin
+}
+
+func (s *fakeExecSession) Stdout() io.Reader {
+	return s.stdout
+}
+
+func (s *fakeExecSession) Stderr() io.Reader {
+	return s.stderr
+}
+
+func (s *fakeExecSession) Resize(ctx context.Context, height uint, width uint) error {
+	return nil
+}
+
+func (s *fakeExecSession) Wait(ctx context.Context) (int, error) {
+	<-s.closed
+	return 0, nil
+}
+
+func (s *fakeExecSession) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+		s.stdin.CloseWithError(io.ErrClosedPipe)
+		s.stdout.CloseWithError(io.ErrClosedPipe)
+		s.stderr.CloseWithError(io.ErrClosedPipe)
+	})
+	return nil
+}
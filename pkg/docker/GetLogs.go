@@ -1,20 +1,21 @@
 package docker
 
 import (
+	"bufio"
 	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	OSexec "os/exec"
-
 	"github.com/containerd/containerd/log"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	trace "go.opentelemetry.io/otel/trace"
 
 	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
 )
 
 func handleError(span trace.Span, err error, statusCode int, start int64) {
@@ -23,7 +24,7 @@ func handleError(span trace.Span, err error, statusCode int, start int64) {
 	span.End()
 }
 
-// GetLogsHandler performs a Docker logs command and returns its manipulated output
+// GetLogsHandler retrieves the logs of a container inside the sandbox of the POD and returns their manipulated output
 func (h *SidecarHandler) GetLogsHandler(w http.ResponseWriter, r *http.Request) {
 	log.G(h.Ctx).Info("\u23F3 [LOGS CALL]: received get logs call")
 
@@ -35,7 +36,6 @@ func (h *SidecarHandler) GetLogsHandler(w http.ResponseWriter, r *http.Request)
 
 	var req commonIL.LogStruct
 	statusCode := http.StatusOK
-	currentTime := time.Now()
 
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -62,111 +62,195 @@ func (h *SidecarHandler) GetLogsHandler(w http.ResponseWriter, r *http.Request)
 
 	containerName := podNamespace + "-" + podUID + "-" + req.ContainerName
 
-	cmd := OSexec.Command("docker", "exec", podUID+"_dind", "docker", "ps", "-a", "--format", "{{.Names}}")
-	output, err := cmd.CombinedOutput()
+	if req.Opts.Follow {
+		log.G(h.Ctx).Info("\u2705 [LOGS CALL] Following the logs of container " + containerName)
+	}
+
+	logsReader, found, err := h.openLogs(r, req, containerName)
 	if err != nil {
 		log.G(h.Ctx).Error(err)
 		statusCode = http.StatusInternalServerError
 		w.WriteHeader(statusCode)
+		w.Write([]byte("Some errors occurred while retrieving container logs. Check Docker Sidecar's logs"))
 		handleError(span, err, statusCode, start)
 		return
 	}
 
-	lines := strings.Split(string(output), "\n")
-	found := false
-	for _, line := range lines {
-		if line == containerName {
-			found = true
-			break
-		}
-	}
 	if !found {
 		w.WriteHeader(statusCode)
-		w.Write([]byte("No logs available for container " + containerName + ". Container not found."))
+		if req.Opts.Previous {
+			w.Write([]byte("No logs available for container " + containerName + ". Previous terminated container not found."))
+		} else {
+			w.Write([]byte("No logs available for container " + containerName + ". Container not found."))
+		}
+		commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+		span.End()
 		return
 	}
 
-	//var cmd *OSexec.Cmd
-	if req.Opts.Timestamps {
-		cmd = OSexec.Command("docker", "exec", podUID+"_dind", "docker", "logs", "-t", containerName)
-	} else {
-		cmd = OSexec.Command("docker", "exec", podUID+"_dind", "docker", "logs", containerName)
+	writeLogs(w, r, containerName, logsReader, req.Opts)
+
+	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+	span.End()
+}
+
+// openLogs returns the logs of the container. The logs of a running container are read from the runtime, while the ones of a terminated
+// or removed container, and the ones of its previous execution, are read from the log archive. The boolean is false if no logs are available.
+func (h *SidecarHandler) openLogs(r *http.Request, req commonIL.LogStruct, containerName string) (io.ReadCloser, bool, error) {
+	if req.Opts.Previous {
+		logsReader, found, err := h.Logs.OpenLogs(req.Namespace, req.PodUID, req.ContainerName, true)
+		if !found || err != nil {
+			return nil, found, err
+		}
+		return filterArchivedLogs(logsReader, req.Opts), true, nil
+	}
+
+	var containerInfo containerruntime.ContainerInfo
+	sandbox, err := h.Sandboxes.Get(h.Ctx, req.PodUID)
+	if err == nil {
+		containerInfo, err = sandbox.Runtime.Inspect(h.Ctx, containerName)
+	}
+	if err != nil && !containerruntime.IsNotFound(err) {
+		return nil, false, err
+	}
+
+	if err == nil && containerInfo.State.Running {
+		// as in Kubernetes, only the logs of the current execution of the container are returned
+		options := logOptions(req.Opts)
+		if options.Since.Before(containerInfo.State.StartedAt) {
+			options.Since = containerInfo.State.StartedAt
+		}
+
+		// the stream is closed by the runtime when the client disconnects
+		logsReader, err := sandbox.Runtime.Logs(r.Context(), containerName, options)
+		return logsReader, err == nil, err
 	}
 
-	output, err = cmd.CombinedOutput()
+	notFound := err != nil
 
+	logsReader, found, err := h.Logs.OpenLogs(req.Namespace, req.PodUID, req.ContainerName, false)
 	if err != nil {
-		log.G(h.Ctx).Error(err)
-		statusCode = http.StatusInternalServerError
-		w.WriteHeader(statusCode)
-		handleError(span, err, statusCode, start)
-		return
+		return nil, false, err
+	} else if found {
+		return filterArchivedLogs(logsReader, req.Opts), true, nil
+	} else if notFound {
+		return nil, false, nil
 	}
 
-	var returnedLogs string
+	// the container has not been archived, e.g. it has never been started
+	logsReader, err = sandbox.Runtime.Logs(r.Context(), containerName, logOptions(req.Opts))
+	return logsReader, err == nil, err
+}
 
-	if req.Opts.Tail != 0 {
-		var lastLines []string
+// logOptions maps the options of a logs request to the ones of the runtime, which filters the logs itself.
+// As in Kubernetes, a zero Tail returns all the lines, and SinceSeconds takes precedence over SinceTime.
+func logOptions(opts commonIL.ContainerLogOpts) containerruntime.LogOptions {
+	options := containerruntime.LogOptions{Timestamps: opts.Timestamps, Follow: opts.Follow}
+	if opts.Tail > 0 {
+		options.Tail = strconv.Itoa(opts.Tail)
+	}
+	if opts.SinceSeconds > 0 {
+		options.Since = time.Now().Add(-time.Duration(opts.SinceSeconds) * time.Second)
+	} else if !opts.SinceTime.IsZero() {
+		options.Since = opts.SinceTime
+	}
+	return options
+}
 
-		splittedLines := strings.Split(string(output), "\n")
+// writeLogs streams the logs of the container to the client, without holding them in memory. When they are followed,
+// every chunk is flushed to the client as soon as it is produced, until the client disconnects or the container exits.
+func writeLogs(w http.ResponseWriter, r *http.Request, containerName string, logsReader io.ReadCloser, opts commonIL.ContainerLogOpts) {
+	defer logsReader.Close()
 
-		if req.Opts.Tail > len(splittedLines) {
-			lastLines = splittedLines
-		} else {
-			lastLines = splittedLines[len(splittedLines)-req.Opts.Tail-1:]
-		}
+	// as in Kubernetes, LimitBytes truncates the logs after their first bytes
+	var reader io.Reader = logsReader
+	if opts.LimitBytes > 0 {
+		reader = io.LimitReader(logsReader, int64(opts.LimitBytes))
+	}
 
-		for _, line := range lastLines {
-			returnedLogs += line + "\n"
-		}
-	} else if req.Opts.LimitBytes != 0 {
-		var lastBytes []byte
-		if req.Opts.LimitBytes > len(output) {
-			lastBytes = output
-		} else {
-			lastBytes = output[len(output)-req.Opts.LimitBytes-1:]
-		}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
 
-		returnedLogs = string(lastBytes)
-	} else {
-		returnedLogs = string(output)
+	var writer io.Writer = w
+	if flusher, ok := w.(http.Flusher); ok && opts.Follow {
+		flusher.Flush()
+		writer = flushWriter{writer: w, flusher: flusher}
 	}
 
-	if req.Opts.Timestamps && (req.Opts.SinceSeconds != 0 || !req.Opts.SinceTime.IsZero()) {
-		temp := returnedLogs
-		returnedLogs = ""
-		splittedLogs := strings.Split(temp, "\n")
-		timestampFormat := "2006-01-02T15:04:05.999999999Z"
-
-		for _, Log := range splittedLogs {
-			part := strings.SplitN(Log, " ", 2)
-			timestampString := part[0]
-			timestamp, err := time.Parse(timestampFormat, timestampString)
-			if err != nil {
-				continue
-			}
-			if req.Opts.SinceSeconds != 0 {
-				if currentTime.Sub(timestamp).Seconds() > float64(req.Opts.SinceSeconds) {
-					returnedLogs += Log + "\n"
+	_, err := io.Copy(writer, reader)
+	if err != nil && r.Context().Err() == nil {
+		log.G(r.Context()).Error("\u274C [LOGS CALL] The logs of container " + containerName + " have been interrupted: " + err.Error())
+	}
+}
+
+// filterArchivedLogs applies the options of a logs request to the archived logs, whose lines all start with their timestamp.
+// As the runtimes do, the last lines are selected before the ones written since the requested time.
+func filterArchivedLogs(logsReader io.ReadCloser, opts commonIL.ContainerLogOpts) io.ReadCloser {
+	options := logOptions(opts)
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		defer logsReader.Close()
+
+		write := func(line string) error {
+			timestamp, message, _ := strings.Cut(line, " ")
+			if !options.Since.IsZero() {
+				t, err := time.Parse(time.RFC3339Nano, timestamp)
+				if err == nil && t.Before(options.Since) {
+					return nil
 				}
-			} else {
-				if timestamp.Sub(req.Opts.SinceTime).Seconds() >= 0 {
-					returnedLogs += Log + "\n"
+			}
+			if !options.Timestamps {
+				line = message
+			}
+			_, err := io.WriteString(pipeWriter, line)
+			return err
+		}
+
+		reader := bufio.NewReader(logsReader)
+		var lastLines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				if opts.Tail > 0 {
+					lastLines = append(lastLines, line)
+					if len(lastLines) > opts.Tail {
+						lastLines = lastLines[1:]
+					}
+				} else if err := write(line); err != nil {
+					pipeWriter.CloseWithError(err)
+					return
 				}
 			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
 		}
-	}
 
-	if statusCode != http.StatusOK {
-		w.Write([]byte("Some errors occurred while checking container status. Check Docker Sidecar's logs"))
-	} else {
-		w.WriteHeader(statusCode)
-		w.Write([]byte(returnedLogs))
-	}
+		for _, line := range lastLines {
+			if err := write(line); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+		pipeWriter.Close()
+	}()
 
-	if err != nil {
-		span.SetAttributes(attribute.String("error", err.Error()))
-	}
-	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
-	span.End()
+	return pipeReader
+}
+
+// flushWriter sends every write to the client right away, as a chunk of the response
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.writer.Write(p)
+	f.flusher.Flush()
+	return n, err
 }
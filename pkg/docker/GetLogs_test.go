@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+)
+
+func TestLogOptions(t *testing.T) {
+	sinceTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		opts      commonIL.ContainerLogOpts
+		wantTail  string
+		wantSince time.Duration
+		// wantSinceTime is checked when wantSince is zero
+		wantSinceTime time.Time
+	}{
+		{name: "all the lines", opts: commonIL.ContainerLogOpts{}},
+		{name: "tail", opts: commonIL.ContainerLogOpts{Tail: 10}, wantTail: "10"},
+		{name: "since seconds", opts: commonIL.ContainerLogOpts{SinceSeconds: 60}, wantSince: time.Minute},
+		{name: "since time", opts: commonIL.ContainerLogOpts{SinceTime: sinceTime}, wantSinceTime: sinceTime},
+		{name: "since seconds before since time", opts: commonIL.ContainerLogOpts{SinceSeconds: 60, SinceTime: sinceTime}, wantSince: time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := logOptions(test.opts)
+			if options.Tail != test.wantTail {
+				t.Fatalf("tail: got %q, want %q", options.Tail, test.wantTail)
+			}
+			if test.wantSince > 0 {
+				if ago := time.Since(options.Since); ago < test.wantSince || ago > test.wantSince+time.Second {
+					t.Fatalf("since: got %s ago, want %s", ago, test.wantSince)
+				}
+			} else if !options.Since.Equal(test.wantSinceTime) {
+				t.Fatalf("since: got %s, want %s", options.Since, test.wantSinceTime)
+			}
+		})
+	}
+
+	options := logOptions(commonIL.ContainerLogOpts{Timestamps: true, Follow: true})
+	if !options.Timestamps || !options.Follow {
+		t.Fatalf("got %+v, want the timestamps followed", options)
+	}
+}
+
+func TestFilterArchivedLogs(t *testing.T) {
+	logs := "2024-05-01T10:00:00Z first\n" +
+		"2024-05-01T10:01:00Z second\n" +
+		"2024-05-01T10:02:00Z third\n" +
+		"2024-05-01T10:03:00Z fourth\n"
+
+	tests := []struct {
+		name string
+		opts commonIL.ContainerLogOpts
+		want string
+	}{
+		{name: "all the lines", opts: commonIL.ContainerLogOpts{}, want: "first\nsecond\nthird\nfourth\n"},
+		{name: "timestamps", opts: commonIL.ContainerLogOpts{Timestamps: true, Tail: 1}, want: "2024-05-01T10:03:00Z fourth\n"},
+		{name: "tail", opts: commonIL.ContainerLogOpts{Tail: 2}, want: "third\nfourth\n"},
+		{name: "tail longer than the logs", opts: commonIL.ContainerLogOpts{Tail: 10}, want: "first\nsecond\nthird\nfourth\n"},
+		{name: "since", opts: commonIL.ContainerLogOpts{SinceTime: time.Date(2024, 5, 1, 10, 1, 30, 0, time.UTC)}, want: "third\nfourth\n"},
+		// the last lines are selected before the ones written since the requested time
+		{name: "tail then since", opts: commonIL.ContainerLogOpts{Tail: 3, SinceTime: time.Date(2024, 5, 1, 10, 2, 30, 0, time.UTC)}, want: "fourth\n"},
+		{name: "since after the logs", opts: commonIL.ContainerLogOpts{Tail: 2, SinceTime: time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)}, want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := filterArchivedLogs(io.NopCloser(strings.NewReader(logs)), test.opts)
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+// terminationGracePeriod returns the time given to the containers of the POD to stop
+func terminationGracePeriod(pod v1.Pod) time.Duration {
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		return time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	return v1.DefaultTerminationGracePeriodSeconds * time.Second
+}
+
+// runLifecycleHandler executes a postStart or preStop hook against a container of the POD
+func runLifecycleHandler(ctx context.Context, sandbox Sandbox, containerName string, container v1.Container, handler *v1.LifecycleHandler) error {
+	switch {
+	case handler.Exec != nil:
+		return execAction(ctx, sandbox, containerName, handler.Exec.Command)
+
+	case handler.HTTPGet != nil:
+		containerInfo, err := sandbox.Runtime.Inspect(ctx, containerName)
+		if err != nil {
+			return err
+		}
+		port, err := resolvePort(handler.HTTPGet.Port, container)
+		if err != nil {
+			return err
+		}
+		host := handler.HTTPGet.Host
+		if host == "" {
+			host = containerIP(containerInfo)
+		}
+		return httpGetAction(ctx, sandbox, host, port, handler.HTTPGet)
+
+	case handler.Sleep != nil:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(handler.Sleep.Seconds) * time.Second):
+			return nil
+		}
+
+	default:
+		return errors.New("the lifecycle hook has no handler")
+	}
+}
+
+// postStart runs the postStart hook of a container that has just been started.
+// If the hook fails, the container is stopped and then restarted according to the restart policy of the POD.
+func (p *PodSupervisor) postStart(containerName string) {
+	container, ok := p.containers[containerName]
+	if !ok || container.spec.Lifecycle == nil || container.spec.Lifecycle.PostStart == nil {
+		return
+	}
+
+	err := runLifecycleHandler(p.Ctx, p.Sandbox, containerName, container.spec, container.spec.Lifecycle.PostStart)
+	if err == nil {
+		return
+	}
+
+	message := "PostStartHookError: " + err.Error()
+	log.G(p.Ctx).Error("\u274C [POD FLOW] " + message + ", container " + containerName)
+
+	p.mu.Lock()
+	container.stopMessage = message
+	p.mu.Unlock()
+
+	err = stopContainer(p.Ctx, p.Sandbox, containerName, container.spec, p.terminationGracePeriod, p)
+	if err != nil {
+		log.G(p.Ctx).Error("\u274C [POD FLOW] Error stopping container " + containerName + ": " + err.Error())
+	}
+}
+
+// stopContainer runs the preStop hook of a running container and then stops it, the whole within the grace period.
+// A container whose grace period has been used up by its hook is killed right away. The failure of the hook is recorded by the supervisor of the POD, if any.
+func stopContainer(ctx context.Context, sandbox Sandbox, containerName string, container v1.Container, gracePeriod time.Duration, supervisor *PodSupervisor) error {
+	start := time.Now()
+
+	if container.Lifecycle != nil && container.Lifecycle.PreStop != nil {
+		err := runPreStopHook(ctx, sandbox, containerName, container, gracePeriod)
+		if err != nil {
+			log.G(ctx).Error("\u274C [DELETE CALL] PreStopHookError: " + err.Error() + ", container " + containerName)
+			supervisor.preStopFailed(containerName, err)
+		}
+	}
+
+	return sandbox.Runtime.Stop(ctx, containerName, max(gracePeriod-time.Since(start), 0))
+}
+
+// runPreStopHook runs the preStop hook of the container, if it is running, for at most the grace period
+func runPreStopHook(ctx context.Context, sandbox Sandbox, containerName string, container v1.Container, gracePeriod time.Duration) error {
+	containerInfo, err := sandbox.Runtime.Inspect(ctx, containerName)
+	if err != nil || !containerInfo.State.Running {
+		return nil
+	}
+
+	hookCtx, cancel := context.WithTimeout(ctx, gracePeriod)
+	defer cancel()
+
+	log.G(ctx).Info("\u2705 [DELETE CALL] Running the preStop hook of container " + containerName)
+
+	return runLifecycleHandler(hookCtx, sandbox, containerName, container, container.Lifecycle.PreStop)
+}
+
+// preStopFailed records the failure of the preStop hook of the container, which is reported as the reason of its termination.
+// The container is stopped anyway.
+func (p *PodSupervisor) preStopFailed(containerName string, err error) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if container, ok := p.containers[containerName]; ok {
+		container.stopReason = "PreStopHookError"
+		container.stopMessage = err.Error()
+	}
+}
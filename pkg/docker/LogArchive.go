@@ -0,0 +1,500 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+const (
+	// DefaultLogMaxSizeMB is the size of a log file rotated, unless configured otherwise
+	DefaultLogMaxSizeMB = 10
+	// DefaultLogMaxFiles is the number of log files kept for every execution of a container, unless configured otherwise
+	DefaultLogMaxFiles = 5
+
+	// logArchiveFolder is the folder of the POD directory holding the logs of its containers
+	logArchiveFolder = "logs"
+	// logDeletedMarker is written in the logs of a deleted POD, its modification time is the deletion of the POD
+	logDeletedMarker = ".deleted"
+	// logExpirationPeriod is the interval between two removals of the expired logs
+	logExpirationPeriod = 10 * time.Minute
+	// logDrainTimeout is the time given to the archivers of a deleted POD to write the last lines of its containers
+	logDrainTimeout = 5 * time.Second
+)
+
+// LogArchive copies the logs of the containers to files under the directories of their PODs, so that they outlive the containers and their sandboxes.
+// Every execution of a container is written to its own files, rotated by size: only the current and the previous executions are kept.
+// The logs of a deleted POD are kept for the retention time, and then removed with the directory of the POD.
+type LogArchive struct {
+	Ctx context.Context
+	// Status holds the state of the containers, from which the archivers learn of their restarts
+	Status *StatusCache
+	// Root is the folder holding the directories of the PODs
+	Root      string
+	MaxSize   int64
+	MaxFiles  int
+	Retention time.Duration
+
+	mu sync.Mutex
+	// pods holds the archivers of the containers of every POD, by POD UID
+	pods map[string]*podLogArchivers
+}
+
+// podLogArchivers are the archivers of the containers of a POD
+type podLogArchivers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stopping is closed once the containers of the POD have been stopped, the archivers return as soon as they have copied the last lines
+	stopping chan struct{}
+	wg       sync.WaitGroup
+	// containers are the names in the runtime of the containers being archived
+	containers map[string]bool
+}
+
+// NewLogArchive archives the logs under the root folder, and removes the logs of the deleted PODs once expired, until the context is done.
+// A retention of 0 or less removes the logs of a POD with its directory.
+func NewLogArchive(ctx context.Context, status *StatusCache, root string, maxSizeMB int, maxFiles int, retention time.Duration) *LogArchive {
+	if maxSizeMB <= 0 {
+		maxSizeMB = DefaultLogMaxSizeMB
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultLogMaxFiles
+	}
+
+	a := &LogArchive{
+		Ctx:       ctx,
+		Status:    status,
+		Root:      root,
+		MaxSize:   int64(maxSizeMB) * 1024 * 1024,
+		MaxFiles:  maxFiles,
+		Retention: retention,
+		pods:      make(map[string]*podLogArchivers),
+	}
+
+	go a.expire()
+
+	return a
+}
+
+// containerLogFolder returns the folder holding the logs of a container of the POD
+func (a *LogArchive) containerLogFolder(podNamespace string, podUID string, containerName string) string {
+	return filepath.Join(a.Root, podNamespace+"-"+podUID, logArchiveFolder, containerName)
+}
+
+// Archive copies the logs of a container of the POD, from now on and across its restarts, until the container is removed or the POD is deleted.
+// The container is identified by its name in the runtime, while its logs are stored under its name in the POD spec. Archiving a container twice has no effect.
+func (a *LogArchive) Archive(sandbox Sandbox, podNamespace string, containerName string, specName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pod, ok := a.pods[sandbox.PodUID]
+	if !ok {
+		ctx, cancel := context.WithCancel(a.Ctx)
+		pod = &podLogArchivers{ctx: ctx, cancel: cancel, stopping: make(chan struct{}), containers: make(map[string]bool)}
+		a.pods[sandbox.PodUID] = pod
+
+		// a POD created again after its deletion keeps its logs
+		os.Remove(filepath.Join(a.Root, podNamespace+"-"+sandbox.PodUID, logArchiveFolder, logDeletedMarker))
+	}
+	if pod.containers[containerName] {
+		return
+	}
+	pod.containers[containerName] = true
+
+	folder := a.containerLogFolder(podNamespace, sandbox.PodUID, specName)
+
+	pod.wg.Add(1)
+	go a.archive(pod, sandbox, containerName, folder)
+}
+
+// Stop waits for the archivers of the POD, whose containers have been stopped, to copy the last lines of their logs
+func (a *LogArchive) Stop(podUID string) {
+	a.mu.Lock()
+	pod, ok := a.pods[podUID]
+	delete(a.pods, podUID)
+	a.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	close(pod.stopping)
+
+	done := make(chan struct{})
+	go func() {
+		pod.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(logDrainTimeout):
+		log.G(a.Ctx).Error("\u274C [LOGS ARCHIVE] The logs of POD " + podUID + " have not been fully archived within " + logDrainTimeout.String())
+	}
+
+	pod.cancel()
+	<-done
+}
+
+// RemovePodDirectory removes the directory of a deleted POD. The logs of its containers are kept until the retention time has expired.
+func (a *LogArchive) RemovePodDirectory(podDirectoryPath string) error {
+	logFolder := filepath.Join(podDirectoryPath, logArchiveFolder)
+	if _, err := os.Stat(logFolder); a.Retention <= 0 || err != nil {
+		return os.RemoveAll(podDirectoryPath)
+	}
+
+	entries, err := os.ReadDir(podDirectoryPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == logArchiveFolder {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(podDirectoryPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	log.G(a.Ctx).Info("\u2705 [LOGS ARCHIVE] Keeping the logs in " + logFolder + " for " + a.Retention.String())
+
+	return os.WriteFile(filepath.Join(logFolder, logDeletedMarker), nil, 0644)
+}
+
+// expire periodically removes the directories of the deleted PODs whose logs have been kept for the retention time
+func (a *LogArchive) expire() {
+	ticker := time.NewTicker(logExpirationPeriod)
+	defer ticker.Stop()
+
+	for {
+		entries, _ := os.ReadDir(a.Root)
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			podDirectoryPath := filepath.Join(a.Root, entry.Name())
+			marker, err := os.Stat(filepath.Join(podDirectoryPath, logArchiveFolder, logDeletedMarker))
+			if err != nil || time.Since(marker.ModTime()) < a.Retention {
+				continue
+			}
+
+			log.G(a.Ctx).Info("\u2705 [LOGS ARCHIVE] Removing the expired logs in " + podDirectoryPath)
+			err = os.RemoveAll(podDirectoryPath)
+			if err != nil {
+				log.G(a.Ctx).Error("\u274C [LOGS ARCHIVE] Error removing the expired logs in " + podDirectoryPath + ": " + err.Error())
+			}
+		}
+
+		select {
+		case <-a.Ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archive copies the logs of every execution of the container, until the container is removed or the POD is deleted
+func (a *LogArchive) archive(pod *podLogArchivers, sandbox Sandbox, containerName string, folder string) {
+	defer pod.wg.Done()
+
+	// archived is the start of the last execution whose logs have been entirely copied
+	var archived time.Time
+
+	for {
+		containerInfo, err := a.Status.Inspect(sandbox, containerName)
+		if containerruntime.IsNotFound(err) || pod.ctx.Err() != nil {
+			return
+		}
+
+		if err == nil && !containerInfo.State.StartedAt.IsZero() && !containerInfo.State.StartedAt.Equal(archived) {
+			startedAt := containerInfo.State.StartedAt
+
+			// the stream of the logs ends when the container terminates
+			err = a.copyExecution(pod.ctx, sandbox, containerName, folder, startedAt)
+			if err != nil && pod.ctx.Err() == nil {
+				log.G(a.Ctx).Error("\u274C [LOGS ARCHIVE] Error archiving the logs of container " + containerName + ": " + err.Error())
+			}
+
+			// the state is read from the runtime, as the event of the termination that has ended the stream may not have been received yet
+			containerInfo, err = sandbox.Runtime.Inspect(pod.ctx, containerName)
+			if err == nil && (!containerInfo.State.Running || !containerInfo.State.StartedAt.Equal(startedAt)) {
+				archived = startedAt
+			}
+			// otherwise the stream has been interrupted while the container is running, the copy is resumed
+			continue
+		}
+
+		select {
+		case <-pod.ctx.Done():
+			return
+		case <-pod.stopping:
+			if err == nil && !containerInfo.State.Running {
+				return
+			}
+		case <-time.After(supervisorPeriod):
+		}
+	}
+}
+
+// copyExecution appends the logs of the execution of the container started at startedAt to its files, resuming after the last line already copied
+func (a *LogArchive) copyExecution(ctx context.Context, sandbox Sandbox, containerName string, folder string, startedAt time.Time) error {
+	err := os.MkdirAll(folder, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(folder, executionLogName(startedAt))
+
+	since := startedAt
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// a new execution, the one before it becomes the previous execution and the older ones are dropped
+		executions := logExecutions(folder)
+		for _, execution := range executions[:max(len(executions)-1, 0)] {
+			removeExecution(folder, execution)
+		}
+	} else if lastTimestamp, ok := lastLogTimestamp(path); ok {
+		since = lastTimestamp.Add(time.Nanosecond)
+	}
+
+	logsReader, err := sandbox.Runtime.Logs(ctx, containerName, containerruntime.LogOptions{Timestamps: true, Follow: true, Since: since})
+	if err != nil {
+		return err
+	}
+	defer logsReader.Close()
+
+	file, err := openRotatingLogFile(path, a.MaxSize, a.MaxFiles)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(logsReader)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if writeErr := file.WriteLine(line); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// OpenLogs returns the archived logs of a container of the POD, of its current execution or of the previous one.
+// The logs are returned with the timestamp of every line, the boolean is false if no logs have been archived.
+func (a *LogArchive) OpenLogs(podNamespace string, podUID string, containerName string, previous bool) (io.ReadCloser, bool, error) {
+	folder := a.containerLogFolder(podNamespace, podUID, containerName)
+
+	executions := logExecutions(folder)
+	if previous {
+		if len(executions) < 2 {
+			return nil, false, nil
+		}
+		executions = executions[:len(executions)-1]
+	}
+	if len(executions) == 0 {
+		return nil, false, nil
+	}
+
+	// the rotated files, from the oldest one, and then the file being written
+	path := filepath.Join(folder, executions[len(executions)-1])
+	paths := []string{}
+	for i := a.MaxFiles; i >= 1; i-- {
+		paths = append(paths, path+"."+strconv.Itoa(i))
+	}
+	paths = append(paths, path)
+
+	logs := &archivedLogFiles{}
+	readers := []io.Reader{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			logs.Close()
+			return nil, false, err
+		}
+		logs.files = append(logs.files, file)
+		readers = append(readers, file)
+	}
+	logs.Reader = io.MultiReader(readers...)
+
+	return logs, true, nil
+}
+
+// LogsSize returns the size of the archived logs of a container of the POD, all its executions and rotated files included
+func (a *LogArchive) LogsSize(podNamespace string, podUID string, containerName string) uint64 {
+	entries, _ := os.ReadDir(a.containerLogFolder(podNamespace, podUID, containerName))
+
+	var size uint64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+	}
+	return size
+}
+
+// executionLogName is the name of the file holding the logs of the execution of a container started at startedAt, the names sort as the executions
+func executionLogName(startedAt time.Time) string {
+	return fmt.Sprintf("%020d.log", startedAt.UnixNano())
+}
+
+// logExecutions returns the names of the log files of the executions archived in the folder, from the oldest one
+func logExecutions(folder string) []string {
+	entries, _ := os.ReadDir(folder)
+
+	var executions []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".log") {
+			executions = append(executions, entry.Name())
+		}
+	}
+	sort.Strings(executions)
+
+	return executions
+}
+
+// removeExecution removes the log files of an execution, the rotated ones included
+func removeExecution(folder string, execution string) {
+	paths, _ := filepath.Glob(filepath.Join(folder, execution+".*"))
+	for _, path := range append(paths, filepath.Join(folder, execution)) {
+		os.Remove(path)
+	}
+}
+
+// lastLogTimestamp returns the timestamp of the last line copied to the log file of an execution, or to its last rotated file
+func lastLogTimestamp(path string) (time.Time, bool) {
+	for _, path := range []string{path, path + ".1"} {
+		file, err := os.Open(path)
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		// the lines are expected to be shorter than 64KiB, a longer one is copied again
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return time.Time{}, false
+		}
+		offset := max(info.Size()-64*1024, 0)
+		content := make([]byte, info.Size()-offset)
+		_, err = file.ReadAt(content, offset)
+		file.Close()
+		if err != nil && err != io.EOF {
+			return time.Time{}, false
+		}
+
+		lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		for i := len(lines) - 1; i >= 0; i-- {
+			timestamp, _, _ := strings.Cut(lines[i], " ")
+			if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// rotatingLogFile writes the lines of the logs to a file, which is rotated once it has reached its maximum size
+type rotatingLogFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingLogFile(path string, maxSize int64, maxFiles int) (*rotatingLogFile, error) {
+	f := &rotatingLogFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	return f, f.open()
+}
+
+func (f *rotatingLogFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// WriteLine appends a line to the file, rotating it first if the line does not fit
+func (f *rotatingLogFile) WriteLine(line []byte) error {
+	if f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+// rotate renames the file to path.1, after shifting the rotated files and dropping the oldest one, and starts a new file
+func (f *rotatingLogFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	os.Remove(f.path + "." + strconv.Itoa(f.maxFiles-1))
+	for i := f.maxFiles - 2; i >= 1; i-- {
+		os.Rename(f.path+"."+strconv.Itoa(i), f.path+"."+strconv.Itoa(i+1))
+	}
+	if f.maxFiles > 1 {
+		err = os.Rename(f.path, f.path+".1")
+	} else {
+		err = os.Remove(f.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+func (f *rotatingLogFile) Close() error {
+	return f.file.Close()
+}
+
+// archivedLogFiles reads the log files one after the other
+type archivedLogFiles struct {
+	io.Reader
+	files []*os.File
+}
+
+func (l *archivedLogFiles) Close() error {
+	for _, file := range l.files {
+		file.Close()
+	}
+	return nil
+}
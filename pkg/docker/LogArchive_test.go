@@ -0,0 +1,178 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingLogFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxFiles int
+		lines    []string
+		// want holds the content of the file, then of its rotated files from the most recent one
+		want []string
+	}{
+		{name: "no rotation", maxFiles: 3, lines: []string{"aaaa\n", "bbbb\n"}, want: []string{"aaaa\nbbbb\n"}},
+		{name: "rotated", maxFiles: 3, lines: []string{"aaaa\n", "bbbb\n", "cccc\n"}, want: []string{"cccc\n", "aaaa\nbbbb\n"}},
+		{name: "oldest file dropped", maxFiles: 3, lines: []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"}, want: []string{"gggg\n", "eeee\nffff\n", "cccc\ndddd\n"}},
+		{name: "single file", maxFiles: 1, lines: []string{"aaaa\n", "bbbb\n", "cccc\n"}, want: []string{"cccc\n"}},
+		{name: "line longer than the maximum size", maxFiles: 3, lines: []string{"aaaaaaaaaaaaaaaa\n", "bbbb\n"}, want: []string{"bbbb\n", "aaaaaaaaaaaaaaaa\n"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "execution.log")
+			file, err := openRotatingLogFile(path, 10, test.maxFiles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range test.lines {
+				if err := file.WriteLine([]byte(line)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			file.Close()
+
+			var got []string
+			for i := 0; ; i++ {
+				filePath := path
+				if i > 0 {
+					filePath += "." + strconv.Itoa(i)
+				}
+				content, err := os.ReadFile(filePath)
+				if os.IsNotExist(err) {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(content))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRotatingLogFileReopened(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "execution.log")
+	file, err := openRotatingLogFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteLine([]byte("aaaa\n"))
+	file.Close()
+
+	// the size of the existing file is taken into account after a restart of the sidecar
+	file, err = openRotatingLogFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteLine([]byte("bbbbbbbb\n"))
+	file.Close()
+
+	if content, _ := os.ReadFile(path + ".1"); string(content) != "aaaa\n" {
+		t.Fatalf("got rotated file %q, want the line written before the restart", content)
+	}
+}
+
+// newTestPodDirectory creates the directory of a POD with a data file and the archived logs of a container
+func newTestPodDirectory(t *testing.T, root string, name string) string {
+	t.Helper()
+	podDirectoryPath := filepath.Join(root, name)
+	logFolder := filepath.Join(podDirectoryPath, logArchiveFolder, "container")
+	if err := os.MkdirAll(logFolder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(podDirectoryPath, "configmap"), filepath.Join(logFolder, "execution.log")} {
+		if err := os.WriteFile(path, []byte("content\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return podDirectoryPath
+}
+
+func TestRemovePodDirectory(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention time.Duration
+		wantLogs  bool
+	}{
+		{name: "retention", retention: time.Hour, wantLogs: true},
+		{name: "retention disabled", retention: 0},
+		{name: "negative retention", retention: -time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			podDirectoryPath := newTestPodDirectory(t, root, "ns-pod-uid")
+			archive := &LogArchive{Ctx: context.Background(), Root: root, Retention: test.retention}
+
+			if err := archive.RemovePodDirectory(podDirectoryPath); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, _ := os.ReadDir(podDirectoryPath)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			if !test.wantLogs {
+				if len(names) != 0 {
+					t.Fatalf("got %v, want the directory removed", names)
+				}
+				return
+			}
+			if !reflect.DeepEqual(names, []string{logArchiveFolder}) {
+				t.Fatalf("got %v, want only the logs kept", names)
+			}
+			if _, err := os.Stat(filepath.Join(podDirectoryPath, logArchiveFolder, logDeletedMarker)); err != nil {
+				t.Fatalf("the deletion of the POD has not been marked: %v", err)
+			}
+		})
+	}
+}
+
+func TestLogArchiveExpire(t *testing.T) {
+	root := t.TempDir()
+	expired := newTestPodDirectory(t, root, "ns-expired")
+	kept := newTestPodDirectory(t, root, "ns-kept")
+	running := newTestPodDirectory(t, root, "ns-running")
+
+	archive := &LogArchive{Ctx: context.Background(), Root: root, Retention: time.Hour}
+	for _, podDirectoryPath := range []string{expired, kept} {
+		if err := archive.RemovePodDirectory(podDirectoryPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deletedAt := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(expired, logArchiveFolder, logDeletedMarker), deletedAt, deletedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	NewLogArchive(ctx, nil, root, 0, 0, time.Hour)
+
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(expired); os.IsNotExist(err) {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the expired logs have not been removed")
+		}
+	}
+	for _, podDirectoryPath := range []string{kept, running} {
+		if _, err := os.Stat(filepath.Join(podDirectoryPath, logArchiveFolder, "container", "execution.log")); err != nil {
+			t.Fatalf("the logs of %s have been removed: %v", strings.TrimPrefix(podDirectoryPath, root), err)
+		}
+	}
+}
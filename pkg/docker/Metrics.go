@@ -0,0 +1,267 @@
+package docker
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	v1 "k8s.io/api/core/v1"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/dindmanager"
+)
+
+// metricsNamespace prefixes the names of all the metrics of the sidecar
+const metricsNamespace = "interlink_docker_plugin"
+
+// the stages of the creation of a POD, whose durations are measured
+const (
+	// createStageQueue is the time a POD waits in the create queue for a worker
+	createStageQueue = "queue"
+	// createStagePrepare is the preparation of the containers, e.g. their files and accelerators
+	createStagePrepare = "prepare"
+	// createStageSandbox is the creation of the sandbox, e.g. taking or building a DIND container
+	createStageSandbox = "sandbox"
+)
+
+// Metrics holds the metrics of the sidecar, exposed in the Prometheus format. The requests and the creations of the PODs are measured
+// as they happen, while the create queue, the DIND containers, the accelerators and the phases of the PODs are read at every scrape.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests            *prometheus.CounterVec
+	requestDuration     *prometheus.HistogramVec
+	createStageDuration *prometheus.HistogramVec
+
+	mu sync.Mutex
+	// podPhases holds the phase of the PODs as last reported by a status request, by POD UID
+	podPhases map[string]v1.PodPhase
+}
+
+// NewMetrics returns the metrics of the sidecar, in a registry of their own along with the metrics of the Go runtime and of the process
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of requests served, by handler and HTTP status code.",
+		}, []string{"handler", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the requests, by handler. The streaming requests last as long as their streams.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"handler"}),
+		createStageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "create_stage_duration_seconds",
+			Help:      "Duration of the stages of the creation of the PODs: queue, prepare and sandbox.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+		}, []string{"stage"}),
+		podPhases: make(map[string]v1.PodPhase),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.createStageDuration,
+	)
+
+	return m
+}
+
+// Register adds the state of the sidecar read at every scrape: its create queue, its accelerators and the phases of its PODs,
+// and the pool of DIND containers if the DIND manager is not nil
+func (m *Metrics) Register(h *SidecarHandler, dindManager dindmanager.DindManagerInterface) {
+	m.registry.MustRegister(newSidecarCollector(h, dindManager, m))
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Instrument counts the requests served by the handler and measures their latency, under the given name
+func (m *Metrics) Instrument(name string, handler http.HandlerFunc) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerDuration(m.requestDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(labels), handler))
+}
+
+// ObserveCreateStage records the duration of a stage of the creation of a POD, started at the given time
+func (m *Metrics) ObserveCreateStage(stage string, start time.Time) {
+	m.createStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// SetPodPhase records the phase of the POD derived from its status
+func (m *Metrics) SetPodPhase(pod v1.Pod, podStatus commonIL.PodStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.podPhases[string(pod.UID)] = podPhase(pod, podStatus)
+}
+
+// ForgetPod drops the phase of a deleted POD
+func (m *Metrics) ForgetPod(podUID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.podPhases, podUID)
+}
+
+// countPodPhases returns the number of PODs in each phase, all the phases being present
+func (m *Metrics) countPodPhases() map[v1.PodPhase]int {
+	counts := map[v1.PodPhase]int{v1.PodPending: 0, v1.PodRunning: 0, v1.PodSucceeded: 0, v1.PodFailed: 0, v1.PodUnknown: 0}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, phase := range m.podPhases {
+		counts[phase]++
+	}
+	return counts
+}
+
+// podPhase derives the phase of the POD from the status of its containers, as the kubelet does: the POD is pending until its init containers have completed
+// and its containers have started, and it ends up succeeded or failed once all its containers have terminated and are not going to be restarted
+func podPhase(pod v1.Pod, podStatus commonIL.PodStatus) v1.PodPhase {
+	sidecars := make(map[string]bool)
+	for _, container := range pod.Spec.InitContainers {
+		sidecars[container.Name] = isSidecar(container)
+	}
+
+	for _, status := range podStatus.InitContainers {
+		switch {
+		case status.State.Terminated != nil && status.State.Terminated.ExitCode == 0:
+		case status.State.Terminated != nil && pod.Spec.RestartPolicy == v1.RestartPolicyNever && !sidecars[status.Name]:
+			return v1.PodFailed
+		case status.State.Running != nil && sidecars[status.Name]:
+		default:
+			return v1.PodPending
+		}
+	}
+
+	running, failed := 0, 0
+	for _, status := range podStatus.Containers {
+		switch {
+		case status.State.Running != nil:
+			running++
+		case status.State.Terminated != nil && status.State.Terminated.ExitCode != 0:
+			failed++
+		case status.State.Terminated != nil:
+		default:
+			return v1.PodPending
+		}
+	}
+
+	switch {
+	case running > 0:
+		return v1.PodRunning
+	case len(podStatus.Containers) == 0:
+		return v1.PodUnknown
+	case pod.Spec.RestartPolicy == v1.RestartPolicyAlways || pod.Spec.RestartPolicy == "":
+		// the terminated containers are going to be restarted
+		return v1.PodRunning
+	case failed > 0 && pod.Spec.RestartPolicy == v1.RestartPolicyOnFailure:
+		return v1.PodRunning
+	case failed > 0:
+		return v1.PodFailed
+	default:
+		return v1.PodSucceeded
+	}
+}
+
+// sidecarCollector reads the state of the sidecar at every scrape
+type sidecarCollector struct {
+	handler     *SidecarHandler
+	dindManager dindmanager.DindManagerInterface
+	metrics     *Metrics
+
+	createQueueDepth    *prometheus.Desc
+	createQueueCapacity *prometheus.Desc
+	createQueueCreating *prometheus.Desc
+	createQueueWorkers  *prometheus.Desc
+	dindContainers      *prometheus.Desc
+	dindBuildFailures   *prometheus.Desc
+	gpuAllocated        *prometheus.Desc
+	fpgaAllocated       *prometheus.Desc
+	pods                *prometheus.Desc
+}
+
+func newSidecarCollector(h *SidecarHandler, dindManager dindmanager.DindManagerInterface, m *Metrics) *sidecarCollector {
+	desc := func(name string, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, labels, nil)
+	}
+
+	return &sidecarCollector{
+		handler:             h,
+		dindManager:         dindManager,
+		metrics:             m,
+		createQueueDepth:    desc("create_queue_depth", "Number of PODs waiting for a worker in the create queue."),
+		createQueueCapacity: desc("create_queue_capacity", "Number of PODs the create queue can hold."),
+		createQueueCreating: desc("create_queue_creating", "Number of PODs being created."),
+		createQueueWorkers:  desc("create_queue_workers", "Number of PODs created at the same time."),
+		dindContainers:      desc("dind_containers", "Number of DIND containers of the pool, by state: available or in_use.", "state"),
+		dindBuildFailures:   desc("dind_build_failures_total", "Number of builds of DIND containers that have failed."),
+		gpuAllocated:        desc("gpu_allocated", "Whether the GPU is assigned to a container.", "uuid", "index", "name"),
+		fpgaAllocated:       desc("fpga_allocated", "Whether the FPGA is assigned to a container.", "bdf", "index"),
+		pods:                desc("pods", "Number of PODs by phase, as last reported by a status request.", "phase"),
+	}
+}
+
+func (c *sidecarCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.createQueueDepth
+	ch <- c.createQueueCapacity
+	ch <- c.createQueueCreating
+	ch <- c.createQueueWorkers
+	ch <- c.dindContainers
+	ch <- c.dindBuildFailures
+	ch <- c.gpuAllocated
+	ch <- c.fpgaAllocated
+	ch <- c.pods
+}
+
+func (c *sidecarCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.handler.CreateQueue != nil {
+		stats := c.handler.CreateQueue.Stats()
+		ch <- prometheus.MustNewConstMetric(c.createQueueDepth, prometheus.GaugeValue, float64(stats.Depth))
+		ch <- prometheus.MustNewConstMetric(c.createQueueCapacity, prometheus.GaugeValue, float64(stats.Capacity))
+		ch <- prometheus.MustNewConstMetric(c.createQueueCreating, prometheus.GaugeValue, float64(stats.Creating))
+		ch <- prometheus.MustNewConstMetric(c.createQueueWorkers, prometheus.GaugeValue, float64(stats.Workers))
+	}
+
+	if c.dindManager != nil {
+		stats := c.dindManager.Stats()
+		ch <- prometheus.MustNewConstMetric(c.dindContainers, prometheus.GaugeValue, float64(stats.Available), "available")
+		ch <- prometheus.MustNewConstMetric(c.dindContainers, prometheus.GaugeValue, float64(stats.InUse), "in_use")
+		ch <- prometheus.MustNewConstMetric(c.dindBuildFailures, prometheus.CounterValue, float64(stats.BuildFailures))
+	}
+
+	if c.handler.GpuManager != nil {
+		for _, gpu := range c.handler.GpuManager.GetGPUSpecsList() {
+			ch <- prometheus.MustNewConstMetric(c.gpuAllocated, prometheus.GaugeValue, allocated(gpu.Available), gpu.UUID, strconv.Itoa(gpu.Index), gpu.Name)
+		}
+	}
+
+	if c.handler.FPGAManager != nil {
+		for _, fpga := range c.handler.FPGAManager.GetFPGASpecsList() {
+			ch <- prometheus.MustNewConstMetric(c.fpgaAllocated, prometheus.GaugeValue, allocated(fpga.Available), fpga.BDF, strconv.Itoa(fpga.Index))
+		}
+	}
+
+	for phase, count := range c.metrics.countPodPhases() {
+		ch <- prometheus.MustNewConstMetric(c.pods, prometheus.GaugeValue, float64(count), string(phase))
+	}
+}
+
+// allocated returns 1 for a device assigned to a container, 0 for an available one
+func allocated(available bool) float64 {
+	if available {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,149 @@
+package docker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	trace "go.opentelemetry.io/otel/trace"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+)
+
+// PlanHandler returns the execution plan of the PODs of a create request, without creating them.
+// The plan is built by the same functions used by CreateHandler, but no sandbox is created and no accelerator is assigned.
+// The files mounted by the containers are written to a temporary folder, which is removed before returning, so that the files of a running POD are never touched.
+func (h *SidecarHandler) PlanHandler(w http.ResponseWriter, r *http.Request) {
+	log.G(h.Ctx).Info("\u23F3 [PLAN CALL] Received plan call")
+
+	start := time.Now().UnixMicro()
+	tracer := otel.Tracer("interlink-API")
+	_, span := tracer.Start(h.Ctx, "Plan", trace.WithAttributes(
+		attribute.Int64("start.timestamp", start),
+	))
+	defer span.End()
+
+	statusCode := http.StatusOK
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(statusCode)
+		w.Write([]byte("Some errors occurred while reading the plan request. Check Docker Sidecar's logs"))
+		commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+		return
+	}
+
+	var req []commonIL.RetrievedPodData
+	err = json.Unmarshal(bodyBytes, &req)
+	if err != nil {
+		statusCode = http.StatusBadRequest
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(statusCode)
+		w.Write([]byte("Some errors occurred while unmarshalling the plan request. Check Docker Sidecar's logs"))
+		commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+		return
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(statusCode)
+		commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+		return
+	}
+
+	// the plan is prepared by a copy of the handler writing its files to a temporary data root folder
+	planHandler := *h
+	planRootFolder := h.Config.DataRootFolder + ".plan-" + uuid.New().String() + "/"
+	planHandler.Config.DataRootFolder = planRootFolder
+	defer func() {
+		// some files are written relative to the working directory, some below it
+		os.RemoveAll(planRootFolder)
+		os.RemoveAll(filepath.Join(wd, planRootFolder))
+	}()
+
+	plans := []PlanStruct{}
+
+	for _, data := range req {
+		podUID := string(data.Pod.UID)
+		podNamespace := string(data.Pod.Namespace)
+
+		plan := PlanStruct{PodUID: podUID, PodName: data.Pod.Name, PodNamespace: podNamespace, Containers: []DockerRunStruct{}}
+
+		dockerRunStructs, err := planHandler.prepareDockerRuns(data, true)
+		if err != nil {
+			log.G(h.Ctx).Error(err)
+			plan.Error = err.Error()
+			plans = append(plans, plan)
+			continue
+		}
+
+		// the paths of the plan are the ones the POD would get if it was created
+		for _, dockerRunStruct := range dockerRunStructs {
+			for i, mount := range dockerRunStruct.Spec.Mounts {
+				dockerRunStruct.Spec.Mounts[i].Source = planPath(mount.Source, filepath.Join(wd, planRootFolder), filepath.Join(wd, h.Config.DataRootFolder))
+			}
+			plan.Containers = append(plan.Containers, dockerRunStruct)
+		}
+
+		plan.Scripts = map[string]string{}
+		planPodDirectory := filepath.Join(wd, planRootFolder+podNamespace+"-"+podUID)
+		entries, err := os.ReadDir(planPodDirectory)
+		if err != nil && !os.IsNotExist(err) {
+			log.G(h.Ctx).Error(err)
+		}
+		for _, entry := range entries {
+			// configMaps, secrets and emptyDirs live in subfolders and are not returned
+			if entry.IsDir() {
+				continue
+			}
+			content, err := os.ReadFile(filepath.Join(planPodDirectory, entry.Name()))
+			if err != nil {
+				log.G(h.Ctx).Error(err)
+				continue
+			}
+			plan.Scripts[filepath.Join(wd, h.Config.DataRootFolder+podNamespace+"-"+podUID, entry.Name())] = string(content)
+		}
+
+		plans = append(plans, plan)
+	}
+
+	planBytes, err := json.Marshal(plans)
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(statusCode)
+		commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(planBytes)
+
+	log.G(h.Ctx).Info("\u2705 [PLAN CALL] Plan returned for " + strconv.Itoa(len(plans)) + " PODs")
+	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+}
+
+// planPath maps a path below the temporary data root folder of the plan to the path the POD would get below the data root folder.
+// The paths of the mounts are cleaned, so they are compared with the cleaned folders rather than with the folders as configured.
+func planPath(path string, planRootFolder string, dataRootFolder string) string {
+	relativePath, err := filepath.Rel(planRootFolder, path)
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		// e.g. a hostPath volume
+		return path
+	}
+	return filepath.Join(dataRootFolder, relativePath)
+}
@@ -0,0 +1,138 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+)
+
+func TestPlanPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "script", path: "/wd/data.plan-uuid/ns-uid/main_args", want: "/wd/data/ns-uid/main_args"},
+		{name: "emptyDir", path: "/wd/data.plan-uuid/ns-uid/emptyDirs/scratch", want: "/wd/data/ns-uid/emptyDirs/scratch"},
+		{name: "hostPath", path: "/cvmfs", want: "/cvmfs"},
+		{name: "sibling with the same prefix", path: "/wd/data.plan-uuid-other/file", want: "/wd/data.plan-uuid-other/file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := planPath(test.path, "/wd/data.plan-uuid", "/wd/data"); got != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPlanHandlerDataRootFolder(t *testing.T) {
+	tests := []struct {
+		name           string
+		dataRootFolder string
+	}{
+		{name: "relative", dataRootFolder: "./data/"},
+		{name: "not clean", dataRootFolder: "data//jobs/"},
+	}
+
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "ns", UID: "uid"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name:         "main",
+				Image:        "busybox",
+				Command:      []string{"sh", "-c"},
+				Args:         []string{"echo hello"},
+				VolumeMounts: []v1.VolumeMount{{Name: "scratch", MountPath: "/scratch"}},
+			}},
+			Volumes: []v1.Volume{{Name: "scratch", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}}},
+		},
+	}
+	body, err := json.Marshal([]commonIL.RetrievedPodData{{Pod: pod, Containers: []commonIL.RetrievedContainer{{Name: "main", EmptyDirs: []string{"/emptyDirs/scratch"}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousWd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(previousWd)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wd := t.TempDir()
+			if err := os.Chdir(wd); err != nil {
+				t.Fatal(err)
+			}
+			// the files are written below the working directory, whatever the data root folder
+			podDirectory := filepath.Join(wd, test.dataRootFolder, "ns-uid")
+
+			h := &SidecarHandler{Config: commonIL.InterLinkConfig{DataRootFolder: test.dataRootFolder}, Ctx: context.Background()}
+			recorder := httptest.NewRecorder()
+			h.PlanHandler(recorder, httptest.NewRequest(http.MethodPost, "/plan", bytes.NewReader(body)))
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+			}
+			var plans []PlanStruct
+			if err := json.Unmarshal(recorder.Body.Bytes(), &plans); err != nil {
+				t.Fatal(err)
+			}
+			if len(plans) != 1 || plans[0].Error != "" || len(plans[0].Containers) != 1 {
+				t.Fatalf("got plans %+v", plans)
+			}
+
+			sources := map[string]bool{}
+			for _, mount := range plans[0].Containers[0].Spec.Mounts {
+				if strings.Contains(mount.Source, ".plan-") {
+					t.Fatalf("the mount %+v refers to the temporary folder of the plan", mount)
+				}
+				sources[mount.Source] = true
+			}
+			for _, want := range []string{
+				filepath.Join(podDirectory, "emptyDirs", "scratch"),
+				filepath.Join(podDirectory, "main_args"),
+				filepath.Join(podDirectory, "main_uid_ns_script.sh"),
+			} {
+				if !sources[want] {
+					t.Fatalf("got mounts %v, want %s", sources, want)
+				}
+			}
+
+			wantScripts := map[string]string{
+				filepath.Join(podDirectory, "main_args"):             "echo hello",
+				filepath.Join(podDirectory, "main_uid_ns_script.sh"): "sh -c \"$(cat main_args)\"",
+			}
+			if len(plans[0].Scripts) != len(wantScripts) {
+				t.Fatalf("got scripts %v, want %v", plans[0].Scripts, wantScripts)
+			}
+			for path, content := range wantScripts {
+				if plans[0].Scripts[path] != content {
+					t.Fatalf("got scripts %v, want %v", plans[0].Scripts, wantScripts)
+				}
+			}
+
+			// the temporary folder of the plan is removed, and the folder of the POD is not created
+			entries, err := os.ReadDir(filepath.Join(wd, test.dataRootFolder))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				t.Fatalf("the plan has left %s in the data root folder", entry.Name())
+			}
+		})
+	}
+}
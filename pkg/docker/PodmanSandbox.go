@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/log"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// PodmanSandboxManager runs every POD inside a Podman pod, sharing the network namespace as the containers of a Kubernetes POD do
+type PodmanSandboxManager struct {
+	Runtime *containerruntime.PodmanRuntime
+	Ctx     context.Context
+}
+
+func podmanPodName(podUID string) string {
+	return podUID + "_pod"
+}
+
+func (m *PodmanSandboxManager) Create(ctx context.Context, pod v1.Pod) (Sandbox, error) {
+	podUID := string(pod.UID)
+
+	podSpec := containerruntime.PodSpec{
+		Name:   podmanPodName(podUID),
+		Labels: map[string]string{PodUIDLabel: podUID},
+	}
+
+	// the containers of a Podman pod cannot publish ports, the hostPorts of the POD are published by the pod itself
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.HostPort != 0 {
+				podSpec.Ports = append(podSpec.Ports, containerruntime.PortSpec{HostPort: port.HostPort, ContainerPort: port.ContainerPort, Protocol: string(port.Protocol)})
+			}
+		}
+	}
+
+	// if the key is interlink.eu/pod-ip and the value is not empty, attach the pod to the vk0 network with the pod IP
+	podIpAddress := pod.Annotations["interlink.eu/pod-ip"]
+	if podIpAddress != "" {
+		log.G(m.Ctx).Info("\u2705 [POD FLOW] Pod IP Address is: " + podIpAddress)
+		podSpec.Network = "vk0"
+		podSpec.IP = podIpAddress
+	}
+
+	podID, err := m.Runtime.CreatePod(ctx, podSpec)
+	if err != nil {
+		return Sandbox{}, err
+	}
+
+	log.G(m.Ctx).Info("\u2705 [POD FLOW] Created Podman pod " + podSpec.Name)
+
+	return Sandbox{ID: podID, PodUID: podUID, PodID: podID, Runtime: m.Runtime}, nil
+}
+
+func (m *PodmanSandboxManager) Get(ctx context.Context, podUID string) (Sandbox, error) {
+	podInfo, err := m.Runtime.InspectPod(ctx, podmanPodName(podUID))
+	if err != nil {
+		return Sandbox{}, err
+	}
+
+	return Sandbox{ID: podInfo.ID, PodUID: podUID, PodID: podInfo.ID, Runtime: m.Runtime}, nil
+}
+
+func (m *PodmanSandboxManager) Remove(ctx context.Context, podUID string) error {
+	log.G(m.Ctx).Debug("\u2705 [DELETE CALL] Deleting Podman pod " + podmanPodName(podUID))
+
+	err := m.Runtime.RemovePod(ctx, podmanPodName(podUID))
+	if err != nil {
+		log.G(m.Ctx).Error("\u274C [DELETE CALL] Error deleting Podman pod " + podmanPodName(podUID))
+		return err
+	}
+
+	log.G(m.Ctx).Info("\u2705 [DELETE CALL] Deleted Podman pod " + podmanPodName(podUID))
+	return nil
+}
@@ -0,0 +1,232 @@
+package docker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	trace "go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/util/httpstream/wsstream"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// the WebSocket subprotocols of the port forwarding streams, as accepted by the kubelet
+const (
+	portForwardV4BinaryProtocol = "v4." + wsstream.ChannelWebSocketProtocol
+	portForwardV4Base64Protocol = "v4." + wsstream.Base64ChannelWebSocketProtocol
+)
+
+// portForwardRequest is a set of ports of a POD to forward, read from the query of the port forward request
+type portForwardRequest struct {
+	Namespace string
+	PodUID    string
+	// ContainerName is the container whose ports are forwarded, if empty the ports are looked for in all the running containers of the POD
+	ContainerName string
+	Ports         []uint16
+}
+
+// PortForwardHandler forwards ports of a POD, as kubectl port-forward does. Every port is reached from inside the network of the POD,
+// e.g. through the DIND container running it, so that no port has to be published on the host. Each connection is relayed over a WebSocket
+// with the channel framing of the kubelet: the port i gets the data channel 2*i and the error channel 2*i+1, each starting with the port number.
+func (h *SidecarHandler) PortForwardHandler(w http.ResponseWriter, r *http.Request) {
+	log.G(h.Ctx).Info("\u23F3 [PORT FORWARD CALL] Received port forward call")
+
+	start := time.Now().UnixMicro()
+	tracer := otel.Tracer("interlink-API")
+	_, span := tracer.Start(h.Ctx, "PortForward", trace.WithAttributes(
+		attribute.Int64("start.timestamp", start),
+	))
+
+	statusCode := http.StatusOK
+
+	req, err := parsePortForwardRequest(r)
+	if err == nil && !wsstream.IsWebSocketRequest(r) {
+		err = errors.New("the port forwarding streams require a WebSocket upgrade")
+	}
+	if err != nil {
+		statusCode = http.StatusBadRequest
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(statusCode)
+		w.Write([]byte(err.Error()))
+		handleError(span, err, statusCode, start)
+		return
+	}
+
+	span.SetAttributes(attribute.String("podUID", req.PodUID), attribute.String("ports", fmt.Sprint(req.Ports)))
+
+	sandbox, err := h.Sandboxes.Get(h.Ctx, req.PodUID)
+	var hosts []string
+	if err == nil {
+		hosts, err = portForwardHosts(h.Ctx, sandbox, req)
+	}
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		if containerruntime.IsNotFound(err) {
+			statusCode = http.StatusNotFound
+		}
+		log.G(h.Ctx).Error(err)
+		w.WriteHeader(statusCode)
+		w.Write([]byte(err.Error()))
+		handleError(span, err, statusCode, start)
+		return
+	}
+
+	channels := make([]wsstream.ChannelType, 0, 2*len(req.Ports))
+	for range req.Ports {
+		channels = append(channels, wsstream.ReadWriteChannel, wsstream.WriteChannel)
+	}
+	conn := wsstream.NewConn(map[string]wsstream.ChannelProtocolConfig{
+		"":                          {Binary: true, Channels: channels},
+		portForwardV4BinaryProtocol: {Binary: true, Channels: channels},
+		portForwardV4Base64Protocol: {Binary: false, Channels: channels},
+	})
+	_, streams, err := conn.Open(w, r)
+	if err != nil {
+		// the handshake has already answered the client
+		log.G(h.Ctx).Error("\u274C [PORT FORWARD CALL] Error opening the port forwarding streams: " + err.Error())
+		handleError(span, err, http.StatusBadRequest, start)
+		return
+	}
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	for i, port := range req.Ports {
+		dataStream := streams[2*i]
+		errorStream := streams[2*i+1]
+
+		portBytes := make([]byte, 2)
+		binary.LittleEndian.PutUint16(portBytes, port)
+		dataStream.Write(portBytes)
+		errorStream.Write(portBytes)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := forwardPort(h.Ctx, sandbox, hosts, port, dataStream)
+			if err != nil {
+				message := "error forwarding port " + strconv.Itoa(int(port)) + " to pod " + req.PodUID + ": " + err.Error()
+				log.G(h.Ctx).Error("\u274C [PORT FORWARD CALL] " + message)
+				errorStream.Write([]byte(message))
+			}
+		}()
+	}
+	wg.Wait()
+
+	log.G(h.Ctx).Info("\u2705 [PORT FORWARD CALL] The forwarding of the ports " + fmt.Sprint(req.Ports) + " of POD " + req.PodUID + " has ended")
+
+	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(http.StatusSwitchingProtocols))
+	span.End()
+}
+
+// parsePortForwardRequest reads the ports to forward from the query of the request: namespace, podUID, container and port, repeated for every port
+func parsePortForwardRequest(r *http.Request) (portForwardRequest, error) {
+	query := r.URL.Query()
+
+	req := portForwardRequest{
+		Namespace:     query.Get("namespace"),
+		PodUID:        query.Get("podUID"),
+		ContainerName: query.Get("container"),
+	}
+	if req.Namespace == "" || req.PodUID == "" {
+		return req, errors.New("the namespace and the podUID of the port forward are required")
+	}
+
+	for _, value := range query["port"] {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || port == 0 {
+			return req, fmt.Errorf("invalid port %s", value)
+		}
+		req.Ports = append(req.Ports, uint16(port))
+	}
+	if len(req.Ports) == 0 {
+		return req, errors.New("at least one port to forward is required")
+	}
+
+	return req, nil
+}
+
+// portForwardHosts returns the addresses, in the network of the POD, of the container whose ports are forwarded,
+// or of all the running containers of the POD if no container is given
+func portForwardHosts(ctx context.Context, sandbox Sandbox, req portForwardRequest) ([]string, error) {
+	if req.ContainerName != "" {
+		containerName := req.Namespace + "-" + req.PodUID + "-" + req.ContainerName
+		containerInfo, err := sandbox.Runtime.Inspect(ctx, containerName)
+		if err != nil {
+			return nil, err
+		}
+		if !containerInfo.State.Running {
+			return nil, fmt.Errorf("%w: container %s is not running", containerruntime.ErrNotFound, containerName)
+		}
+		return []string{containerIP(containerInfo)}, nil
+	}
+
+	containers, err := sandbox.Runtime.List(ctx, containerruntime.ListOptions{Labels: map[string]string{PodUIDLabel: req.PodUID}})
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, container := range containers {
+		containerInfo, err := sandbox.Runtime.Inspect(ctx, container.ID)
+		if err != nil || !containerInfo.State.Running {
+			continue
+		}
+		host := containerIP(containerInfo)
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%w: POD %s has no running container", containerruntime.ErrNotFound, req.PodUID)
+	}
+
+	return hosts, nil
+}
+
+// forwardPort connects to the port on the first host accepting the connection, and relays the connection to the data stream.
+// Once the client has sent all its data, the connection is half-closed so that the container still sends its response, and the
+// forwarding ends once the container has closed its side. As the data stream cannot be half-closed, it is released with the WebSocket.
+func forwardPort(ctx context.Context, sandbox Sandbox, hosts []string, port uint16, dataStream io.ReadWriter) error {
+	// the connection may be relayed by a command bound to the context, which lasts as long as the forwarding
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	for _, host := range hosts {
+		conn, err = sandbox.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		io.Copy(conn, dataStream)
+		if closeWriter, ok := conn.(interface{ CloseWrite() error }); ok {
+			closeWriter.CloseWrite()
+		}
+	}()
+
+	io.Copy(dataStream, conn)
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePortForwardRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    portForwardRequest
+		wantErr bool
+	}{
+		{name: "ports of a container", query: "namespace=ns&podUID=uid&container=web&port=8080&port=9090", want: portForwardRequest{Namespace: "ns", PodUID: "uid", ContainerName: "web", Ports: []uint16{8080, 9090}}},
+		{name: "ports of the POD", query: "namespace=ns&podUID=uid&port=80", want: portForwardRequest{Namespace: "ns", PodUID: "uid", Ports: []uint16{80}}},
+		{name: "missing namespace", query: "podUID=uid&port=80", wantErr: true},
+		{name: "missing POD", query: "namespace=ns&port=80", wantErr: true},
+		{name: "missing port", query: "namespace=ns&podUID=uid", wantErr: true},
+		{name: "port 0", query: "namespace=ns&podUID=uid&port=0", wantErr: true},
+		{name: "port out of range", query: "namespace=ns&podUID=uid&port=65536", wantErr: true},
+		{name: "port name", query: "namespace=ns&podUID=uid&port=http", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parsePortForwardRequest(httptest.NewRequest("GET", "/portforward?"+test.query, nil))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// dataStream is the data channel of a forwarded port, the client sends its request and then stops sending
+type dataStream struct {
+	request  io.Reader
+	received bytes.Buffer
+}
+
+func (s *dataStream) Read(p []byte) (int, error) {
+	return s.request.Read(p)
+}
+
+func (s *dataStream) Write(p []byte) (int, error) {
+	return s.received.Write(p)
+}
+
+func TestForwardPortHalfClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// the server answers once it has read the whole request
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, _ := io.ReadAll(conn)
+		conn.Write([]byte("response to " + string(request)))
+	}()
+
+	stream := &dataStream{request: strings.NewReader("request")}
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	if err := forwardPort(context.Background(), Sandbox{}, []string{"127.0.0.1"}, port, stream); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := stream.received.String(); got != "response to request" {
+		t.Fatalf("got %q, want the response sent after the end of the request", got)
+	}
+}
+
+func TestForwardPortUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	stream := &dataStream{request: strings.NewReader("request")}
+	if err := forwardPort(context.Background(), Sandbox{}, []string{"127.0.0.1"}, port, stream); err == nil {
+		t.Fatal("got no error")
+	}
+}
@@ -0,0 +1,399 @@
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+type probeType string
+
+const (
+	livenessProbe  probeType = "Liveness"
+	readinessProbe probeType = "Readiness"
+	startupProbe   probeType = "Startup"
+)
+
+// probeUserAgent is sent by the httpGet and grpc probes, as the kubelet does
+const probeUserAgent = "kube-probe/interlink"
+
+// maxProbeBodyBytes is the part of the body of an httpGet probe response that is read, the rest is discarded
+const maxProbeBodyBytes = 10 * 1024
+
+// startProbes runs the probes of the container in background, until the supervisor is stopped. It is called by the supervisor only, the probes of a container are started once.
+func (p *PodSupervisor) startProbes(containerName string) {
+	supervisedContainer := p.containers[containerName]
+	if supervisedContainer.probing {
+		return
+	}
+	supervisedContainer.probing = true
+	container := supervisedContainer.spec
+
+	if container.StartupProbe != nil {
+		go p.runProbe(containerName, container, startupProbe, container.StartupProbe)
+	}
+	if container.ReadinessProbe != nil {
+		go p.runProbe(containerName, container, readinessProbe, container.ReadinessProbe)
+	}
+	if container.LivenessProbe != nil {
+		go p.runProbe(containerName, container, livenessProbe, container.LivenessProbe)
+	}
+}
+
+// runProbe executes the probe periodically while the container is running. The liveness and readiness probes wait for the startup probe to succeed.
+// A container failing its liveness or startup probe is stopped, and then restarted according to the restart policy of the POD.
+func (p *PodSupervisor) runProbe(containerName string, container v1.Container, kind probeType, probe *v1.Probe) {
+	period := probeSeconds(probe.PeriodSeconds, 10)
+	timeout := probeSeconds(probe.TimeoutSeconds, 1)
+	initialDelay := time.Duration(probe.InitialDelaySeconds) * time.Second
+	results := probeResults{
+		successThreshold: probeThreshold(probe.SuccessThreshold, 1),
+		failureThreshold: probeThreshold(probe.FailureThreshold, 3),
+	}
+
+	var startedAt time.Time
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.Ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		containerInfo, err := p.inspect(containerName)
+		if err != nil || !containerInfo.State.Running {
+			continue
+		}
+
+		if !containerInfo.State.StartedAt.Equal(startedAt) {
+			// the container has been restarted, its probes start again
+			startedAt = containerInfo.State.StartedAt
+			results.reset()
+		}
+
+		if time.Since(startedAt) < initialDelay {
+			continue
+		}
+
+		started := p.probeStarted(containerName, startedAt)
+		if (kind == startupProbe) == started {
+			continue
+		}
+
+		err = p.probe(containerName, container, containerInfo, probe, timeout)
+		if err != nil {
+			log.G(p.Ctx).Debug("\u274C [POD FLOW] " + string(kind) + " probe of container " + containerName + " failed: " + err.Error())
+		}
+
+		succeeded, failed := results.observe(err)
+		if succeeded {
+			p.setProbeResult(containerName, startedAt, kind, true, "")
+		} else if failed {
+			message := string(kind) + " probe failed: " + err.Error()
+			log.G(p.Ctx).Info("\u274C [POD FLOW] " + message + ", container " + containerName)
+
+			p.setProbeResult(containerName, startedAt, kind, false, message)
+
+			if kind != readinessProbe {
+				results.reset()
+
+				gracePeriod := p.terminationGracePeriod
+				if probe.TerminationGracePeriodSeconds != nil {
+					gracePeriod = time.Duration(*probe.TerminationGracePeriodSeconds) * time.Second
+				}
+
+				log.G(p.Ctx).Info("\u2705 [POD FLOW] Stopping container " + containerName + " failing its " + strings.ToLower(string(kind)) + " probe")
+				err = stopContainer(p.Ctx, p.Sandbox, containerName, container, gracePeriod, p)
+				if err != nil {
+					log.G(p.Ctx).Error("\u274C [POD FLOW] Error stopping container " + containerName + ": " + err.Error())
+				}
+			}
+		}
+	}
+}
+
+// probeResults counts the consecutive successes and failures of a probe against its thresholds
+type probeResults struct {
+	successThreshold int32
+	failureThreshold int32
+	successes        int32
+	failures         int32
+}
+
+// observe records the outcome of an execution of the probe, and reports whether the success or the failure threshold is reached
+func (r *probeResults) observe(err error) (succeeded bool, failed bool) {
+	if err == nil {
+		r.successes++
+		r.failures = 0
+		return r.successes >= r.successThreshold, false
+	}
+
+	r.failures++
+	r.successes = 0
+	return false, r.failures >= r.failureThreshold
+}
+
+// reset starts counting again, e.g. after a restart of the container
+func (r *probeResults) reset() {
+	r.successes, r.failures = 0, 0
+}
+
+// probeStarted reports whether the execution of the container started at startedAt has passed its startup probe
+func (p *PodSupervisor) probeStarted(containerName string, startedAt time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	container := p.containers[containerName]
+	container.resetProbes(startedAt)
+	return container.probedStartedAt.Equal(startedAt) && container.started
+}
+
+// setProbeResult records the result of a probe, unless the container has been restarted in the meantime
+func (p *PodSupervisor) setProbeResult(containerName string, startedAt time.Time, kind probeType, success bool, message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	container := p.containers[containerName]
+	container.resetProbes(startedAt)
+	if !container.probedStartedAt.Equal(startedAt) {
+		return
+	}
+
+	switch kind {
+	case startupProbe:
+		if success {
+			container.started = true
+		}
+	case readinessProbe:
+		container.ready = success
+	}
+
+	if !success && kind != readinessProbe {
+		// reported as the message of the termination caused by the probe
+		container.stopMessage = message
+	}
+}
+
+// resetProbes sets the probe results of the container to their initial values when a new execution of the container is observed
+func (c *supervisedContainer) resetProbes(startedAt time.Time) {
+	if !startedAt.After(c.probedStartedAt) {
+		return
+	}
+
+	c.probedStartedAt = startedAt
+	c.started = c.spec.StartupProbe == nil
+	c.ready = c.spec.ReadinessProbe == nil
+}
+
+// probe executes the probe once against the container
+func (p *PodSupervisor) probe(containerName string, container v1.Container, containerInfo containerruntime.ContainerInfo, probe *v1.Probe, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(p.Ctx, timeout)
+	defer cancel()
+
+	podIP := containerIP(containerInfo)
+
+	switch {
+	case probe.Exec != nil:
+		return execAction(ctx, p.Sandbox, containerName, probe.Exec.Command)
+
+	case probe.HTTPGet != nil:
+		port, err := resolvePort(probe.HTTPGet.Port, container)
+		if err != nil {
+			return err
+		}
+		host := probe.HTTPGet.Host
+		if host == "" {
+			host = podIP
+		}
+		return httpGetAction(ctx, p.Sandbox, host, port, probe.HTTPGet)
+
+	case probe.TCPSocket != nil:
+		port, err := resolvePort(probe.TCPSocket.Port, container)
+		if err != nil {
+			return err
+		}
+		host := probe.TCPSocket.Host
+		if host == "" {
+			host = podIP
+		}
+		conn, err := p.Sandbox.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+
+	case probe.GRPC != nil:
+		return p.grpcProbe(ctx, net.JoinHostPort(podIP, strconv.Itoa(int(probe.GRPC.Port))), probe.GRPC)
+
+	default:
+		return errors.New("the probe has no handler")
+	}
+}
+
+// containerIP returns the address the container is reachable at, in the network of its POD
+func containerIP(containerInfo containerruntime.ContainerInfo) string {
+	// the containers of a POD without a network of its own share the network of the host
+	if containerInfo.IPAddress == "" {
+		return "127.0.0.1"
+	}
+	return containerInfo.IPAddress
+}
+
+// execAction runs the command of a probe or of a lifecycle hook inside the container, failing if it exits with a non-zero code
+func execAction(ctx context.Context, sandbox Sandbox, containerName string, command []string) error {
+	type execOutcome struct {
+		result containerruntime.ExecResult
+		err    error
+	}
+
+	// the runtimes wait for the command to complete, regardless of the context
+	outcome := make(chan execOutcome, 1)
+	go func() {
+		result, err := sandbox.Runtime.Exec(ctx, containerName, command)
+		outcome <- execOutcome{result: result, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("command timed out: %w", ctx.Err())
+	case execOutcome := <-outcome:
+		if execOutcome.err != nil {
+			return execOutcome.err
+		}
+		if execOutcome.result.ExitCode != 0 {
+			return fmt.Errorf("command exited with code %d: %s", execOutcome.result.ExitCode, strings.TrimSpace(execOutcome.result.Stdout+execOutcome.result.Stderr))
+		}
+		return nil
+	}
+}
+
+// httpGetAction sends the request of a probe or of a lifecycle hook to the container, failing unless it gets a 2xx or 3xx response
+func httpGetAction(ctx context.Context, sandbox Sandbox, host string, port int, httpGet *v1.HTTPGetAction) error {
+	probeURL, err := url.Parse(httpGet.Path)
+	if err != nil {
+		return err
+	}
+	probeURL.Scheme = strings.ToLower(string(httpGet.Scheme))
+	if probeURL.Scheme == "" {
+		probeURL.Scheme = "http"
+	}
+	probeURL.Host = net.JoinHostPort(host, strconv.Itoa(port))
+	if probeURL.Path == "" {
+		probeURL.Path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", probeUserAgent)
+	req.Header.Set("Accept", "*/*")
+	for _, header := range httpGet.HTTPHeaders {
+		if strings.EqualFold(header.Name, "Host") {
+			req.Host = header.Value
+		} else {
+			req.Header.Add(header.Name, header.Value)
+		}
+	}
+
+	// as the kubelet, the certificates of the containers are not verified
+	client := &http.Client{Transport: &http.Transport{
+		DialContext:       sandbox.DialContext,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxProbeBodyBytes))
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("HTTP request failed with statuscode: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *PodSupervisor) grpcProbe(ctx context.Context, address string, grpcAction *v1.GRPCAction) error {
+	conn, err := grpc.NewClient("passthrough:///"+address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUserAgent(probeUserAgent),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return p.Sandbox.DialContext(ctx, "tcp", address)
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	service := ""
+	if grpcAction.Service != nil {
+		service = *grpcAction.Service
+	}
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service unhealthy (responded with %q)", resp.GetStatus().String())
+	}
+	return nil
+}
+
+// resolvePort returns the number of a port given either by number or by the name of a port of the container
+func resolvePort(port intstr.IntOrString, container v1.Container) (int, error) {
+	if port.Type == intstr.Int {
+		return port.IntValue(), nil
+	}
+
+	for _, containerPort := range container.Ports {
+		if containerPort.Name == port.StrVal {
+			return int(containerPort.ContainerPort), nil
+		}
+	}
+
+	number, err := strconv.Atoi(port.StrVal)
+	if err != nil {
+		return 0, fmt.Errorf("port %s not found in container %s", port.StrVal, container.Name)
+	}
+	return number, nil
+}
+
+// probeSeconds converts a duration of the probe, in seconds, using the default if unset
+func probeSeconds(seconds int32, defaultSeconds int32) time.Duration {
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func probeThreshold(threshold int32, defaultThreshold int32) int32 {
+	if threshold <= 0 {
+		return defaultThreshold
+	}
+	return threshold
+}
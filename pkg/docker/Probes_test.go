@@ -0,0 +1,153 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+func TestResolvePort(t *testing.T) {
+	container := v1.Container{Name: "web", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "metrics", ContainerPort: 9090}}}
+
+	tests := []struct {
+		name    string
+		port    intstr.IntOrString
+		want    int
+		wantErr bool
+	}{
+		{name: "number", port: intstr.FromInt32(80), want: 80},
+		{name: "name", port: intstr.FromString("metrics"), want: 9090},
+		{name: "number as a string", port: intstr.FromString("8443"), want: 8443},
+		{name: "unknown name", port: intstr.FromString("grpc"), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := resolvePort(test.port, container)
+			if (err != nil) != test.wantErr || got != test.want {
+				t.Fatalf("got %d, %v, want %d, error %t", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeResults(t *testing.T) {
+	failure := errors.New("connection refused")
+
+	tests := []struct {
+		name             string
+		successThreshold int32
+		failureThreshold int32
+		outcomes         []error
+		// want holds, for every outcome, 's' if the success threshold is reached, 'f' if the failure threshold is reached, '-' otherwise
+		want string
+	}{
+		{name: "default thresholds", successThreshold: 1, failureThreshold: 3, outcomes: []error{nil, failure, failure, failure, failure}, want: "s--ff"},
+		{name: "failures interrupted by a success", successThreshold: 1, failureThreshold: 3, outcomes: []error{failure, failure, nil, failure, failure, failure}, want: "--s--f"},
+		{name: "success threshold", successThreshold: 3, failureThreshold: 1, outcomes: []error{nil, nil, failure, nil, nil, nil, nil}, want: "--f--ss"},
+		{name: "single failure", successThreshold: 1, failureThreshold: 1, outcomes: []error{failure, nil}, want: "fs"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := probeResults{successThreshold: test.successThreshold, failureThreshold: test.failureThreshold}
+			got := ""
+			for _, outcome := range test.outcomes {
+				succeeded, failed := results.observe(outcome)
+				switch {
+				case succeeded && failed:
+					t.Fatal("both thresholds reached")
+				case succeeded:
+					got += "s"
+				case failed:
+					got += "f"
+				default:
+					got += "-"
+				}
+			}
+			if got != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+
+	results := probeResults{successThreshold: 2, failureThreshold: 2}
+	results.observe(failure)
+	results.reset()
+	if _, failed := results.observe(failure); failed {
+		t.Fatal("the failures have not been reset")
+	}
+}
+
+func TestProbeThresholdDefaults(t *testing.T) {
+	if got := probeThreshold(0, 3); got != 3 {
+		t.Fatalf("unset threshold: got %d, want 3", got)
+	}
+	if got := probeThreshold(5, 3); got != 5 {
+		t.Fatalf("got %d, want 5", got)
+	}
+	if got := probeSeconds(0, 10); got != 10*time.Second {
+		t.Fatalf("unset period: got %s, want 10s", got)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closedListener.Addr().(*net.TCPAddr).Port
+	closedListener.Close()
+
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "container", State: containerruntime.ContainerState{Status: "running", Running: true}})
+	runtime.execResults["true"] = containerruntime.ExecResult{ExitCode: 0}
+	runtime.execResults["false"] = containerruntime.ExecResult{ExitCode: 1, Stderr: "unhealthy"}
+	supervisor := newTestSupervisor(runtime, v1.RestartPolicyAlways)
+	container := v1.Container{Name: "container", Ports: []v1.ContainerPort{{Name: "http", ContainerPort: int32(openPort)}}}
+
+	tests := []struct {
+		name    string
+		probe   v1.Probe
+		wantErr bool
+	}{
+		{name: "exec success", probe: v1.Probe{ProbeHandler: v1.ProbeHandler{Exec: &v1.ExecAction{Command: []string{"true"}}}}},
+		{name: "exec failure", probe: v1.Probe{ProbeHandler: v1.ProbeHandler{Exec: &v1.ExecAction{Command: []string{"false"}}}}, wantErr: true},
+		{name: "tcp open", probe: v1.Probe{ProbeHandler: v1.ProbeHandler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromString("http")}}}},
+		{name: "tcp closed", probe: v1.Probe{ProbeHandler: v1.ProbeHandler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromString(strconv.Itoa(closedPort))}}}, wantErr: true},
+		{name: "no handler", probe: v1.Probe{}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			containerInfo, _ := runtime.Inspect(context.Background(), "container")
+			err := supervisor.probe("container", container, containerInfo, &test.probe, time.Second)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
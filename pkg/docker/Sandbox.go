@@ -0,0 +1,88 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"regexp"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/acceleratorledger"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// Sandbox is the isolated environment in which the containers of a POD are executed
+type Sandbox struct {
+	// ID identifies the sandbox in the runtime and is returned to InterLink as the JobID of the POD
+	ID     string
+	PodUID string
+	// PodID is the runtime pod the containers have to join, if the runtime groups containers in pods
+	PodID string
+	// Network is the network the containers have to join, empty to use the default network of the runtime
+	Network string
+	// Runtime runs the containers of the POD. It is owned by the SandboxManager and must not be closed by the caller
+	Runtime containerruntime.ContainerRuntime
+	// Dial connects to an address of the network of the POD. If nil, the network of the POD is reachable from the host
+	Dial func(ctx context.Context, network string, address string) (net.Conn, error)
+	// HostRuntime runs the container isolating the POD, e.g. its DIND container, whose ID is the ID of the sandbox. Nil if the sandbox is not a container
+	HostRuntime containerruntime.ContainerRuntime
+}
+
+// SandboxManager creates, retrieves and removes the sandboxes of the PODs. Each supported runtime has its own implementation.
+type SandboxManager interface {
+	Create(ctx context.Context, pod v1.Pod) (Sandbox, error)
+	// Get returns an error wrapping containerruntime.ErrNotFound if the POD has no sandbox
+	Get(ctx context.Context, podUID string) (Sandbox, error)
+	Remove(ctx context.Context, podUID string) error
+}
+
+// PodUIDLabel is set on the containers of a POD, so that they can be found even if they share the runtime with other PODs
+const PodUIDLabel = "interlink.eu/pod-uid"
+
+// prepareContainerSpec binds the spec of a container to the sandbox of its POD
+func (s Sandbox) prepareContainerSpec(spec containerruntime.ContainerSpec) containerruntime.ContainerSpec {
+	spec.Pod = s.PodID
+	if s.Network != "" {
+		spec.NetworkMode = s.Network
+	}
+
+	labels := map[string]string{PodUIDLabel: s.PodUID}
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
+	spec.Labels = labels
+
+	return spec
+}
+
+// DialContext connects to an address of the network of the POD, e.g. to probe its containers
+func (s Sandbox) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if s.Dial != nil {
+		return s.Dial(ctx, network, address)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+// podUIDRegexp matches the POD UID in the name of a container, built as <namespace>-<POD UID>-<container name>
+var podUIDRegexp = regexp.MustCompile(`-([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})-`)
+
+// ContainerLivenessCheck returns the check used by the accelerator managers to reconcile their ledgers.
+// A container is live as long as its POD has a sandbox, since the accelerators are released only when the POD is deleted.
+func ContainerLivenessCheck(ctx context.Context, sandboxes SandboxManager) acceleratorledger.LivenessCheck {
+	return func(containerName string) (bool, error) {
+		matches := podUIDRegexp.FindStringSubmatch(containerName)
+		if matches == nil {
+			// not a container of a POD, e.g. a container of the host found by the GPU check
+			return false, nil
+		}
+
+		_, err := sandboxes.Get(ctx, matches[1])
+		if containerruntime.IsNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,384 @@
+package docker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api/statsv1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	trace "go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+const (
+	// statsWorkers is the number of PODs whose usage is read at the same time, so that the runtimes are not flooded
+	statsWorkers = 4
+	// rootfsSizePeriod is the interval between two measures of the size of the writable layer of a container, which the runtimes compute by walking the whole layer
+	rootfsSizePeriod = time.Minute
+)
+
+// UsageSamples keeps the last sample of the usage of every container, from which the rate of its CPU usage is computed as cAdvisor does,
+// along with the last size of its writable layer
+type UsageSamples struct {
+	mu sync.Mutex
+	// samples are indexed by container ID
+	samples map[string]containerruntime.ContainerStats
+	// rootfsSizes are indexed by container ID
+	rootfsSizes map[string]rootfsSize
+}
+
+// rootfsSize is the size of the writable layer of a container, measured at a given time
+type rootfsSize struct {
+	usedBytes uint64
+	measured  time.Time
+}
+
+func NewUsageSamples() *UsageSamples {
+	return &UsageSamples{samples: make(map[string]containerruntime.ContainerStats), rootfsSizes: make(map[string]rootfsSize)}
+}
+
+// rootfsSizeDue reports whether the size of the writable layer of the container has to be measured again
+func (u *UsageSamples) rootfsSizeDue(containerID string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	size, ok := u.rootfsSizes[containerID]
+	return !ok || time.Since(size.measured) >= rootfsSizePeriod
+}
+
+// rootfsUsedBytes records the size of the writable layer of the container held by the sample, if it has been measured, and returns its last measured size
+func (u *UsageSamples) rootfsUsedBytes(containerID string, sample containerruntime.ContainerStats, measured bool) uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if measured {
+		u.rootfsSizes[containerID] = rootfsSize{usedBytes: sample.RootfsUsedBytes, measured: time.Now()}
+	}
+	return u.rootfsSizes[containerID].usedBytes
+}
+
+// usageNanoCores records the sample of the container and returns its CPU usage since the previous sample,
+// nil for the first sample of the container or once its usage has been reset by a restart
+func (u *UsageSamples) usageNanoCores(containerID string, sample containerruntime.ContainerStats) *uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	previous, ok := u.samples[containerID]
+	u.samples[containerID] = sample
+	if !ok || !sample.Time.After(previous.Time) || sample.CPUUsageNanoSeconds < previous.CPUUsageNanoSeconds {
+		return nil
+	}
+
+	nanoCores := uint64(float64(sample.CPUUsageNanoSeconds-previous.CPUUsageNanoSeconds) / sample.Time.Sub(previous.Time).Seconds())
+	return &nanoCores
+}
+
+// prune drops the samples of the containers that are not running anymore
+func (u *UsageSamples) prune(containerIDs map[string]bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for containerID := range u.samples {
+		if !containerIDs[containerID] {
+			delete(u.samples, containerID)
+		}
+	}
+	for containerID := range u.rootfsSizes {
+		if !containerIDs[containerID] {
+			delete(u.rootfsSizes, containerID)
+		}
+	}
+}
+
+// podUsage is the usage of a POD, along with the IDs of the containers it has been read from
+type podUsage struct {
+	stats        statsv1alpha1.PodStats
+	containerIDs []string
+	err          error
+}
+
+// StatsHandler reports the resource usage of the running containers of the PODs in the format of the stats/summary API of the kubelet,
+// so that the virtual kubelet can serve kubectl top and the HPA. The usage of the POD as a whole is the usage of its DIND container, which holds
+// its containers along with their Docker daemon, or the sum of the usage of its containers if the POD has no DIND container.
+func (h *SidecarHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	log.G(h.Ctx).Info("\u23F3 [STATS CALL] Received stats call")
+
+	start := time.Now().UnixMicro()
+	tracer := otel.Tracer("interlink-API")
+	_, span := tracer.Start(h.Ctx, "Stats", trace.WithAttributes(
+		attribute.Int64("start.timestamp", start),
+	))
+
+	statusCode := http.StatusOK
+
+	// the PODs are read concurrently, since the runtimes may take a while to compute the usage of their containers
+	semaphore := make(chan struct{}, statsWorkers)
+
+	supervisors := h.Supervisors.List()
+	usages := make([]podUsage, len(supervisors))
+	var wg sync.WaitGroup
+	for i, supervisor := range supervisors {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			usages[i] = h.podStats(supervisor)
+		}()
+	}
+	wg.Wait()
+
+	// the node is described by the virtual kubelet itself, only the PODs are reported
+	summary := statsv1alpha1.Summary{Pods: []statsv1alpha1.PodStats{}}
+	containerIDs := make(map[string]bool)
+	var podErrors []string
+	for _, usage := range usages {
+		if usage.err != nil {
+			log.G(h.Ctx).Error("\u274C [STATS CALL] Error reading the usage of POD " + usage.stats.PodRef.UID + ": " + usage.err.Error())
+			podErrors = append(podErrors, usage.stats.PodRef.UID+": "+usage.err.Error())
+			continue
+		}
+		summary.Pods = append(summary.Pods, usage.stats)
+		for _, containerID := range usage.containerIDs {
+			containerIDs[containerID] = true
+		}
+	}
+	h.Usage.prune(containerIDs)
+	if len(podErrors) > 0 {
+		span.SetAttributes(attribute.StringSlice("errors", podErrors))
+	}
+
+	bodyBytes, err := json.Marshal(summary)
+	if err != nil {
+		log.G(h.Ctx).Error(err)
+		statusCode = http.StatusInternalServerError
+		w.WriteHeader(statusCode)
+		w.Write([]byte("Some errors occurred while reading the usage of the containers. Check Docker Sidecar's logs"))
+		span.SetAttributes(attribute.String("error", err.Error()))
+	} else {
+		log.G(h.Ctx).Info("\u2705 [STATS CALL] Usage of " + strconv.Itoa(len(summary.Pods)) + " PODs reported")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write(bodyBytes)
+	}
+
+	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+	span.End()
+}
+
+// podStats reads the usage of the running containers of the POD, and of its DIND container if any
+func (h *SidecarHandler) podStats(supervisor *PodSupervisor) podUsage {
+	sandbox := supervisor.Sandbox
+	usage := podUsage{stats: statsv1alpha1.PodStats{
+		PodRef:     statsv1alpha1.PodReference{Name: supervisor.PodName, Namespace: supervisor.PodNamespace, UID: sandbox.PodUID},
+		Containers: []statsv1alpha1.ContainerStats{},
+	}}
+
+	specNames := supervisor.containerNames()
+	containerNames := make([]string, 0, len(specNames))
+	for containerName := range specNames {
+		containerNames = append(containerNames, containerName)
+	}
+	sort.Strings(containerNames)
+
+	var interfaces [][]containerruntime.InterfaceStats
+	var ephemeralStorage uint64
+	for _, containerName := range containerNames {
+		containerInfo, err := h.StatusCache.Inspect(sandbox, containerName)
+		if containerruntime.IsNotFound(err) || (err == nil && !containerInfo.State.Running) {
+			continue
+		} else if err != nil {
+			usage.err = err
+			return usage
+		}
+
+		measureSize := h.Usage.rootfsSizeDue(containerInfo.ID)
+		sample, err := sandbox.Runtime.Stats(h.Ctx, containerInfo.ID, containerruntime.StatsOptions{Size: measureSize})
+		if containerruntime.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			usage.err = err
+			return usage
+		}
+		sample.RootfsUsedBytes = h.Usage.rootfsUsedBytes(containerInfo.ID, sample, measureSize)
+		usage.containerIDs = append(usage.containerIDs, containerInfo.ID)
+		interfaces = append(interfaces, sample.Networks)
+
+		specName := specNames[containerName]
+		logsUsed := h.Logs.LogsSize(supervisor.PodNamespace, sandbox.PodUID, specName)
+		ephemeralStorage += sample.RootfsUsedBytes + logsUsed
+
+		usage.stats.Containers = append(usage.stats.Containers, statsv1alpha1.ContainerStats{
+			Name:      specName,
+			StartTime: metav1.NewTime(containerInfo.State.StartedAt),
+			CPU:       cpuStats(sample, h.Usage.usageNanoCores(containerInfo.ID, sample)),
+			Memory:    memoryStats(sample),
+			Rootfs:    &statsv1alpha1.FsStats{Time: metav1.NewTime(sample.Time), UsedBytes: &sample.RootfsUsedBytes},
+			Logs:      filesystemStats(h.Logs.Root, logsUsed, sample.Time),
+		})
+
+		if usage.stats.StartTime.IsZero() || containerInfo.State.StartedAt.Before(usage.stats.StartTime.Time) {
+			usage.stats.StartTime = metav1.NewTime(containerInfo.State.StartedAt)
+		}
+	}
+
+	now := metav1.Now()
+	usage.stats.EphemeralStorage = &statsv1alpha1.FsStats{Time: now, UsedBytes: &ephemeralStorage}
+
+	switch {
+	case sandbox.HostRuntime != nil:
+		sandboxInfo, err := sandbox.HostRuntime.Inspect(h.Ctx, sandbox.ID)
+		if err != nil {
+			usage.err = err
+			return usage
+		}
+		// the writable layer of the DIND container, which holds the images of the POD, is not reported
+		sample, err := sandbox.HostRuntime.Stats(h.Ctx, sandbox.ID, containerruntime.StatsOptions{})
+		if err != nil {
+			usage.err = err
+			return usage
+		}
+		usage.containerIDs = append(usage.containerIDs, sandbox.ID)
+
+		usage.stats.StartTime = metav1.NewTime(sandboxInfo.State.StartedAt)
+		usage.stats.CPU = cpuStats(sample, h.Usage.usageNanoCores(sandbox.ID, sample))
+		usage.stats.Memory = memoryStats(sample)
+		usage.stats.Network = networkStats(sample.Networks, sample.Time)
+	case len(usage.stats.Containers) > 0:
+		usage.stats.CPU, usage.stats.Memory = sumContainerStats(usage.stats.Containers)
+		if sandbox.PodID != "" {
+			// the containers of a runtime pod share its network namespace
+			usage.stats.Network = networkStats(interfaces[0], now.Time)
+		} else {
+			usage.stats.Network = networkStats(sumInterfaces(interfaces), now.Time)
+		}
+	}
+
+	return usage
+}
+
+func cpuStats(sample containerruntime.ContainerStats, usageNanoCores *uint64) *statsv1alpha1.CPUStats {
+	return &statsv1alpha1.CPUStats{
+		Time:                 metav1.NewTime(sample.Time),
+		UsageNanoCores:       usageNanoCores,
+		UsageCoreNanoSeconds: &sample.CPUUsageNanoSeconds,
+	}
+}
+
+func memoryStats(sample containerruntime.ContainerStats) *statsv1alpha1.MemoryStats {
+	stats := &statsv1alpha1.MemoryStats{
+		Time:            metav1.NewTime(sample.Time),
+		UsageBytes:      &sample.MemoryUsageBytes,
+		WorkingSetBytes: &sample.MemoryWorkingSetBytes,
+		RSSBytes:        &sample.MemoryRSSBytes,
+		PageFaults:      &sample.PageFaults,
+		MajorPageFaults: &sample.MajorPageFaults,
+	}
+	if sample.MemoryLimitBytes > sample.MemoryWorkingSetBytes {
+		available := sample.MemoryLimitBytes - sample.MemoryWorkingSetBytes
+		stats.AvailableBytes = &available
+	}
+	return stats
+}
+
+// sumContainerStats returns the usage of a POD as the sum of the usage of its containers. The rate of its CPU usage is only known if it is known for all its containers.
+func sumContainerStats(containers []statsv1alpha1.ContainerStats) (*statsv1alpha1.CPUStats, *statsv1alpha1.MemoryStats) {
+	cpu := &statsv1alpha1.CPUStats{Time: containers[0].CPU.Time, UsageNanoCores: new(uint64), UsageCoreNanoSeconds: new(uint64)}
+	memory := &statsv1alpha1.MemoryStats{Time: containers[0].Memory.Time, UsageBytes: new(uint64), WorkingSetBytes: new(uint64), RSSBytes: new(uint64), PageFaults: new(uint64), MajorPageFaults: new(uint64)}
+
+	for _, container := range containers {
+		if cpu.UsageNanoCores != nil && container.CPU.UsageNanoCores != nil {
+			*cpu.UsageNanoCores += *container.CPU.UsageNanoCores
+		} else {
+			cpu.UsageNanoCores = nil
+		}
+		*cpu.UsageCoreNanoSeconds += *container.CPU.UsageCoreNanoSeconds
+
+		*memory.UsageBytes += *container.Memory.UsageBytes
+		*memory.WorkingSetBytes += *container.Memory.WorkingSetBytes
+		*memory.RSSBytes += *container.Memory.RSSBytes
+		*memory.PageFaults += *container.Memory.PageFaults
+		*memory.MajorPageFaults += *container.Memory.MajorPageFaults
+	}
+
+	return cpu, memory
+}
+
+// sumInterfaces adds up the traffic of the interfaces with the same name, for containers having network namespaces of their own
+func sumInterfaces(interfaces [][]containerruntime.InterfaceStats) []containerruntime.InterfaceStats {
+	sums := make(map[string]*containerruntime.InterfaceStats)
+	var sum []containerruntime.InterfaceStats
+	for _, containerInterfaces := range interfaces {
+		for _, stats := range containerInterfaces {
+			if _, ok := sums[stats.Name]; !ok {
+				sums[stats.Name] = &containerruntime.InterfaceStats{Name: stats.Name}
+			}
+			sums[stats.Name].RxBytes += stats.RxBytes
+			sums[stats.Name].RxErrors += stats.RxErrors
+			sums[stats.Name].TxBytes += stats.TxBytes
+			sums[stats.Name].TxErrors += stats.TxErrors
+		}
+	}
+	for _, stats := range sums {
+		sum = append(sum, *stats)
+	}
+	return sum
+}
+
+// networkStats returns the traffic of the interfaces, eth0 being the default one as for the kubelet. It returns nil if there is no interface, e.g. with the network of the host.
+func networkStats(interfaces []containerruntime.InterfaceStats, sampled time.Time) *statsv1alpha1.NetworkStats {
+	if len(interfaces) == 0 {
+		return nil
+	}
+
+	sort.Slice(interfaces, func(i, j int) bool { return interfaces[i].Name < interfaces[j].Name })
+
+	stats := &statsv1alpha1.NetworkStats{Time: metav1.NewTime(sampled)}
+	for _, networkInterface := range interfaces {
+		interfaceStats := statsv1alpha1.InterfaceStats{
+			Name:     networkInterface.Name,
+			RxBytes:  &networkInterface.RxBytes,
+			RxErrors: &networkInterface.RxErrors,
+			TxBytes:  &networkInterface.TxBytes,
+			TxErrors: &networkInterface.TxErrors,
+		}
+		stats.Interfaces = append(stats.Interfaces, interfaceStats)
+		if networkInterface.Name == "eth0" {
+			stats.InterfaceStats = interfaceStats
+		}
+	}
+	if stats.InterfaceStats.Name == "" {
+		stats.InterfaceStats = stats.Interfaces[0]
+	}
+
+	return stats
+}
+
+// filesystemStats returns the usage of a filesystem by a container, along with the capacity of the filesystem holding path
+func filesystemStats(path string, used uint64, sampled time.Time) *statsv1alpha1.FsStats {
+	stats := &statsv1alpha1.FsStats{Time: metav1.NewTime(sampled), UsedBytes: &used}
+
+	var statfs syscall.Statfs_t
+	if syscall.Statfs(path, &statfs) == nil {
+		capacity := statfs.Blocks * uint64(statfs.Bsize)
+		available := statfs.Bavail * uint64(statfs.Bsize)
+		inodes := statfs.Files
+		inodesFree := statfs.Ffree
+		stats.CapacityBytes = &capacity
+		stats.AvailableBytes = &available
+		stats.Inodes = &inodes
+		stats.InodesFree = &inodesFree
+	}
+
+	return stats
+}
@@ -0,0 +1,240 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api/statsv1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+func TestUsageNanoCores(t *testing.T) {
+	start := time.Now()
+
+	tests := []struct {
+		name    string
+		samples []containerruntime.ContainerStats
+		// want is the rate returned for the last sample, -1 for nil
+		want int64
+	}{
+		{name: "first sample", samples: []containerruntime.ContainerStats{{Time: start, CPUUsageNanoSeconds: 1e9}}, want: -1},
+		{name: "one core", samples: []containerruntime.ContainerStats{{Time: start, CPUUsageNanoSeconds: 1e9}, {Time: start.Add(2 * time.Second), CPUUsageNanoSeconds: 3e9}}, want: 1e9},
+		{name: "half a core", samples: []containerruntime.ContainerStats{{Time: start, CPUUsageNanoSeconds: 0}, {Time: start.Add(10 * time.Second), CPUUsageNanoSeconds: 5e9}}, want: 5e8},
+		{name: "idle", samples: []containerruntime.ContainerStats{{Time: start, CPUUsageNanoSeconds: 1e9}, {Time: start.Add(time.Second), CPUUsageNanoSeconds: 1e9}}, want: 0},
+		{name: "usage reset by a restart", samples: []containerruntime.ContainerStats{{Time: start, CPUUsageNanoSeconds: 5e9}, {Time: start.Add(time.Second), CPUUsageNanoSeconds: 1e8}}, want: -1},
+		{name: "same sample", samples: []containerruntime.ContainerStats{{Time: start, CPUUsageNanoSeconds: 1e9}, {Time: start, CPUUsageNanoSeconds: 2e9}}, want: -1},
+		{name: "after a reset", samples: []containerruntime.ContainerStats{{Time: start, CPUUsageNanoSeconds: 5e9}, {Time: start.Add(time.Second), CPUUsageNanoSeconds: 0}, {Time: start.Add(2 * time.Second), CPUUsageNanoSeconds: 2e9}}, want: 2e9},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			usage := NewUsageSamples()
+			var got *uint64
+			for _, sample := range test.samples {
+				got = usage.usageNanoCores("container-id", sample)
+			}
+			if test.want < 0 {
+				if got != nil {
+					t.Fatalf("got %d, want no rate", *got)
+				}
+				return
+			}
+			if got == nil || *got != uint64(test.want) {
+				t.Fatalf("got %v, want %d", got, test.want)
+			}
+		})
+	}
+
+	usage := NewUsageSamples()
+	usage.usageNanoCores("running", containerruntime.ContainerStats{Time: start})
+	usage.usageNanoCores("removed", containerruntime.ContainerStats{Time: start})
+	usage.prune(map[string]bool{"running": true})
+	if _, ok := usage.samples["removed"]; ok || len(usage.samples) != 1 {
+		t.Fatalf("got samples %v, want only the running container", usage.samples)
+	}
+}
+
+func containerStats(nanoCores *uint64, coreNanoSeconds uint64, memory uint64) statsv1alpha1.ContainerStats {
+	pageFaults := uint64(1)
+	return statsv1alpha1.ContainerStats{
+		CPU:    &statsv1alpha1.CPUStats{UsageNanoCores: nanoCores, UsageCoreNanoSeconds: &coreNanoSeconds},
+		Memory: &statsv1alpha1.MemoryStats{UsageBytes: &memory, WorkingSetBytes: &memory, RSSBytes: &memory, PageFaults: &pageFaults, MajorPageFaults: &pageFaults},
+	}
+}
+
+func TestSumContainerStats(t *testing.T) {
+	one, two := uint64(1e8), uint64(2e8)
+
+	tests := []struct {
+		name          string
+		containers    []statsv1alpha1.ContainerStats
+		wantNanoCores *uint64
+	}{
+		{name: "single container", containers: []statsv1alpha1.ContainerStats{containerStats(&one, 10, 100)}, wantNanoCores: &one},
+		{name: "rates known", containers: []statsv1alpha1.ContainerStats{containerStats(&one, 10, 100), containerStats(&one, 10, 100)}, wantNanoCores: &two},
+		{name: "rate unknown for the first container", containers: []statsv1alpha1.ContainerStats{containerStats(nil, 10, 100), containerStats(&one, 10, 100)}},
+		{name: "rate unknown for the last container", containers: []statsv1alpha1.ContainerStats{containerStats(&one, 10, 100), containerStats(nil, 10, 100)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cpu, memory := sumContainerStats(test.containers)
+
+			n := uint64(len(test.containers))
+			if *cpu.UsageCoreNanoSeconds != 10*n || *memory.UsageBytes != 100*n || *memory.WorkingSetBytes != 100*n || *memory.RSSBytes != 100*n || *memory.PageFaults != n || *memory.MajorPageFaults != n {
+				t.Fatalf("got %+v and %+v, want the sums of %d containers", cpu, memory, n)
+			}
+			if (cpu.UsageNanoCores == nil) != (test.wantNanoCores == nil) || (cpu.UsageNanoCores != nil && *cpu.UsageNanoCores != *test.wantNanoCores) {
+				t.Fatalf("got rate %v, want %v", cpu.UsageNanoCores, test.wantNanoCores)
+			}
+		})
+	}
+
+	// the usage of the containers is left untouched
+	containers := []statsv1alpha1.ContainerStats{containerStats(&one, 10, 100), containerStats(&one, 10, 100)}
+	sumContainerStats(containers)
+	if *containers[0].CPU.UsageNanoCores != one || *containers[0].Memory.UsageBytes != 100 {
+		t.Fatal("the usage of a container has been modified")
+	}
+}
+
+func TestNetworkStats(t *testing.T) {
+	sampled := time.Now()
+
+	tests := []struct {
+		name        string
+		interfaces  []containerruntime.InterfaceStats
+		wantDefault string
+		wantNames   []string
+	}{
+		{name: "no interface"},
+		{name: "eth0 is the default", interfaces: []containerruntime.InterfaceStats{{Name: "net1", RxBytes: 1}, {Name: "eth0", RxBytes: 2}}, wantDefault: "eth0", wantNames: []string{"eth0", "net1"}},
+		{name: "first interface without eth0", interfaces: []containerruntime.InterfaceStats{{Name: "net2", RxBytes: 1}, {Name: "net1", RxBytes: 2}}, wantDefault: "net1", wantNames: []string{"net1", "net2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := networkStats(test.interfaces, sampled)
+			if test.wantNames == nil {
+				if stats != nil {
+					t.Fatalf("got %+v, want no network", stats)
+				}
+				return
+			}
+
+			if stats.InterfaceStats.Name != test.wantDefault || !stats.Time.Equal(&metav1.Time{Time: sampled}) {
+				t.Fatalf("got default interface %s at %s, want %s", stats.InterfaceStats.Name, stats.Time, test.wantDefault)
+			}
+			var names []string
+			for _, networkInterface := range stats.Interfaces {
+				names = append(names, networkInterface.Name)
+			}
+			if len(names) != len(test.wantNames) || names[0] != test.wantNames[0] || names[1] != test.wantNames[1] {
+				t.Fatalf("got interfaces %v, want %v", names, test.wantNames)
+			}
+			// every interface reports its own traffic
+			if *stats.Interfaces[0].RxBytes == *stats.Interfaces[1].RxBytes {
+				t.Fatal("the interfaces share their traffic")
+			}
+		})
+	}
+
+	// the interfaces with the same name are added up, for containers having network namespaces of their own
+	sum := sumInterfaces([][]containerruntime.InterfaceStats{{{Name: "eth0", RxBytes: 1, TxBytes: 2}}, {{Name: "eth0", RxBytes: 3, TxBytes: 4}}})
+	if len(sum) != 1 || sum[0].RxBytes != 4 || sum[0].TxBytes != 6 {
+		t.Fatalf("got %+v, want the traffic of eth0 added up", sum)
+	}
+}
+
+func TestStatsHandlerWorkers(t *testing.T) {
+	ctx := context.Background()
+	running := containerruntime.ContainerState{Status: "running", Running: true, StartedAt: time.Now()}
+	runtime := newFakeRuntime()
+	runtime.statsDelay = 20 * time.Millisecond
+
+	supervisors := NewPodSupervisors(ctx, nil, nil)
+	for i := 0; i < 2*statsWorkers; i++ {
+		podUID := "pod-" + strconv.Itoa(i)
+		containerName := "ns-" + podUID + "-app"
+		runtime.set(containerruntime.ContainerInfo{Name: containerName, State: running})
+		runtime.stats[containerName] = containerruntime.ContainerStats{Time: time.Now(), RootfsUsedBytes: 4096}
+		supervisors.supervisors[podUID] = &PodSupervisor{
+			Sandbox:      Sandbox{PodUID: podUID, Runtime: runtime},
+			PodName:      podUID,
+			PodNamespace: "ns",
+			containers:   map[string]*supervisedContainer{containerName: {name: "app", spec: v1.Container{Name: "app"}}},
+		}
+	}
+
+	handler := &SidecarHandler{
+		Ctx:         ctx,
+		Supervisors: supervisors,
+		StatusCache: NewStatusCache(ctx, nil),
+		Logs:        &LogArchive{Ctx: ctx, Root: t.TempDir()},
+		Usage:       NewUsageSamples(),
+	}
+
+	// the size of the writable layers is measured by the first request only, and reported by both
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		handler.StatsHandler(recorder, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("got %d, want 200", recorder.Code)
+		}
+
+		var summary statsv1alpha1.Summary
+		if err := json.Unmarshal(recorder.Body.Bytes(), &summary); err != nil {
+			t.Fatal(err)
+		}
+		if len(summary.Pods) != 2*statsWorkers {
+			t.Fatalf("got the usage of %d PODs, want %d", len(summary.Pods), 2*statsWorkers)
+		}
+		for _, pod := range summary.Pods {
+			if rootfs := pod.Containers[0].Rootfs; rootfs == nil || rootfs.UsedBytes == nil || *rootfs.UsedBytes != 4096 {
+				t.Fatalf("got rootfs %+v for POD %s, want 4096 bytes used", rootfs, pod.PodRef.UID)
+			}
+		}
+	}
+	if runtime.maxStatsInFlight != statsWorkers {
+		t.Fatalf("got %d PODs read at the same time, want %d", runtime.maxStatsInFlight, statsWorkers)
+	}
+	if len(runtime.sizes) != 2*statsWorkers {
+		t.Fatalf("measured %d writable layers, want every one measured once", len(runtime.sizes))
+	}
+}
+
+func TestRootfsSize(t *testing.T) {
+	usage := NewUsageSamples()
+
+	if !usage.rootfsSizeDue("id") {
+		t.Fatal("the size of a new container is not due")
+	}
+	if got := usage.rootfsUsedBytes("id", containerruntime.ContainerStats{RootfsUsedBytes: 4096}, true); got != 4096 {
+		t.Fatalf("got %d bytes, want 4096", got)
+	}
+	if usage.rootfsSizeDue("id") {
+		t.Fatal("the size just measured is due")
+	}
+	// a sample without size keeps the last measured one
+	if got := usage.rootfsUsedBytes("id", containerruntime.ContainerStats{}, false); got != 4096 {
+		t.Fatalf("got %d bytes, want the last measured 4096", got)
+	}
+
+	usage.rootfsSizes["id"] = rootfsSize{usedBytes: 4096, measured: time.Now().Add(-rootfsSizePeriod)}
+	if !usage.rootfsSizeDue("id") {
+		t.Fatal("the size measured a period ago is not due")
+	}
+
+	usage.prune(map[string]bool{})
+	if _, ok := usage.rootfsSizes["id"]; ok {
+		t.Fatal("the size of a removed container is kept")
+	}
+}
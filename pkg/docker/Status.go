@@ -5,20 +5,20 @@ import (
 	"io"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
-	exec "github.com/alexellis/go-execute/pkg/v1"
 	"github.com/containerd/containerd/log"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	trace "go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
 )
 
-// StatusHandler checks Docker Container's status by running docker ps -af command and returns that status
+// StatusHandler checks Docker Container's status by inspecting the containers inside the sandbox of each POD and returns that status
 func (h *SidecarHandler) StatusHandler(w http.ResponseWriter, r *http.Request) {
 	log.G(h.Ctx).Info("\u23F3 [STATUS CALL] received get status call")
 
@@ -50,152 +50,253 @@ func (h *SidecarHandler) StatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for i, pod := range req {
+	// every POD gets its own status, an error affecting a POD does not prevent the others from being reported
+	var podErrors []string
+	for _, pod := range req {
+		podStatus, err := h.podStatus(*pod)
+		if err != nil {
+			log.G(h.Ctx).Error("\u274C [STATUS CALL] Error retrieving the status of POD " + string(pod.UID) + ": " + err.Error())
+			podErrors = append(podErrors, string(pod.UID)+": "+err.Error())
+			podStatus = waitingPodStatus(*pod, "StatusUnknown", err.Error())
+		}
+		h.Metrics.SetPodPhase(*pod, podStatus)
+		resp = append(resp, podStatus)
+	}
+	if len(podErrors) > 0 {
+		span.SetAttributes(attribute.StringSlice("errors", podErrors))
+	}
+
+	bodyBytes, err = json.Marshal(resp)
+	if err != nil {
+		log.G(h.Ctx).Error(err)
+		statusCode = http.StatusInternalServerError
+		w.WriteHeader(statusCode)
+		w.Write([]byte("Some errors occurred while checking container status. Check Docker Sidecar's logs"))
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write(bodyBytes)
+	}
+
+	if err != nil {
+		span.SetAttributes(attribute.String("error", err.Error()))
+	}
+	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
+	span.End()
+
+}
 
-		podUID := string(pod.UID)
-		podNamespace := string(pod.Namespace)
+// podStatus returns the status of the containers of the POD. A POD whose sandbox does not exist, e.g. because its DIND container has been removed,
+// is reported as terminated, so that it can be reconciled.
+func (h *SidecarHandler) podStatus(pod v1.Pod) (commonIL.PodStatus, error) {
+	podUID := string(pod.UID)
+	podNamespace := string(pod.Namespace)
 
-		log.G(h.Ctx).Info("\u2705 [STATUS CALL] Pod UID: ", podUID)
+	log.G(h.Ctx).Info("\u2705 [STATUS CALL] Pod UID: ", podUID)
 
-		// send a docker command to retrieve the uuid of the dind container
-		// the command to exec is: docker inspect --format '{{.Id}}' podUID + "_dind"
-		cmd := []string{"inspect", "--format", "{{.Id}}", podUID + "_dind"}
-		shell := exec.ExecTask{
-			Command: "docker",
-			Args:    cmd,
-			Shell:   true,
+	// the POD has not been created yet, or its creation has failed
+	if h.CreateQueue.Pending(podUID) {
+		return waitingPodStatus(pod, "ContainerCreating", ""), nil
+	}
+	if message, failed := h.CreateQueue.Failure(podUID); failed {
+		return waitingPodStatus(pod, "CreateContainerError", message), nil
+	}
+
+	terminating := h.Supervisors.Terminating(podUID)
+
+	// retrieve the sandbox running the pod
+	sandbox, err := h.StatusCache.Sandbox(podUID)
+	if containerruntime.IsNotFound(err) && terminating {
+		// the sandbox has already been removed, the deletion of the pod is completing
+		supervisor, _ := h.Supervisors.Get(podUID)
+		podStatus := commonIL.PodStatus{PodName: pod.Name, PodUID: podUID, PodNamespace: podNamespace}
+		for _, container := range pod.Spec.InitContainers {
+			podStatus.InitContainers = append(podStatus.InitContainers, terminatedContainerStatus(supervisor, podNamespace+"-"+podUID+"-"+container.Name, container.Name))
 		}
-		execReturn, err := shell.Execute()
+		for _, container := range pod.Spec.Containers {
+			podStatus.Containers = append(podStatus.Containers, terminatedContainerStatus(supervisor, podNamespace+"-"+podUID+"-"+container.Name, container.Name))
+		}
+		return podStatus, nil
+	} else if containerruntime.IsNotFound(err) {
+		log.G(h.Ctx).Error("\u274C [STATUS CALL] The sandbox of POD " + podUID + " has not been found, reporting its containers as terminated")
+		return notFoundPodStatus(pod), nil
+	} else if err != nil {
+		return commonIL.PodStatus{}, err
+	}
+
+	log.G(h.Ctx).Info("\u2705 [STATUS CALL] ID of the sandbox retrieved successfully: ", sandbox.ID)
+
+	// the containers of a POD created before a restart of the sidecar are supervised again
+	h.Supervisors.Adopt(sandbox, pod)
+	supervisor, _ := h.Supervisors.Get(podUID)
+
+	podStatus := commonIL.PodStatus{PodName: pod.Name, PodUID: podUID, PodNamespace: podNamespace, JobID: sandbox.ID}
+
+	// check if the pod has initContainers and get their status
+	for _, container := range pod.Spec.InitContainers {
+		containerName := podNamespace + "-" + podUID + "-" + container.Name
+		containerStatus, err := h.containerStatus(sandbox, containerName, container)
 		if err != nil {
-			log.G(h.Ctx).Error(err)
-			statusCode = http.StatusInternalServerError
-			break
+			return commonIL.PodStatus{}, err
+		}
+		if supervisor != nil {
+			supervisor.containerStatus(containerName, &containerStatus)
 		}
+		if terminating {
+			containerStatus = terminatingContainerStatus(containerStatus)
+		}
+		podStatus.InitContainers = append(podStatus.InitContainers, containerStatus)
+	}
 
-		//dindUUID := strings.ReplaceAll(execReturn.Stdout, "\n", "")
-		dindUUID := strings.Join(strings.Fields(execReturn.Stdout), "")
-		log.G(h.Ctx).Info("\u2705 [STATUS CALL] UUID of the dind container retrieved successfully: ", dindUUID)
-
-		// if the string is empty or the length of the string is 0, return an error and 404 status code\
-		if len(dindUUID) == 0 || dindUUID == "" {
-			log.G(h.Ctx).Error("\u274C [STATUS CALL] Error retrieving UUID of the dind container")
-			statusCode = http.StatusNotFound
-			w.WriteHeader(statusCode)
-			w.Write([]byte("DIND container with UUID " + dindUUID + " not found. Maybe it was deleted or never existed."))
-			return
+	for _, container := range pod.Spec.Containers {
+		containerName := podNamespace + "-" + podUID + "-" + container.Name
+		containerStatus, err := h.containerStatus(sandbox, containerName, container)
+		if err != nil {
+			return commonIL.PodStatus{}, err
+		}
+		if supervisor != nil {
+			supervisor.containerStatus(containerName, &containerStatus)
+		}
+		if terminating {
+			containerStatus = terminatingContainerStatus(containerStatus)
 		}
+		podStatus.Containers = append(podStatus.Containers, containerStatus)
+	}
 
-		resp = append(resp, commonIL.PodStatus{PodName: pod.Name, PodUID: podUID, PodNamespace: podNamespace, JobID: dindUUID})
+	failInitializingContainers(pod, &podStatus)
 
-		// check if the pod has initContainers and get their status
-		for _, container := range pod.Spec.InitContainers {
-			containerName := podNamespace + "-" + podUID + "-" + container.Name
-			cmd := []string{"exec " + podUID + "_dind" + " docker ps -af name=^" + containerName + "$ --format \"{{.Status}}\""}
-			shell := exec.ExecTask{
-				Command: "docker",
-				Args:    cmd,
-				Shell:   true,
-			}
-			execReturn, err := shell.Execute()
-			execReturn.Stdout = strings.ReplaceAll(execReturn.Stdout, "\n", "")
+	return podStatus, nil
+}
 
-			if err != nil {
-				log.G(h.Ctx).Error(err)
-				statusCode = http.StatusInternalServerError
-				break
-			}
+// failInitializingContainers reports the containers still waiting for the init containers as terminated, if an init container has failed while the POD must never be restarted.
+// As the containers are never going to start, the POD is reported as failed.
+func failInitializingContainers(pod v1.Pod, podStatus *commonIL.PodStatus) {
+	if pod.Spec.RestartPolicy != v1.RestartPolicyNever {
+		return
+	}
 
-			log.G(h.Ctx).Info("\u2705 [STATUS CALL] Status of the init container retrieved successfully")
-
-			initContainerStatus := strings.Split(execReturn.Stdout, " ")
-
-			if execReturn.Stdout != "" {
-				log.G(h.Ctx).Info("\u2705 [STATUS CALL] The container " + container.Name + " is in the state: " + initContainerStatus[0])
-
-				if initContainerStatus[0] == "Created" {
-					resp[i].InitContainers = append(resp[i].InitContainers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{}}, Ready: false})
-				} else if initContainerStatus[0] == "Up" {
-					resp[i].InitContainers = append(resp[i].InitContainers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}, Ready: true})
-				} else if initContainerStatus[0] == "Exited" {
-					containerExitCode := strings.Split(initContainerStatus[1], "(")
-					exitCode, err := strconv.Atoi(strings.Trim(containerExitCode[1], ")"))
-					if err != nil {
-						log.G(h.Ctx).Error(err)
-						exitCode = 0
-					}
-					resp[i].InitContainers = append(resp[i].InitContainers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: int32(exitCode)}}, Ready: false})
-				}
-			} else {
-				resp[i].InitContainers = append(resp[i].InitContainers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{}}, Ready: false})
+	var initFailure *v1.ContainerStateTerminated
+	for i, status := range podStatus.InitContainers {
+		if i < len(pod.Spec.InitContainers) && isSidecar(pod.Spec.InitContainers[i]) {
+			continue
+		}
+		if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+			initFailure = &v1.ContainerStateTerminated{
+				ExitCode:   terminated.ExitCode,
+				Reason:     "Init:Error",
+				Message:    "Init container " + status.Name + " failed with exit code " + strconv.Itoa(int(terminated.ExitCode)),
+				FinishedAt: terminated.FinishedAt,
 			}
+			break
 		}
+	}
+	if initFailure == nil {
+		return
+	}
 
-		for _, container := range pod.Spec.Containers {
+	for i := range podStatus.Containers {
+		if podStatus.Containers[i].State.Waiting != nil {
+			podStatus.Containers[i].State = v1.ContainerState{Terminated: initFailure.DeepCopy()}
+			podStatus.Containers[i].Ready = false
+		}
+	}
+}
 
-			containerName := podNamespace + "-" + podUID + "-" + container.Name
-			cmd := []string{"exec " + podUID + "_dind" + " docker ps -af name=^" + containerName + "$ --format \"{{.Status}}\""}
+// containerStatus reads the state of a container of the POD from the status cache and translates it to a v1.ContainerStatus
+func (h *SidecarHandler) containerStatus(sandbox Sandbox, containerName string, container v1.Container) (v1.ContainerStatus, error) {
+	containerInfo, err := h.StatusCache.Inspect(sandbox, containerName)
+	if containerruntime.IsNotFound(err) {
+		return v1.ContainerStatus{Name: container.Name, Image: container.Image, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}, Ready: false}, nil
+	} else if err != nil {
+		return v1.ContainerStatus{}, err
+	}
 
-			shell := exec.ExecTask{
-				Command: "docker",
-				Args:    cmd,
-				Shell:   true,
-			}
-			execReturn, err := shell.Execute()
-			execReturn.Stdout = strings.ReplaceAll(execReturn.Stdout, "\n", "")
+	log.G(h.Ctx).Info("\u2705 [STATUS CALL] The container " + container.Name + " is in the state: " + containerInfo.State.Status)
 
-			if err != nil {
-				log.G(h.Ctx).Error(err)
-				statusCode = http.StatusInternalServerError
-				break
-			}
+	containerID := containerIDScheme(sandbox.Runtime) + containerInfo.ID
+	status := v1.ContainerStatus{
+		Name:        container.Name,
+		Image:       containerInfo.Image,
+		ImageID:     containerInfo.ImageID,
+		ContainerID: containerID,
+	}
+	if status.Image == "" {
+		status.Image = container.Image
+	}
 
-			log.G(h.Ctx).Info("\u2705 [STATUS CALL] Status of the container retrieved successfully")
-
-			containerstatus := strings.Split(execReturn.Stdout, " ")
-
-			if execReturn.Stdout != "" {
-				log.G(h.Ctx).Info("\u2705 [STATUS CALL] The container " + container.Name + " is in the state: " + containerstatus[0])
-
-				if containerstatus[0] == "Created" {
-					resp[i].Containers = append(resp[i].Containers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{}}, Ready: false})
-				} else if containerstatus[0] == "Up" {
-					resp[i].Containers = append(resp[i].Containers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}, Ready: true})
-				} else if containerstatus[0] == "Exited" {
-					containerExitCode := strings.Split(containerstatus[1], "(")
-					exitCode, err := strconv.Atoi(strings.Trim(containerExitCode[1], ")"))
-					if err != nil {
-						log.G(h.Ctx).Error(err)
-						exitCode = 0
-					}
-					resp[i].Containers = append(resp[i].Containers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: int32(exitCode)}}, Ready: false})
-					// release all the GPUs from the container
-					//h.GpuManager.Release(containerName)
-				}
-			} else {
-				resp[i].Containers = append(resp[i].Containers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{}}, Ready: false})
-			}
-		}
+	switch containerInfo.State.Status {
+	case "running", "paused":
+		status.State = v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: metav1.NewTime(containerInfo.State.StartedAt)}}
+		status.Ready = true
+	case "exited", "dead":
+		terminated := terminatedState(containerInfo.State)
+		terminated.ContainerID = containerID
+		status.State = v1.ContainerState{Terminated: terminated}
+	default:
+		status.State = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}
 	}
 
-	w.WriteHeader(statusCode)
+	return status, nil
+}
 
-	if statusCode != http.StatusOK {
-		w.Write([]byte("Some errors occurred while checking container status. Check Docker Sidecar's logs"))
-	} else {
-		bodyBytes, err = json.Marshal(resp)
-		if err != nil {
-			log.G(h.Ctx).Error(err)
-			statusCode = http.StatusInternalServerError
-			w.WriteHeader(statusCode)
-			w.Write([]byte("Some errors occurred while checking container status. Check Docker Sidecar's logs"))
-		}
-		w.Write(bodyBytes)
+// containerIDScheme returns the prefix of the container IDs, which names the runtime running the container as the kubelet does
+func containerIDScheme(runtime containerruntime.ContainerRuntime) string {
+	switch runtime.(type) {
+	case *containerruntime.PodmanRuntime:
+		return "podman://"
+	case *containerruntime.ApptainerRuntime:
+		return "apptainer://"
+	default:
+		return "docker://"
 	}
+}
 
-	if err != nil {
-		span.SetAttributes(attribute.String("error", err.Error()))
+// waitingPodStatus reports all the containers of the POD as waiting for the given reason
+func waitingPodStatus(pod v1.Pod, reason string, message string) commonIL.PodStatus {
+	podStatus := commonIL.PodStatus{PodName: pod.Name, PodUID: string(pod.UID), PodNamespace: pod.Namespace}
+	for _, container := range pod.Spec.InitContainers {
+		podStatus.InitContainers = append(podStatus.InitContainers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason, Message: message}}})
 	}
-	commonIL.SetDurationSpan(start, span, commonIL.WithHTTPReturnCode(statusCode))
-	span.End()
+	for _, container := range pod.Spec.Containers {
+		podStatus.Containers = append(podStatus.Containers, v1.ContainerStatus{Name: container.Name, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason, Message: message}}})
+	}
+	return podStatus
+}
+
+// notFoundPodStatus reports all the containers of a POD without a sandbox as terminated for good.
+// As the kubelet does for the containers it has lost track of, they are reported with exit code 137.
+func notFoundPodStatus(pod v1.Pod) commonIL.PodStatus {
+	terminated := v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+		ExitCode: 137,
+		Reason:   "NotFound",
+		Message:  "The sandbox of the pod has not been found. Maybe it was deleted or never existed.",
+	}}
+
+	podStatus := commonIL.PodStatus{PodName: pod.Name, PodUID: string(pod.UID), PodNamespace: pod.Namespace}
+	for _, container := range pod.Spec.InitContainers {
+		podStatus.InitContainers = append(podStatus.InitContainers, v1.ContainerStatus{Name: container.Name, Image: container.Image, State: terminated})
+	}
+	for _, container := range pod.Spec.Containers {
+		podStatus.Containers = append(podStatus.Containers, v1.ContainerStatus{Name: container.Name, Image: container.Image, State: terminated})
+	}
+	return podStatus
+}
 
+// terminatingContainerStatus adapts the status of a container of a POD being deleted: the containers still running are no longer ready,
+// and the ones already removed are reported as terminated
+func terminatingContainerStatus(status v1.ContainerStatus) v1.ContainerStatus {
+	status.Ready = false
+	if status.State.Running == nil && status.State.Terminated == nil {
+		status.State = v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "Terminating", Message: "The pod is being deleted"}}
+	}
+	return status
+}
+
+// terminatedContainerStatus reports a container removed with the sandbox of the POD being deleted, with the failure of its preStop hook recorded by the supervisor, if any
+func terminatedContainerStatus(supervisor *PodSupervisor, containerName string, name string) v1.ContainerStatus {
+	status := terminatingContainerStatus(v1.ContainerStatus{Name: name})
+	if supervisor != nil {
+		supervisor.containerStatus(containerName, &status)
+	}
+	return status
 }
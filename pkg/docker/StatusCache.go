@@ -0,0 +1,277 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// statusResyncPeriod is the interval between two full resyncs of the status cache, which recover the events missed by the cache
+const statusResyncPeriod = 60 * time.Second
+
+// StatusCache keeps the state of the containers of the PODs in memory, so that the status requests are answered without querying the runtimes.
+// The sandboxes of the PODs are cached, and the containers of every runtime are tracked through its event stream, with a periodic full resync as a safety net.
+type StatusCache struct {
+	Ctx       context.Context
+	Sandboxes SandboxManager
+
+	mu sync.Mutex
+	// sandboxes holds the sandboxes of the PODs, by POD UID
+	sandboxes map[string]Sandbox
+	// watchers are indexed by runtime: a runtime is shared by all the PODs it runs, e.g. in direct mode, or dedicated to a single POD, e.g. the daemon of a DIND container
+	watchers map[containerruntime.ContainerRuntime]*runtimeWatcher
+}
+
+// runtimeWatcher tracks the containers of a runtime through its events
+type runtimeWatcher struct {
+	runtime containerruntime.ContainerRuntime
+	cancel  context.CancelFunc
+	// pods are the UIDs of the PODs run by the runtime
+	pods map[string]bool
+	// refreshMu serializes the inspections of the containers, so that the state of a container is never replaced by an older one
+	refreshMu sync.Mutex
+	// synced is set once the containers have been listed, while the events of the runtime are received
+	synced bool
+	// containers are indexed by name
+	containers map[string]containerruntime.ContainerInfo
+}
+
+func NewStatusCache(ctx context.Context, sandboxes SandboxManager) *StatusCache {
+	return &StatusCache{
+		Ctx:       ctx,
+		Sandboxes: sandboxes,
+		sandboxes: make(map[string]Sandbox),
+		watchers:  make(map[containerruntime.ContainerRuntime]*runtimeWatcher),
+	}
+}
+
+// Sandbox returns the sandbox of the POD, retrieving it from the sandbox manager the first time and watching the containers of its runtime from then on
+func (c *StatusCache) Sandbox(podUID string) (Sandbox, error) {
+	c.mu.Lock()
+	sandbox, ok := c.sandboxes[podUID]
+	c.mu.Unlock()
+	if ok {
+		return sandbox, nil
+	}
+
+	sandbox, err := c.Sandboxes.Get(c.Ctx, podUID)
+	if err != nil {
+		return Sandbox{}, err
+	}
+
+	c.Watch(sandbox)
+
+	return sandbox, nil
+}
+
+// Watch caches the sandbox of the POD and watches the containers of its runtime, so that their state is read from memory.
+// The supervisor of the POD watches its sandbox from its creation, so that the state of its containers is never polled from the runtime.
+func (c *StatusCache) Watch(sandbox Sandbox) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sandboxes[sandbox.PodUID] = sandbox
+
+	watcher, ok := c.watchers[sandbox.Runtime]
+	if !ok {
+		ctx, cancel := context.WithCancel(c.Ctx)
+		watcher = &runtimeWatcher{runtime: sandbox.Runtime, cancel: cancel, pods: make(map[string]bool)}
+		c.watchers[sandbox.Runtime] = watcher
+		go c.watch(ctx, watcher)
+	}
+	watcher.pods[sandbox.PodUID] = true
+}
+
+// Inspect returns the state of a container of the sandbox. It is read from memory once the runtime of the sandbox is synced, and from the runtime until then.
+func (c *StatusCache) Inspect(sandbox Sandbox, containerName string) (containerruntime.ContainerInfo, error) {
+	c.mu.Lock()
+	watcher, ok := c.watchers[sandbox.Runtime]
+	if ok && watcher.synced {
+		containerInfo, found := watcher.containers[containerName]
+		c.mu.Unlock()
+		if !found {
+			return containerruntime.ContainerInfo{}, fmt.Errorf("%w: no such container %s", containerruntime.ErrNotFound, containerName)
+		}
+		return containerInfo, nil
+	}
+	c.mu.Unlock()
+
+	return sandbox.Runtime.Inspect(c.Ctx, containerName)
+}
+
+// Refresh inspects a container of the sandbox that has just been created or started, so that its state is read from memory
+// without waiting for the event of the runtime
+func (c *StatusCache) Refresh(sandbox Sandbox, containerName string) {
+	c.mu.Lock()
+	watcher, ok := c.watchers[sandbox.Runtime]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	c.refresh(c.Ctx, watcher, containerName)
+}
+
+// Forget drops the sandbox of a deleted POD, and stops watching its runtime if it runs no other POD
+func (c *StatusCache) Forget(podUID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sandbox, ok := c.sandboxes[podUID]
+	if !ok {
+		return
+	}
+	delete(c.sandboxes, podUID)
+
+	watcher, ok := c.watchers[sandbox.Runtime]
+	if !ok {
+		return
+	}
+	delete(watcher.pods, podUID)
+	if len(watcher.pods) == 0 {
+		watcher.cancel()
+		delete(c.watchers, sandbox.Runtime)
+	}
+}
+
+// watch updates the containers of the runtime on its events, and resyncs them periodically.
+// When the event stream fails, e.g. because a DIND container has stopped, the runtime and the sandboxes of its PODs are dropped,
+// so that they are retrieved again by the next status request.
+func (c *StatusCache) watch(ctx context.Context, watcher *runtimeWatcher) {
+	events, errs := watcher.runtime.Events(ctx)
+
+	// the containers are listed after subscribing to the events, so that no change is missed
+	err := c.resync(ctx, watcher)
+
+	ticker := time.NewTicker(statusResyncPeriod)
+	defer ticker.Stop()
+
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case err = <-errs:
+		case event := <-events:
+			c.update(ctx, watcher, event)
+		case <-ticker.C:
+			err = c.resync(ctx, watcher)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	watcher.cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	log.G(c.Ctx).Error("\u274C [STATUS CACHE] Stopped watching the containers of the PODs " + fmt.Sprint(watcher.podUIDs()) + ": " + err.Error())
+
+	if c.watchers[watcher.runtime] != watcher {
+		return
+	}
+	delete(c.watchers, watcher.runtime)
+	for podUID := range watcher.pods {
+		delete(c.sandboxes, podUID)
+	}
+}
+
+// resync replaces the cached containers of the runtime with the containers of the PODs it currently runs.
+// Only the containers that are new or whose state has changed since the last resync are inspected, the others keep their cached state.
+func (c *StatusCache) resync(ctx context.Context, watcher *runtimeWatcher) error {
+	watcher.refreshMu.Lock()
+	defer watcher.refreshMu.Unlock()
+
+	containers, err := watcher.runtime.List(ctx, containerruntime.ListOptions{All: true, Labels: map[string]string{PodUIDLabel: ""}})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	cachedByID := make(map[string]containerruntime.ContainerInfo, len(watcher.containers))
+	for _, cached := range watcher.containers {
+		cachedByID[cached.ID] = cached
+	}
+	c.mu.Unlock()
+
+	containerInfos := make(map[string]containerruntime.ContainerInfo, len(containers))
+	for _, container := range containers {
+		cached, ok := cachedByID[container.ID]
+		if ok && cached.Name == container.Name && cached.State.Status == container.State.Status {
+			containerInfos[cached.Name] = cached
+			continue
+		}
+
+		containerInfo, err := watcher.runtime.Inspect(ctx, container.ID)
+		if containerruntime.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		containerInfos[containerInfo.Name] = containerInfo
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	watcher.containers = containerInfos
+	watcher.synced = true
+
+	return nil
+}
+
+// update refreshes the cached state of the container the event refers to
+func (c *StatusCache) update(ctx context.Context, watcher *runtimeWatcher, event containerruntime.ContainerEvent) {
+	switch event.Action {
+	case "create", "start", "restart", "die", "oom", "pause", "unpause", "rename", "destroy":
+	default:
+		// e.g. the execs run by the probes, which do not change the state of the container
+		return
+	}
+
+	c.refresh(ctx, watcher, event.ID)
+}
+
+// refresh inspects a container of the runtime, by ID or by name, and replaces its cached state
+func (c *StatusCache) refresh(ctx context.Context, watcher *runtimeWatcher, id string) {
+	watcher.refreshMu.Lock()
+	defer watcher.refreshMu.Unlock()
+
+	containerInfo, err := watcher.runtime.Inspect(ctx, id)
+	if err != nil && !containerruntime.IsNotFound(err) {
+		log.G(c.Ctx).Error("\u274C [STATUS CACHE] Error inspecting container " + id + ": " + err.Error())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if watcher.containers == nil {
+		return
+	}
+
+	// the container may have been renamed, so it is looked up by ID
+	for name, cached := range watcher.containers {
+		if cached.ID == id || name == id || (err == nil && cached.ID == containerInfo.ID) {
+			delete(watcher.containers, name)
+		}
+	}
+	if err == nil {
+		watcher.containers[containerInfo.Name] = containerInfo
+	}
+}
+
+// podUIDs returns the UIDs of the PODs run by the runtime, the lock of the cache is held by the caller
+func (w *runtimeWatcher) podUIDs() []string {
+	var podUIDs []string
+	for podUID := range w.pods {
+		podUIDs = append(podUIDs, podUID)
+	}
+	return podUIDs
+}
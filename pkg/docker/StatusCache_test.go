@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+func TestStatusCacheResync(t *testing.T) {
+	podLabels := map[string]string{PodUIDLabel: "pod-uid"}
+	runtime := newFakeRuntime(
+		containerruntime.ContainerInfo{ID: "id-app", Name: "app", Labels: podLabels, State: containerruntime.ContainerState{Status: "running", Running: true}},
+		containerruntime.ContainerInfo{ID: "id-init", Name: "init", Labels: podLabels, State: exitedState(0, time.Second)},
+		containerruntime.ContainerInfo{ID: "id-other", Name: "other", State: containerruntime.ContainerState{Status: "running", Running: true}},
+	)
+	cache := NewStatusCache(context.Background(), nil)
+	watcher := &runtimeWatcher{runtime: runtime, pods: map[string]bool{"pod-uid": true}}
+
+	resync := func(wantInspections ...string) {
+		t.Helper()
+		runtime.inspections = nil
+		if err := cache.resync(context.Background(), watcher); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(runtime.inspections)
+		if !reflect.DeepEqual(runtime.inspections, wantInspections) {
+			t.Fatalf("inspected %v, want %v", runtime.inspections, wantInspections)
+		}
+	}
+	cached := func() []string {
+		var names []string
+		for name := range watcher.containers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// only the containers of the PODs are cached
+	resync("app", "init")
+	if got := cached(); !reflect.DeepEqual(got, []string{"app", "init"}) || !watcher.synced {
+		t.Fatalf("cached %v, want app and init", got)
+	}
+
+	// the unchanged containers are not inspected again
+	resync()
+
+	runtime.set(containerruntime.ContainerInfo{ID: "id-app", Name: "app", Labels: podLabels, State: exitedState(1, time.Second)})
+	resync("app")
+	if state := watcher.containers["app"].State; state.Status != "exited" || state.ExitCode != 1 {
+		t.Fatalf("got state %+v, want the new state of app", state)
+	}
+
+	// a recreated container has a new ID
+	runtime.Remove(context.Background(), "init", true)
+	runtime.set(containerruntime.ContainerInfo{ID: "id-init-2", Name: "init", Labels: podLabels, State: exitedState(0, time.Second)})
+	resync("init")
+	if watcher.containers["init"].ID != "id-init-2" {
+		t.Fatalf("got %s, want the recreated init container", watcher.containers["init"].ID)
+	}
+
+	runtime.Remove(context.Background(), "init", true)
+	resync()
+	if got := cached(); !reflect.DeepEqual(got, []string{"app"}) {
+		t.Fatalf("cached %v, want the removed container dropped", got)
+	}
+}
+
+func TestStatusCacheRefresh(t *testing.T) {
+	podLabels := map[string]string{PodUIDLabel: "pod-uid"}
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{ID: "id-app", Name: "app", Labels: podLabels, State: exitedState(1, time.Second)})
+	sandbox := Sandbox{PodUID: "pod-uid", Runtime: runtime}
+
+	cache := NewStatusCache(context.Background(), nil)
+	watcher := &runtimeWatcher{runtime: runtime, pods: map[string]bool{"pod-uid": true}}
+	cache.watchers[runtime] = watcher
+	if err := cache.resync(context.Background(), watcher); err != nil {
+		t.Fatal(err)
+	}
+
+	// the state is read from memory, until the container is refreshed
+	runtime.Start(context.Background(), "app")
+	runtime.inspections = nil
+	if containerInfo, err := cache.Inspect(sandbox, "app"); err != nil || containerInfo.State.Running || len(runtime.inspections) != 0 {
+		t.Fatalf("got %+v, %v with %d inspections, want the cached state", containerInfo.State, err, len(runtime.inspections))
+	}
+	cache.Refresh(sandbox, "app")
+	if containerInfo, err := cache.Inspect(sandbox, "app"); err != nil || !containerInfo.State.Running {
+		t.Fatalf("got %+v, %v, want the refreshed state", containerInfo.State, err)
+	}
+
+	// a container created since the last resync is added, a removed one is dropped
+	runtime.Create(context.Background(), containerruntime.ContainerSpec{Name: "sidecar", Labels: podLabels})
+	cache.Refresh(sandbox, "sidecar")
+	runtime.Remove(context.Background(), "app", true)
+	cache.Refresh(sandbox, "app")
+	names := make([]string, 0, len(watcher.containers))
+	for name := range watcher.containers {
+		names = append(names, name)
+	}
+	if !reflect.DeepEqual(names, []string{"sidecar"}) {
+		t.Fatalf("cached %v, want sidecar", names)
+	}
+
+	// the containers of a runtime not watched are not cached
+	other := newFakeRuntime(containerruntime.ContainerInfo{Name: "app"})
+	cache.Refresh(Sandbox{PodUID: "other", Runtime: other}, "app")
+	if len(other.inspections) != 0 {
+		t.Fatalf("inspected %v, want nothing", other.inspections)
+	}
+}
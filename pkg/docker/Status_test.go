@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+
+	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
+)
+
+func TestFailInitializingContainers(t *testing.T) {
+	always := v1.ContainerRestartPolicyAlways
+	waiting := v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "PodInitializing"}}
+	failed := v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 2, Reason: "Error"}}
+	completed := v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "Completed"}}
+	running := v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+
+	tests := []struct {
+		name           string
+		restartPolicy  v1.RestartPolicy
+		initContainers []v1.Container
+		initStates     []v1.ContainerState
+		wantFailed     bool
+	}{
+		{name: "failed, Never", restartPolicy: v1.RestartPolicyNever, initContainers: []v1.Container{{Name: "init"}}, initStates: []v1.ContainerState{failed}, wantFailed: true},
+		{name: "failed after a completed one, Never", restartPolicy: v1.RestartPolicyNever, initContainers: []v1.Container{{Name: "first"}, {Name: "init"}}, initStates: []v1.ContainerState{completed, failed}, wantFailed: true},
+		{name: "failed, OnFailure", restartPolicy: v1.RestartPolicyOnFailure, initContainers: []v1.Container{{Name: "init"}}, initStates: []v1.ContainerState{failed}},
+		{name: "failed, Always", restartPolicy: v1.RestartPolicyAlways, initContainers: []v1.Container{{Name: "init"}}, initStates: []v1.ContainerState{failed}},
+		{name: "running, Never", restartPolicy: v1.RestartPolicyNever, initContainers: []v1.Container{{Name: "init"}}, initStates: []v1.ContainerState{running}},
+		{name: "completed, Never", restartPolicy: v1.RestartPolicyNever, initContainers: []v1.Container{{Name: "init"}}, initStates: []v1.ContainerState{completed}},
+		{name: "sidecar failed, Never", restartPolicy: v1.RestartPolicyNever, initContainers: []v1.Container{{Name: "proxy", RestartPolicy: &always}}, initStates: []v1.ContainerState{failed}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := v1.Pod{Spec: v1.PodSpec{RestartPolicy: test.restartPolicy, InitContainers: test.initContainers}}
+			podStatus := commonIL.PodStatus{Containers: []v1.ContainerStatus{{Name: "app", State: waiting}, {Name: "running", State: running}}}
+			for i, state := range test.initStates {
+				podStatus.InitContainers = append(podStatus.InitContainers, v1.ContainerStatus{Name: test.initContainers[i].Name, State: state})
+			}
+
+			failInitializingContainers(pod, &podStatus)
+
+			app := podStatus.Containers[0]
+			if !test.wantFailed {
+				if app.State.Waiting == nil {
+					t.Fatalf("got state %+v, want waiting", app.State)
+				}
+				return
+			}
+			if app.State.Terminated == nil || app.State.Terminated.Reason != "Init:Error" || app.State.Terminated.ExitCode != 2 {
+				t.Fatalf("got state %+v, want terminated by Init:Error with exit code 2", app.State)
+			}
+			if podStatus.Containers[1].State.Running == nil {
+				t.Fatal("a running container has been reported as terminated")
+			}
+		})
+	}
+}
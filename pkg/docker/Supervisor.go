@@ -0,0 +1,694 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// the containers are restarted with the same backoff as the kubelet
+const (
+	initialRestartBackoff = 10 * time.Second
+	maxRestartBackoff     = 5 * time.Minute
+	// the backoff of a container is reset once it has run for this long
+	restartBackoffReset = 10 * time.Minute
+	// supervisorPeriod is the interval between two checks of the containers of a POD
+	supervisorPeriod = 1 * time.Second
+)
+
+// supervisedContainer tracks a container of the POD across its restarts
+type supervisedContainer struct {
+	// name is the name of the container in the POD spec
+	name          string
+	restartPolicy v1.RestartPolicy
+	restartCount  int32
+	// backoff is the delay applied to the next restart
+	backoff time.Duration
+	// restartAt is the time of the next restart, zero if no restart is scheduled
+	restartAt time.Time
+	// restartDelay is the backoff applied to the scheduled restart
+	restartDelay time.Duration
+	// lastTermination is the last termination of the container that led to a restart
+	lastTermination *v1.ContainerStateTerminated
+	// stopMessage explains why the container has been stopped by the supervisor, e.g. after failing its liveness probe
+	stopMessage string
+	// stopReason replaces the reason of the termination of the container stopped by the supervisor, e.g. PreStopHookError
+	stopReason string
+	// createError is the error that prevented the creation of the container
+	createError error
+
+	spec   v1.Container
+	isInit bool
+	// sidecar is set on the init containers that keep running beside the containers of the POD
+	sidecar bool
+	// probing is set once the probes of the container have been started
+	probing bool
+	// probedStartedAt is the start of the execution of the container the probe results refer to
+	probedStartedAt time.Time
+	started         bool
+	ready           bool
+}
+
+// PodSupervisor runs the containers of a POD inside its sandbox and restarts them according to the restart policy of the POD
+type PodSupervisor struct {
+	Sandbox      Sandbox
+	PodName      string
+	PodNamespace string
+	Ctx          context.Context
+	// Logs archives the logs of the containers of the POD
+	Logs *LogArchive
+	// Status holds the state of the containers of the POD, which is read from it rather than polled from the runtime
+	Status *StatusCache
+
+	terminationGracePeriod time.Duration
+
+	mu sync.Mutex
+	// initialized is set once all the init containers have completed successfully
+	initialized bool
+	// containers are indexed by the name of the container in the runtime
+	containers map[string]*supervisedContainer
+	// sidecars are the names of the sidecar containers in the runtime, in the order in which they are started
+	sidecars []string
+	// sidecarsStopped is set once the sidecars have been stopped because all the containers of the POD have completed
+	sidecarsStopped bool
+	cancel          context.CancelFunc
+	done            chan struct{}
+}
+
+// PodSupervisors holds the supervisor of every POD, by POD UID, and keeps track of the PODs being deleted
+type PodSupervisors struct {
+	Ctx    context.Context
+	Logs   *LogArchive
+	Status *StatusCache
+
+	mu          sync.Mutex
+	supervisors map[string]*PodSupervisor
+	// terminating holds the stopped supervisors of the PODs being deleted, nil for the PODs without supervisor, by POD UID.
+	// They keep the state of the containers, e.g. the failures of their preStop hooks, until the deletion has completed.
+	terminating map[string]*PodSupervisor
+}
+
+func NewPodSupervisors(ctx context.Context, logs *LogArchive, status *StatusCache) *PodSupervisors {
+	return &PodSupervisors{Ctx: ctx, Logs: logs, Status: status, supervisors: make(map[string]*PodSupervisor), terminating: make(map[string]*PodSupervisor)}
+}
+
+// Start runs the init containers and the containers of the POD in background and supervises them until Stop is called
+func (s *PodSupervisors) Start(sandbox Sandbox, pod v1.Pod, initContainers []DockerRunStruct, containers []DockerRunStruct) {
+	if s.Terminating(sandbox.PodUID) {
+		// the POD has been deleted while it was being created
+		return
+	}
+
+	supervisor := s.newSupervisor(sandbox, pod)
+	go supervisor.run(initContainers, containers)
+}
+
+// Adopt supervises the containers of a POD created before a restart of the sidecar.
+// Only the containers already existing in the sandbox are supervised, their restart counts start again from zero.
+func (s *PodSupervisors) Adopt(sandbox Sandbox, pod v1.Pod) {
+	s.mu.Lock()
+	_, ok := s.supervisors[sandbox.PodUID]
+	_, terminating := s.terminating[sandbox.PodUID]
+	s.mu.Unlock()
+	if ok || terminating {
+		return
+	}
+
+	log.G(s.Ctx).Info("\u2705 [POD FLOW] Adopting the containers of POD " + sandbox.PodUID)
+
+	supervisor := s.newSupervisor(sandbox, pod)
+	go supervisor.run(nil, nil)
+}
+
+func (s *PodSupervisors) newSupervisor(sandbox Sandbox, pod v1.Pod) *PodSupervisor {
+	ctx, cancel := context.WithCancel(s.Ctx)
+
+	supervisor := &PodSupervisor{
+		Sandbox:      sandbox,
+		PodName:      pod.Name,
+		PodNamespace: pod.Namespace,
+		Ctx:          ctx,
+		Logs:         s.Logs,
+		Status:       s.Status,
+		containers:   make(map[string]*supervisedContainer),
+		cancel:       cancel,
+		done:         make(chan struct{}),
+
+		terminationGracePeriod: terminationGracePeriod(pod),
+	}
+
+	restartPolicy := pod.Spec.RestartPolicy
+	if restartPolicy == "" {
+		restartPolicy = v1.RestartPolicyAlways
+	}
+	for _, container := range pod.Spec.Containers {
+		containerName := pod.Namespace + "-" + string(pod.UID) + "-" + container.Name
+		supervisor.containers[containerName] = &supervisedContainer{name: container.Name, restartPolicy: restartPolicy, spec: container}
+	}
+
+	// a failed init container is retried, unless the POD must never be restarted
+	initRestartPolicy := v1.RestartPolicyOnFailure
+	if restartPolicy == v1.RestartPolicyNever {
+		initRestartPolicy = v1.RestartPolicyNever
+	}
+	for _, container := range pod.Spec.InitContainers {
+		containerName := pod.Namespace + "-" + string(pod.UID) + "-" + container.Name
+		if isSidecar(container) {
+			// a sidecar is always restarted, whatever the restart policy of the POD
+			supervisor.containers[containerName] = &supervisedContainer{name: container.Name, restartPolicy: v1.RestartPolicyAlways, spec: container, isInit: true, sidecar: true}
+			supervisor.sidecars = append(supervisor.sidecars, containerName)
+			continue
+		}
+		supervisor.containers[containerName] = &supervisedContainer{name: container.Name, restartPolicy: initRestartPolicy, spec: container, isInit: true}
+	}
+
+	s.mu.Lock()
+	previous := s.supervisors[sandbox.PodUID]
+	s.supervisors[sandbox.PodUID] = supervisor
+	s.mu.Unlock()
+
+	if previous != nil {
+		previous.stop()
+	}
+
+	return supervisor
+}
+
+// Get returns the supervisor of the POD, if any, or the stopped supervisor of the POD being deleted
+func (s *PodSupervisors) Get(podUID string) (*PodSupervisor, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if supervisor, ok := s.supervisors[podUID]; ok {
+		return supervisor, true
+	}
+	supervisor := s.terminating[podUID]
+	return supervisor, supervisor != nil
+}
+
+// List returns the supervisors of all the PODs
+func (s *PodSupervisors) List() []*PodSupervisor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	supervisors := make([]*PodSupervisor, 0, len(s.supervisors))
+	for _, supervisor := range s.supervisors {
+		supervisors = append(supervisors, supervisor)
+	}
+	return supervisors
+}
+
+// Stop stops supervising the POD and waits for its supervisor to return, so that no container is restarted afterwards
+func (s *PodSupervisors) Stop(podUID string) {
+	s.mu.Lock()
+	supervisor, ok := s.supervisors[podUID]
+	delete(s.supervisors, podUID)
+	s.mu.Unlock()
+
+	if ok {
+		supervisor.stop()
+	}
+}
+
+// StartTermination stops supervising the POD and marks it as being deleted.
+// It returns false if the POD is already being deleted.
+func (s *PodSupervisors) StartTermination(podUID string) bool {
+	s.mu.Lock()
+	if _, ok := s.terminating[podUID]; ok {
+		s.mu.Unlock()
+		return false
+	}
+	supervisor, ok := s.supervisors[podUID]
+	delete(s.supervisors, podUID)
+	s.terminating[podUID] = supervisor
+	s.mu.Unlock()
+
+	if ok {
+		supervisor.stop()
+	}
+	return true
+}
+
+// EndTermination marks the deletion of the POD as completed
+func (s *PodSupervisors) EndTermination(podUID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.terminating, podUID)
+}
+
+// Terminating reports whether the POD is being deleted
+func (s *PodSupervisors) Terminating(podUID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.terminating[podUID]
+	return ok
+}
+
+func (p *PodSupervisor) stop() {
+	p.cancel()
+	<-p.done
+}
+
+func (p *PodSupervisor) run(initContainers []DockerRunStruct, containers []DockerRunStruct) {
+	defer close(p.done)
+
+	// the state of the containers is read from the status cache, which follows the events of the runtime
+	p.Status.Watch(p.Sandbox)
+
+	if len(initContainers) > 0 {
+
+		log.G(p.Ctx).Info("\u2705 [POD FLOW] Start creating init containers")
+
+		// Run init containers sequentially, the containers are not started until all of them have completed successfully
+		for _, initContainer := range initContainers {
+			if initContainer.IsSidecar {
+				log.G(p.Ctx).Info("\u2705 [POD FLOW] Starting sidecar container: " + initContainer.Name)
+
+				if !p.startSidecar(initContainer) {
+					return
+				}
+				continue
+			}
+
+			log.G(p.Ctx).Info("\u2705 [POD FLOW] Executing init container: " + initContainer.Name)
+
+			if !p.runInitContainer(initContainer) {
+				return
+			}
+		}
+
+		log.G(p.Ctx).Info("\u2705 [POD FLOW] All init containers created and executed successfully")
+	}
+
+	p.mu.Lock()
+	p.initialized = true
+	p.mu.Unlock()
+
+	for _, container := range containers {
+		err := runContainer(p.Ctx, p.Sandbox, container)
+		if err != nil {
+			log.G(p.Ctx).Error("\u274C [POD FLOW] An error occurred during the run of the container " + container.Name + ": " + err.Error())
+			p.createFailed(container.Name, err)
+			continue
+		}
+		p.Status.Refresh(p.Sandbox, container.Name)
+		p.archiveLogs(container.Name)
+		p.postStart(container.Name)
+	}
+
+	if len(containers) > 0 {
+		log.G(p.Ctx).Info("\u2705 [POD FLOW] Containers created successfully")
+	}
+
+	for containerName, container := range p.containers {
+		// the logs of the containers adopted after a restart of the sidecar are archived from now on
+		p.archiveLogs(containerName)
+		if !container.isInit || container.sidecar {
+			p.startProbes(containerName)
+		}
+	}
+
+	ticker := time.NewTicker(supervisorPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.Ctx.Done():
+			return
+		case <-ticker.C:
+			p.stopSidecarsOnCompletion()
+
+			for containerName, container := range p.containers {
+				// the init containers have already completed, while the sidecars keep running until the containers complete
+				if !container.isInit || (container.sidecar && !p.sidecarsStopped) {
+					p.check(containerName)
+				}
+			}
+		}
+	}
+}
+
+// runInitContainer runs an init container until it completes successfully. A failed init container is restarted with backoff,
+// unless the restart policy of the POD is Never. It returns false if the init container cannot complete, in which case the containers of the POD are never started.
+func (p *PodSupervisor) runInitContainer(initContainer DockerRunStruct) bool {
+	err := runContainer(p.Ctx, p.Sandbox, initContainer)
+	if err != nil {
+		log.G(p.Ctx).Error("\u274C [POD FLOW] An error occurred during the run of the init container " + initContainer.Name + ": " + err.Error())
+		p.createFailed(initContainer.Name, err)
+		return false
+	}
+	p.Status.Refresh(p.Sandbox, initContainer.Name)
+	p.archiveLogs(initContainer.Name)
+
+	// Poll the container status until it completes
+	for {
+		select {
+		case <-p.Ctx.Done():
+			return false
+		case <-time.After(supervisorPeriod):
+		}
+
+		initContainerInfo, err := p.inspect(initContainer.Name)
+		if err != nil {
+			log.G(p.Ctx).Error("\u274C [POD FLOW] An error occurred during inspect of init container " + initContainer.Name + ": " + err.Error())
+			return false
+		}
+
+		state := initContainerInfo.State
+		if state.Status != "exited" && state.Status != "dead" {
+			continue
+		}
+
+		if state.ExitCode == 0 {
+			log.G(p.Ctx).Info("\u2705 [POD FLOW] Init container " + initContainer.Name + " has completed")
+			return true
+		}
+
+		if p.shouldRestart(initContainer.Name, initContainerInfo) {
+			log.G(p.Ctx).Info("\u2705 [POD FLOW] Restarting init container " + initContainer.Name)
+			err = p.Sandbox.Runtime.Start(p.Ctx, initContainer.Name)
+			if err != nil {
+				log.G(p.Ctx).Error("\u274C [POD FLOW] Error restarting init container " + initContainer.Name + ": " + err.Error())
+			}
+			p.Status.Refresh(p.Sandbox, initContainer.Name)
+		} else if p.containers[initContainer.Name].restartPolicy == v1.RestartPolicyNever {
+			log.G(p.Ctx).Error("\u274C [POD FLOW] Init container " + initContainer.Name + " has failed with exit code " + fmt.Sprint(state.ExitCode) + ", the POD is not started")
+			// the POD has failed, the sidecars already started have nothing left to serve
+			p.stopSidecars()
+			return false
+		}
+	}
+}
+
+// startSidecar starts a sidecar container and waits for it to be running and past its startup probe, so that the next init container can be run.
+// As a container, a sidecar terminating in the meantime is restarted with backoff. It returns false if the sidecar cannot be started.
+func (p *PodSupervisor) startSidecar(sidecar DockerRunStruct) bool {
+	err := runContainer(p.Ctx, p.Sandbox, sidecar)
+	if err != nil {
+		log.G(p.Ctx).Error("\u274C [POD FLOW] An error occurred during the run of the sidecar container " + sidecar.Name + ": " + err.Error())
+		p.createFailed(sidecar.Name, err)
+		return false
+	}
+	p.Status.Refresh(p.Sandbox, sidecar.Name)
+	p.archiveLogs(sidecar.Name)
+	p.postStart(sidecar.Name)
+	p.startProbes(sidecar.Name)
+
+	for {
+		select {
+		case <-p.Ctx.Done():
+			return false
+		case <-time.After(supervisorPeriod):
+		}
+
+		sidecarInfo, err := p.inspect(sidecar.Name)
+		if err != nil {
+			log.G(p.Ctx).Error("\u274C [POD FLOW] An error occurred during inspect of sidecar container " + sidecar.Name + ": " + err.Error())
+			return false
+		}
+
+		if sidecarInfo.State.Running && p.probeStarted(sidecar.Name, sidecarInfo.State.StartedAt) {
+			log.G(p.Ctx).Info("\u2705 [POD FLOW] Sidecar container " + sidecar.Name + " has started")
+			return true
+		}
+
+		p.check(sidecar.Name)
+	}
+}
+
+// stopSidecarsOnCompletion stops the sidecars, in the reverse order of their start, once all the containers of the POD have terminated and are not going to be restarted
+func (p *PodSupervisor) stopSidecarsOnCompletion() {
+	if len(p.sidecars) == 0 || p.sidecarsStopped || !p.initialized {
+		return
+	}
+
+	for containerName, container := range p.containers {
+		if !container.isInit && !p.completed(containerName) {
+			return
+		}
+	}
+
+	log.G(p.Ctx).Info("\u2705 [POD FLOW] All the containers of POD " + p.Sandbox.PodUID + " have completed, stopping its sidecars")
+
+	p.stopSidecars()
+}
+
+// stopSidecars stops the sidecars started so far, in the reverse order of their start
+func (p *PodSupervisor) stopSidecars() {
+	p.sidecarsStopped = true
+
+	for i := len(p.sidecars) - 1; i >= 0; i-- {
+		containerName := p.sidecars[i]
+
+		containerInfo, err := p.Sandbox.Runtime.Inspect(p.Ctx, containerName)
+		if err != nil || !containerInfo.State.Running {
+			continue
+		}
+
+		err = stopContainer(p.Ctx, p.Sandbox, containerName, p.containers[containerName].spec, p.terminationGracePeriod, p)
+		if err != nil {
+			log.G(p.Ctx).Error("\u274C [POD FLOW] Error stopping sidecar container " + containerName + ": " + err.Error())
+		}
+	}
+}
+
+// completed reports whether the container has terminated and its restart policy does not restart it
+func (p *PodSupervisor) completed(containerName string) bool {
+	containerInfo, err := p.inspect(containerName)
+	if err != nil {
+		return false
+	}
+
+	state := containerInfo.State
+	if state.Status != "exited" && state.Status != "dead" {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch p.containers[containerName].restartPolicy {
+	case v1.RestartPolicyNever:
+		return true
+	case v1.RestartPolicyOnFailure:
+		return state.ExitCode == 0
+	default:
+		return false
+	}
+}
+
+// archiveLogs starts archiving the logs of a container of the POD, across its restarts
+func (p *PodSupervisor) archiveLogs(containerName string) {
+	container, ok := p.containers[containerName]
+	if !ok {
+		return
+	}
+	p.Logs.Archive(p.Sandbox, p.PodNamespace, containerName, container.name)
+}
+
+// createFailed records the error that prevented the creation of the container, which is reported as the reason it is waiting for
+func (p *PodSupervisor) createFailed(containerName string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if container, ok := p.containers[containerName]; ok {
+		container.createError = err
+	}
+}
+
+// check restarts the container if it has terminated and its restart policy requires it, once its backoff has expired
+func (p *PodSupervisor) check(containerName string) {
+	containerInfo, err := p.inspect(containerName)
+	if err != nil {
+		// the container may not have been created yet, or it is being removed
+		return
+	}
+
+	if !p.shouldRestart(containerName, containerInfo) {
+		return
+	}
+
+	log.G(p.Ctx).Info("\u2705 [POD FLOW] Restarting container " + containerName)
+
+	err = p.Sandbox.Runtime.Start(p.Ctx, containerName)
+	if err != nil {
+		log.G(p.Ctx).Error("\u274C [POD FLOW] Error restarting container " + containerName + ": " + err.Error())
+		return
+	}
+	p.Status.Refresh(p.Sandbox, containerName)
+	p.postStart(containerName)
+}
+
+// inspect reads the state of a container of the POD from the status cache. The containers started by the supervisor are refreshed in the cache right away,
+// so that a restarted container is not seen terminated again before the event of its start has been received.
+func (p *PodSupervisor) inspect(containerName string) (containerruntime.ContainerInfo, error) {
+	return p.Status.Inspect(p.Sandbox, containerName)
+}
+
+// shouldRestart updates the restart state of the container and reports whether it has to be restarted now
+func (p *PodSupervisor) shouldRestart(containerName string, containerInfo containerruntime.ContainerInfo) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := containerInfo.State
+
+	container := p.containers[containerName]
+
+	if state.Running {
+		container.restartAt = time.Time{}
+		if container.backoff > 0 && time.Since(state.StartedAt) >= restartBackoffReset {
+			container.backoff = 0
+		}
+		return false
+	}
+
+	if state.Status != "exited" && state.Status != "dead" {
+		return false
+	}
+
+	switch container.restartPolicy {
+	case v1.RestartPolicyNever:
+		return false
+	case v1.RestartPolicyOnFailure:
+		if state.ExitCode == 0 {
+			return false
+		}
+	}
+
+	if container.restartAt.IsZero() {
+		// the container has just terminated, schedule its restart
+		if state.FinishedAt.Sub(state.StartedAt) >= restartBackoffReset {
+			container.backoff = 0
+		}
+
+		delay := container.backoff
+		if container.backoff == 0 {
+			container.backoff = initialRestartBackoff
+		} else {
+			container.backoff = min(2*container.backoff, maxRestartBackoff)
+		}
+
+		container.restartAt = time.Now().Add(delay)
+		container.restartDelay = delay
+		container.lastTermination = terminatedState(state)
+		container.lastTermination.ContainerID = containerIDScheme(p.Sandbox.Runtime) + containerInfo.ID
+		if container.stopMessage != "" {
+			container.lastTermination.Message = container.stopMessage
+			container.stopMessage = ""
+		}
+		if container.stopReason != "" {
+			container.lastTermination.Reason = container.stopReason
+			container.stopReason = ""
+		}
+
+		if delay > 0 {
+			log.G(p.Ctx).Info("\u2705 [POD FLOW] Container " + containerName + " terminated with exit code " + fmt.Sprint(state.ExitCode) + ", restarting it in " + delay.String())
+		}
+	}
+
+	if time.Now().Before(container.restartAt) {
+		return false
+	}
+
+	container.restartCount++
+	container.restartAt = time.Time{}
+	return true
+}
+
+// containerNames returns the names in the POD spec of the containers of the POD, sidecars and init containers included, by name in the runtime
+func (p *PodSupervisor) containerNames() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make(map[string]string, len(p.containers))
+	for containerName, container := range p.containers {
+		names[containerName] = container.name
+	}
+	return names
+}
+
+// containerStatus completes the status of a container with its restarts and the results of its probes, reporting CrashLoopBackOff while it waits for the next restart
+func (p *PodSupervisor) containerStatus(containerName string, status *v1.ContainerStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	container, ok := p.containers[containerName]
+	if !ok {
+		return
+	}
+
+	if container.isInit && !container.sidecar {
+		// an init container is ready once it has completed successfully
+		status.Ready = status.State.Terminated != nil && status.State.Terminated.ExitCode == 0
+	} else {
+		started := false
+		status.Ready = false
+		if status.State.Running != nil {
+			container.resetProbes(status.State.Running.StartedAt.Time)
+			started = container.started
+			status.Ready = container.started && container.ready
+		}
+		status.Started = &started
+	}
+
+	if status.State.Waiting != nil && container.createError != nil {
+		reason := "CreateContainerError"
+		if containerruntime.IsImagePull(container.createError) {
+			reason = "ErrImagePull"
+		}
+		status.State.Waiting = &v1.ContainerStateWaiting{Reason: reason, Message: container.createError.Error()}
+	} else if !p.initialized && status.State.Waiting != nil && status.State.Waiting.Reason == "ContainerCreating" {
+		status.State.Waiting.Reason = "PodInitializing"
+	}
+
+	if status.State.Terminated != nil && container.stopMessage != "" {
+		// the container has been stopped by the supervisor and is not going to be restarted
+		status.State.Terminated.Message = container.stopMessage
+		if container.stopReason != "" {
+			status.State.Terminated.Reason = container.stopReason
+		}
+	}
+
+	status.RestartCount = container.restartCount
+	if container.lastTermination != nil {
+		status.LastTerminationState = v1.ContainerState{Terminated: container.lastTermination}
+	}
+
+	// a stopped supervisor, e.g. of a POD being deleted, is not going to restart the container
+	if !container.restartAt.IsZero() && time.Now().Before(container.restartAt) && p.Ctx.Err() == nil {
+		status.State = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{
+			Reason:  "CrashLoopBackOff",
+			Message: "back-off " + container.restartDelay.String() + " restarting failed container=" + container.name + " pod=" + p.PodName,
+		}}
+		status.Ready = false
+	}
+}
+
+// isSidecar reports whether an init container is a sidecar, i.e. it has the Always restart policy and keeps running beside the containers of the POD
+func isSidecar(container v1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == v1.ContainerRestartPolicyAlways
+}
+
+// terminatedState translates the state of a terminated container to its Kubernetes counterpart
+func terminatedState(state containerruntime.ContainerState) *v1.ContainerStateTerminated {
+	reason := "Completed"
+	if state.OOMKilled {
+		reason = "OOMKilled"
+	} else if state.ExitCode != 0 {
+		reason = "Error"
+	}
+
+	return &v1.ContainerStateTerminated{
+		ExitCode:   int32(state.ExitCode),
+		Reason:     reason,
+		Message:    state.Error,
+		StartedAt:  metav1.NewTime(state.StartedAt),
+		FinishedAt: metav1.NewTime(state.FinishedAt),
+	}
+}
@@ -0,0 +1,279 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+func exitedState(exitCode int, ranFor time.Duration) containerruntime.ContainerState {
+	finishedAt := time.Now()
+	return containerruntime.ContainerState{Status: "exited", ExitCode: exitCode, StartedAt: finishedAt.Add(-ranFor), FinishedAt: finishedAt}
+}
+
+func newTestSupervisor(runtime containerruntime.ContainerRuntime, restartPolicy v1.RestartPolicy) *PodSupervisor {
+	return &PodSupervisor{
+		Sandbox: Sandbox{PodUID: "pod-uid", Runtime: runtime},
+		PodName: "pod",
+		Ctx:     context.Background(),
+		Status:  NewStatusCache(context.Background(), nil),
+		containers: map[string]*supervisedContainer{
+			"container": {name: "container", restartPolicy: restartPolicy},
+		},
+	}
+}
+
+func TestShouldRestartPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		restartPolicy v1.RestartPolicy
+		state         containerruntime.ContainerState
+		want          bool
+	}{
+		{name: "Always, running", restartPolicy: v1.RestartPolicyAlways, state: containerruntime.ContainerState{Status: "running", Running: true, StartedAt: time.Now()}},
+		{name: "Always, created", restartPolicy: v1.RestartPolicyAlways, state: containerruntime.ContainerState{Status: "created"}},
+		{name: "Always, completed", restartPolicy: v1.RestartPolicyAlways, state: exitedState(0, time.Second), want: true},
+		{name: "Always, failed", restartPolicy: v1.RestartPolicyAlways, state: exitedState(1, time.Second), want: true},
+		{name: "Always, dead", restartPolicy: v1.RestartPolicyAlways, state: containerruntime.ContainerState{Status: "dead", ExitCode: 137}, want: true},
+		{name: "OnFailure, completed", restartPolicy: v1.RestartPolicyOnFailure, state: exitedState(0, time.Second)},
+		{name: "OnFailure, failed", restartPolicy: v1.RestartPolicyOnFailure, state: exitedState(1, time.Second), want: true},
+		{name: "Never, completed", restartPolicy: v1.RestartPolicyNever, state: exitedState(0, time.Second)},
+		{name: "Never, failed", restartPolicy: v1.RestartPolicyNever, state: exitedState(1, time.Second)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			supervisor := newTestSupervisor(newFakeRuntime(), test.restartPolicy)
+			got := supervisor.shouldRestart("container", containerruntime.ContainerInfo{ID: "id", Name: "container", State: test.state})
+			if got != test.want {
+				t.Fatalf("got %t, want %t", got, test.want)
+			}
+
+			container := supervisor.containers["container"]
+			wantRestartCount := int32(0)
+			if test.want {
+				wantRestartCount = 1
+			}
+			if container.restartCount != wantRestartCount {
+				t.Fatalf("restart count: got %d, want %d", container.restartCount, wantRestartCount)
+			}
+			if test.want && (container.lastTermination == nil || container.lastTermination.ExitCode != int32(test.state.ExitCode) || container.lastTermination.ContainerID != "docker://id") {
+				t.Fatalf("last termination: got %+v", container.lastTermination)
+			}
+		})
+	}
+}
+
+func TestShouldRestartBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff time.Duration
+		// ranFor is how long the container has run before terminating
+		ranFor    time.Duration
+		wantDelay time.Duration
+		// wantBackoff is the backoff of the following restart
+		wantBackoff time.Duration
+	}{
+		{name: "first termination", ranFor: time.Second, wantDelay: 0, wantBackoff: initialRestartBackoff},
+		{name: "second termination", backoff: 10 * time.Second, ranFor: time.Second, wantDelay: 10 * time.Second, wantBackoff: 20 * time.Second},
+		{name: "doubling", backoff: 80 * time.Second, ranFor: time.Second, wantDelay: 80 * time.Second, wantBackoff: 160 * time.Second},
+		{name: "capped", backoff: 160 * time.Second, ranFor: time.Second, wantDelay: 160 * time.Second, wantBackoff: maxRestartBackoff},
+		{name: "at the cap", backoff: maxRestartBackoff, ranFor: time.Second, wantDelay: maxRestartBackoff, wantBackoff: maxRestartBackoff},
+		{name: "reset after a long execution", backoff: maxRestartBackoff, ranFor: restartBackoffReset, wantDelay: 0, wantBackoff: initialRestartBackoff},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			supervisor := newTestSupervisor(newFakeRuntime(), v1.RestartPolicyAlways)
+			container := supervisor.containers["container"]
+			container.backoff = test.backoff
+
+			info := containerruntime.ContainerInfo{Name: "container", State: exitedState(1, test.ranFor)}
+			got := supervisor.shouldRestart("container", info)
+
+			if got != (test.wantDelay == 0) {
+				t.Fatalf("got %t, want a restart only without delay", got)
+			}
+			if container.backoff != test.wantBackoff {
+				t.Fatalf("backoff: got %s, want %s", container.backoff, test.wantBackoff)
+			}
+			if !got {
+				if container.restartDelay != test.wantDelay {
+					t.Fatalf("delay: got %s, want %s", container.restartDelay, test.wantDelay)
+				}
+				if until := time.Until(container.restartAt); until <= 0 || until > test.wantDelay {
+					t.Fatalf("restart scheduled in %s, want %s", until, test.wantDelay)
+				}
+
+				// the same termination is seen again before the end of the backoff
+				if supervisor.shouldRestart("container", info) || container.backoff != test.wantBackoff {
+					t.Fatal("restarted before the end of the backoff")
+				}
+
+				// the backoff expires
+				container.restartAt = time.Now().Add(-time.Millisecond)
+				if !supervisor.shouldRestart("container", info) || container.restartCount != 1 || !container.restartAt.IsZero() {
+					t.Fatal("not restarted at the end of the backoff")
+				}
+			}
+		})
+	}
+}
+
+func TestShouldRestartBackoffResetWhileRunning(t *testing.T) {
+	tests := []struct {
+		name        string
+		runningFor  time.Duration
+		wantBackoff time.Duration
+	}{
+		{name: "short execution", runningFor: time.Minute, wantBackoff: 40 * time.Second},
+		{name: "long execution", runningFor: restartBackoffReset, wantBackoff: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			supervisor := newTestSupervisor(newFakeRuntime(), v1.RestartPolicyAlways)
+			container := supervisor.containers["container"]
+			container.backoff = 40 * time.Second
+			container.restartAt = time.Now().Add(-time.Second)
+
+			running := containerruntime.ContainerState{Status: "running", Running: true, StartedAt: time.Now().Add(-test.runningFor)}
+			if supervisor.shouldRestart("container", containerruntime.ContainerInfo{Name: "container", State: running}) {
+				t.Fatal("a running container has been restarted")
+			}
+			if container.backoff != test.wantBackoff || !container.restartAt.IsZero() {
+				t.Fatalf("got backoff %s and restart at %s, want %s and no restart", container.backoff, container.restartAt, test.wantBackoff)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "container", State: exitedState(1, time.Second)})
+	supervisor := newTestSupervisor(runtime, v1.RestartPolicyOnFailure)
+
+	// the first termination is restarted right away
+	supervisor.check("container")
+	if len(runtime.starts) != 1 {
+		t.Fatalf("got %d starts, want 1", len(runtime.starts))
+	}
+
+	// the second one waits for the backoff
+	runtime.set(containerruntime.ContainerInfo{Name: "container", State: exitedState(1, time.Second)})
+	supervisor.check("container")
+	supervisor.check("container")
+	if len(runtime.starts) != 1 {
+		t.Fatalf("got %d starts during the backoff, want 1", len(runtime.starts))
+	}
+
+	status := v1.ContainerStatus{Name: "container", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 1}}}
+	supervisor.containerStatus("container", &status)
+	if status.State.Waiting == nil || status.State.Waiting.Reason != "CrashLoopBackOff" || status.RestartCount != 1 || status.LastTerminationState.Terminated == nil {
+		t.Fatalf("got status %+v, want CrashLoopBackOff after 1 restart", status)
+	}
+
+	supervisor.containers["container"].restartAt = time.Now().Add(-time.Millisecond)
+	supervisor.check("container")
+	if len(runtime.starts) != 2 || supervisor.containers["container"].restartCount != 2 {
+		t.Fatalf("got %d starts and %d restarts, want 2", len(runtime.starts), supervisor.containers["container"].restartCount)
+	}
+
+	// a completed container is not restarted with OnFailure
+	runtime.set(containerruntime.ContainerInfo{Name: "container", State: exitedState(0, time.Second)})
+	supervisor.check("container")
+	if len(runtime.starts) != 2 || !supervisor.completed("container") {
+		t.Fatal("a completed container has been restarted")
+	}
+
+	// a missing container is left alone
+	supervisor.check("missing")
+}
+
+func TestRunInitContainerNever(t *testing.T) {
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "proxy", State: containerruntime.ContainerState{Status: "running", Running: true}})
+	supervisor := newTestSupervisor(runtime, v1.RestartPolicyNever)
+	supervisor.containers["init"] = &supervisedContainer{name: "init", restartPolicy: v1.RestartPolicyNever, isInit: true}
+	supervisor.containers["proxy"] = &supervisedContainer{name: "proxy", restartPolicy: v1.RestartPolicyAlways, isInit: true, sidecar: true}
+	supervisor.sidecars = []string{"proxy"}
+	// the logs are not archived
+	archiveCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	supervisor.Logs = &LogArchive{Ctx: archiveCtx, Status: supervisor.Status, Root: t.TempDir(), pods: make(map[string]*podLogArchivers)}
+
+	completed := make(chan bool)
+	go func() {
+		completed <- supervisor.runInitContainer(DockerRunStruct{Name: "init", Spec: containerruntime.ContainerSpec{Name: "init"}})
+	}()
+
+	// the init container fails once it has been started
+	for {
+		if info, err := runtime.Inspect(context.Background(), "init"); err == nil && info.State.Running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	runtime.set(containerruntime.ContainerInfo{Name: "init", State: exitedState(1, time.Second)})
+
+	if <-completed {
+		t.Fatal("the failed init container has completed")
+	}
+	if len(runtime.starts) != 1 {
+		t.Fatalf("got %d starts, want the init container never restarted", len(runtime.starts))
+	}
+	if len(runtime.stops) != 1 || runtime.stops[0] != "proxy" {
+		t.Fatalf("got stops %v, want the sidecar stopped", runtime.stops)
+	}
+}
+
+func TestPreStopHookErrorLastTermination(t *testing.T) {
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "container", State: exitedState(143, time.Second)})
+	supervisor := newTestSupervisor(runtime, v1.RestartPolicyAlways)
+
+	// the container has been stopped after failing its liveness probe, and its preStop hook has failed
+	supervisor.preStopFailed("container", errors.New("command timed out"))
+	supervisor.check("container")
+
+	lastTermination := supervisor.containers["container"].lastTermination
+	if lastTermination == nil || lastTermination.Reason != "PreStopHookError" || lastTermination.Message != "command timed out" || lastTermination.ExitCode != 143 {
+		t.Fatalf("got last termination %+v, want PreStopHookError", lastTermination)
+	}
+
+	// the next termination has its own reason
+	runtime.set(containerruntime.ContainerInfo{Name: "container", State: exitedState(1, time.Second)})
+	supervisor.containers["container"].restartAt = time.Time{}
+	supervisor.check("container")
+	if lastTermination := supervisor.containers["container"].lastTermination; lastTermination.Reason != "Error" {
+		t.Fatalf("got last termination %+v, want Error", lastTermination)
+	}
+}
+
+func TestCheckReadsStatusCache(t *testing.T) {
+	runtime := newFakeRuntime(containerruntime.ContainerInfo{Name: "container", Labels: map[string]string{PodUIDLabel: "pod-uid"}, State: exitedState(1, time.Second)})
+	supervisor := newTestSupervisor(runtime, v1.RestartPolicyAlways)
+	watcher := &runtimeWatcher{runtime: runtime, pods: map[string]bool{"pod-uid": true}}
+	supervisor.Status.watchers[runtime] = watcher
+	if err := supervisor.Status.resync(context.Background(), watcher); err != nil {
+		t.Fatal(err)
+	}
+
+	// the terminated container is restarted, and refreshed in the cache once started
+	runtime.inspections = nil
+	supervisor.check("container")
+	if len(runtime.starts) != 1 || len(runtime.inspections) != 1 {
+		t.Fatalf("got %d starts and inspections %v, want the container restarted and refreshed", len(runtime.starts), runtime.inspections)
+	}
+
+	// the supervisor does not poll the runtime, nor sees the restarted container terminated again
+	runtime.inspections = nil
+	for i := 0; i < 3; i++ {
+		supervisor.check("container")
+	}
+	if len(runtime.starts) != 1 || len(runtime.inspections) != 0 || supervisor.completed("container") {
+		t.Fatalf("got %d starts and inspections %v, want the running container read from the cache", len(runtime.starts), runtime.inspections)
+	}
+}
@@ -0,0 +1,58 @@
+package acceleratorledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Ledger persists the allocations of the accelerators handled by a manager, so that they survive a restart of the sidecar.
+// The file holds the list of the specs of the manager, in the same JSON format of its Dump function: every allocated accelerator is keyed by the name of the container it is assigned to.
+type Ledger struct {
+	Path string
+}
+
+// LivenessCheck reports whether the container to which an accelerator is assigned belongs to a POD that still exists
+type LivenessCheck func(containerName string) (bool, error)
+
+// Save writes specsList, a list of GPU or FPGA specs, to the ledger
+func (l Ledger) Save(specsList interface{}) error {
+	jsonData, err := json.MarshalIndent(specsList, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling JSON: %v", err)
+	}
+
+	if dir := filepath.Dir(l.Path); dir != "" {
+		err = os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the ledger is written to a temporary file first, so that a crash never leaves it truncated
+	tmpPath := l.Path + ".tmp"
+	err = os.WriteFile(tmpPath, jsonData, 0644)
+	if err != nil {
+		return fmt.Errorf("Error writing to file: %v", err)
+	}
+
+	return os.Rename(tmpPath, l.Path)
+}
+
+// Load reads the ledger into specsList, a pointer to a list of GPU or FPGA specs. It returns false if the ledger does not exist yet.
+func (l Ledger) Load(specsList interface{}) (bool, error) {
+	jsonData, err := os.ReadFile(l.Path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	err = json.Unmarshal(jsonData, specsList)
+	if err != nil {
+		return false, fmt.Errorf("Error unmarshalling JSON from %s: %v", l.Path, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,47 @@
+package acceleratorledger
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testSpecs struct {
+	UUID        string
+	ContainerID string
+	Available   bool
+}
+
+func TestLedgerSaveLoad(t *testing.T) {
+	ledger := Ledger{Path: filepath.Join(t.TempDir(), "ledgers", "gpu_specs.json")}
+
+	var loaded []testSpecs
+	found, err := ledger.Load(&loaded)
+	if err != nil || found {
+		t.Fatalf("missing ledger: got %t, %v, want false, nil", found, err)
+	}
+
+	saved := []testSpecs{{UUID: "GPU-0", ContainerID: "pod-a-container", Available: false}, {UUID: "GPU-1", Available: true}}
+	if err := ledger.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ledger.Path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("the temporary file has been left behind: %v", err)
+	}
+
+	found, err = ledger.Load(&loaded)
+	if err != nil || !found {
+		t.Fatalf("got %t, %v, want true, nil", found, err)
+	}
+	if !reflect.DeepEqual(loaded, saved) {
+		t.Fatalf("got %+v, want %+v", loaded, saved)
+	}
+
+	if err := os.WriteFile(ledger.Path, []byte("[{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ledger.Load(&loaded); err == nil {
+		t.Fatal("corrupted ledger: got no error")
+	}
+}
@@ -0,0 +1,870 @@
+package containerruntime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ApptainerRuntime implements ContainerRuntime by invoking the apptainer (or singularity) command line.
+// Every container is an Apptainer instance, in which the command of the container is executed.
+// Apptainer has no daemon keeping track of the containers, so their state and logs are kept under StateDir.
+// Apptainer containers share the network of the host: networks and published ports are not supported.
+type ApptainerRuntime struct {
+	// CommandPrefix is run by BashPath before every apptainer invocation, e.g. to load the apptainer module
+	CommandPrefix string
+	// SingularityPrefix is prepended to every apptainer invocation
+	SingularityPrefix string
+	BashPath          string
+	StateDir          string
+
+	containers sync.Map
+	events     eventBroadcaster
+}
+
+// apptainerContainer is the state of a container, persisted as JSON in its state directory
+type apptainerContainer struct {
+	mu sync.Mutex
+
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Spec  ContainerSpec  `json:"spec"`
+	State ContainerState `json:"state"`
+	Pid   int            `json:"pid,omitempty"`
+
+	dir string
+	cmd *exec.Cmd
+}
+
+// NewApptainerRuntime returns an ApptainerRuntime keeping its state under stateDir.
+// The containers left running by a previous execution of the plugin are stopped and marked as exited.
+func NewApptainerRuntime(commandPrefix string, singularityPrefix string, bashPath string, stateDir string) (*ApptainerRuntime, error) {
+	if bashPath == "" {
+		bashPath = "/bin/bash"
+	}
+
+	a := &ApptainerRuntime{
+		CommandPrefix:     commandPrefix,
+		SingularityPrefix: singularityPrefix,
+		BashPath:          bashPath,
+		StateDir:          stateDir,
+	}
+
+	err := os.MkdirAll(a.containersDir(), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(a.containersDir())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		dir := filepath.Join(a.containersDir(), entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "state.json"))
+		if err != nil {
+			continue
+		}
+
+		c := &apptainerContainer{dir: dir}
+		err = json.Unmarshal(data, c)
+		if err != nil {
+			continue
+		}
+
+		if c.State.Running {
+			// the output of the container was captured by the previous execution of the plugin and is lost
+			if c.Pid != 0 {
+				syscall.Kill(-c.Pid, syscall.SIGKILL)
+			}
+			a.command(context.Background(), "instance", "stop", c.ID).Run()
+			c.State = ContainerState{
+				Status:     "exited",
+				ExitCode:   137,
+				Error:      "the container has been stopped by a restart of the plugin",
+				StartedAt:  c.State.StartedAt,
+				FinishedAt: time.Now(),
+			}
+			c.save()
+		}
+
+		a.containers.Store(c.ID, c)
+	}
+
+	return a, nil
+}
+
+func (a *ApptainerRuntime) containersDir() string {
+	return filepath.Join(a.StateDir, "containers")
+}
+
+// command returns the command running apptainer with args. Every argument is quoted, while the prefixes are passed to the shell as they are.
+func (a *ApptainerRuntime) command(ctx context.Context, args ...string) *exec.Cmd {
+	quotedArgs := make([]string, len(args))
+	for i, arg := range args {
+		quotedArgs[i] = shellQuote(arg)
+	}
+
+	script := "APPTAINER=$(command -v apptainer || command -v singularity || echo apptainer)\n"
+	if a.CommandPrefix != "" {
+		script = a.CommandPrefix + "\n" + script
+	}
+	script += strings.TrimSpace(a.SingularityPrefix+" \"$APPTAINER\" ") + " " + strings.Join(quotedArgs, " ")
+
+	return exec.CommandContext(ctx, a.BashPath, "-c", script)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// imageURI adds the docker:// scheme to the images without a scheme that are not local files
+func imageURI(image string) string {
+	if strings.Contains(image, "://") || strings.HasPrefix(image, "/") || strings.HasPrefix(image, ".") || strings.HasSuffix(image, ".sif") {
+		return image
+	}
+	return "docker://" + image
+}
+
+func (c *apptainerContainer) save() error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.dir, "state.json"), data, 0644)
+}
+
+func (c *apptainerContainer) logPath() string {
+	return filepath.Join(c.dir, "container.log")
+}
+
+func (c *apptainerContainer) envPath() string {
+	return filepath.Join(c.dir, "env")
+}
+
+// instanceStartArgs are the arguments of apptainer starting the instance of the container, with its mounts and devices
+func (c *apptainerContainer) instanceStartArgs() []string {
+	args := []string{"instance", "start", "--cleanenv", "--env-file", c.envPath()}
+	for _, mount := range c.Spec.Mounts {
+		bind := mount.Source + ":" + mount.Target
+		if mount.ReadOnly {
+			bind += ":ro"
+		}
+		args = append(args, "--bind", bind)
+	}
+	for _, device := range c.Spec.Devices {
+		args = append(args, "--bind", device)
+	}
+	if c.Spec.Runtime == "nvidia" {
+		args = append(args, "--nv")
+	}
+	return append(args, imageURI(c.Spec.Image), c.ID)
+}
+
+// runArgs are the arguments of apptainer running the command of the container in its instance.
+// Same as docker: an entrypoint replaces the one of the image, otherwise the command is passed to the runscript.
+func (c *apptainerContainer) runArgs() []string {
+	args := []string{"run"}
+	if len(c.Spec.Entrypoint) > 0 {
+		args = []string{"exec"}
+	}
+	args = append(args, "--cleanenv", "--env-file", c.envPath())
+	if c.Spec.WorkingDir != "" {
+		args = append(args, "--pwd", c.Spec.WorkingDir)
+	}
+	args = append(args, "instance://"+c.ID)
+	args = append(args, c.Spec.Entrypoint...)
+	return append(args, c.Spec.Command...)
+}
+
+func (c *apptainerContainer) info() ContainerInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ContainerInfo{
+		ID:      c.ID,
+		Name:    c.Name,
+		Image:   c.Spec.Image,
+		ImageID: imageURI(c.Spec.Image),
+		Labels:  c.Spec.Labels,
+		State:   c.State,
+	}
+}
+
+// lookup returns the container with the given ID or name
+func (a *ApptainerRuntime) lookup(id string) (*apptainerContainer, error) {
+	if c, ok := a.containers.Load(id); ok {
+		return c.(*apptainerContainer), nil
+	}
+
+	var found *apptainerContainer
+	a.containers.Range(func(_, value interface{}) bool {
+		c := value.(*apptainerContainer)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.Name == id {
+			found = c
+			return false
+		}
+		return true
+	})
+	if found == nil {
+		return nil, fmt.Errorf("%w: no such container %s", ErrNotFound, id)
+	}
+	return found, nil
+}
+
+func (a *ApptainerRuntime) Ping(ctx context.Context) error {
+	output, err := a.command(ctx, "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unable to run apptainer: %v: %s", err, string(output))
+	}
+	return nil
+}
+
+// Create records the container, the instance is started only by Start, as Apptainer has no created state
+func (a *ApptainerRuntime) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	if spec.Name != "" {
+		if _, err := a.lookup(spec.Name); err == nil {
+			return "", fmt.Errorf("the container name %s is already in use", spec.Name)
+		}
+	}
+
+	randomID := make([]byte, 32)
+	_, err := rand.Read(randomID)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(randomID)
+
+	c := &apptainerContainer{
+		ID:    id,
+		Name:  spec.Name,
+		Spec:  spec,
+		State: ContainerState{Status: "created"},
+		dir:   filepath.Join(a.containersDir(), id),
+	}
+
+	err = os.MkdirAll(c.dir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	// the environment is passed through a file, since the values of --env cannot contain commas
+	var envFile bytes.Buffer
+	for _, env := range spec.Env {
+		name, value, _ := strings.Cut(env, "=")
+		envFile.WriteString(name + "=" + shellQuote(value) + "\n")
+	}
+	err = os.WriteFile(c.envPath(), envFile.Bytes(), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.save()
+	if err != nil {
+		return "", err
+	}
+
+	a.containers.Store(id, c)
+	a.publish(c, "create")
+	return id, nil
+}
+
+// Start starts the instance of the container, then runs its command inside the instance capturing the output
+func (a *ApptainerRuntime) Start(ctx context.Context, id string) error {
+	c, err := a.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	// the lock is not held while the image is pulled, so that the container can be inspected in the meantime
+	c.mu.Lock()
+	if c.State.Running {
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	output, err := a.command(ctx, c.instanceStartArgs()...).CombinedOutput()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		c.State = ContainerState{Status: "exited", ExitCode: 128, Error: strings.TrimSpace(string(output)), FinishedAt: time.Now()}
+		c.save()
+		a.publishLocked(c, "die")
+		return fmt.Errorf("unable to start the instance of container %s: %v: %s", c.Name, err, string(output))
+	}
+
+	logFile, err := os.OpenFile(c.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	logWriter := &timestampWriter{writer: logFile}
+
+	// the command must outlive the request that started it
+	cmd := a.command(context.Background(), c.runArgs()...)
+	cmd.Stdout = logWriter
+	cmd.Stderr = logWriter
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+
+	err = cmd.Start()
+	if err != nil {
+		logFile.Close()
+		a.command(ctx, "instance", "stop", c.ID).Run()
+		return err
+	}
+
+	c.cmd = cmd
+	c.Pid = cmd.Process.Pid
+	c.State = ContainerState{Status: "running", Running: true, StartedAt: time.Now()}
+	c.save()
+	a.publishLocked(c, "start")
+
+	go func() {
+		err := cmd.Wait()
+		logWriter.Flush()
+		logFile.Close()
+
+		exitCode := 0
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			exitCode = exitError.ExitCode()
+			if status, ok := exitError.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				exitCode = 128 + int(status.Signal())
+			}
+		} else if err != nil {
+			exitCode = 1
+		}
+
+		a.command(context.Background(), "instance", "stop", c.ID).Run()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.cmd = nil
+		c.State.Status = "exited"
+		c.State.Running = false
+		c.State.ExitCode = exitCode
+		c.State.FinishedAt = time.Now()
+		c.save()
+		a.publishLocked(c, "die")
+	}()
+
+	return nil
+}
+
+func (a *ApptainerRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	c, err := a.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	cmd := c.cmd
+	c.mu.Unlock()
+	if cmd == nil {
+		return nil
+	}
+
+	// the command of the container runs in its own process group, with the processes it has spawned
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !c.info().State.Running {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	return nil
+}
+
+func (a *ApptainerRuntime) Remove(ctx context.Context, id string, force bool) error {
+	c, err := a.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.State.Running {
+		if !force {
+			c.mu.Unlock()
+			return fmt.Errorf("container %s is running, stop it or force the removal", c.Name)
+		}
+		if c.cmd != nil {
+			syscall.Kill(-c.cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}
+	c.mu.Unlock()
+
+	a.command(ctx, "instance", "stop", "--force", c.ID).Run()
+
+	a.containers.Delete(c.ID)
+	a.publish(c, "destroy")
+	return os.RemoveAll(c.dir)
+}
+
+func (a *ApptainerRuntime) Rename(ctx context.Context, id string, name string) error {
+	c, err := a.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.lookup(name); err == nil {
+		return fmt.Errorf("the container name %s is already in use", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Name = name
+	a.publishLocked(c, "rename")
+	return c.save()
+}
+
+// Events streams the events of the containers, generated by the runtime itself since Apptainer has no daemon
+func (a *ApptainerRuntime) Events(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	return a.events.subscribe(ctx)
+}
+
+func (a *ApptainerRuntime) publish(c *apptainerContainer, action string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a.publishLocked(c, action)
+}
+
+// publishLocked publishes an event of the container, whose lock is held by the caller
+func (a *ApptainerRuntime) publishLocked(c *apptainerContainer, action string) {
+	a.events.publish(ContainerEvent{ID: c.ID, Name: c.Name, Action: action, Labels: c.Spec.Labels})
+}
+
+func (a *ApptainerRuntime) Inspect(ctx context.Context, id string) (ContainerInfo, error) {
+	c, err := a.lookup(id)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	return c.info(), nil
+}
+
+func (a *ApptainerRuntime) List(ctx context.Context, opts ListOptions) ([]ContainerInfo, error) {
+	var nameRegexp *regexp.Regexp
+	if opts.Name != "" {
+		var err error
+		nameRegexp, err = regexp.Compile(opts.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var infos []ContainerInfo
+	a.containers.Range(func(_, value interface{}) bool {
+		info := value.(*apptainerContainer).info()
+
+		if !opts.All && !info.State.Running {
+			return true
+		}
+		if nameRegexp != nil && !nameRegexp.MatchString(info.Name) {
+			return true
+		}
+		for key, label := range opts.Labels {
+			if value, ok := info.Labels[key]; !ok || (label != "" && value != label) {
+				return true
+			}
+		}
+
+		infos = append(infos, info)
+		return true
+	})
+
+	return infos, nil
+}
+
+// Logs returns the output of the container. The output is stored with the timestamp of every line, as docker does.
+func (a *ApptainerRuntime) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	c, err := a.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	tail := -1
+	if opts.Tail != "" && opts.Tail != "all" {
+		tail, err = strconv.Atoi(opts.Tail)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tail %s: %v", opts.Tail, err)
+		}
+	}
+
+	logFile, err := os.Open(c.logPath())
+	if os.IsNotExist(err) {
+		return io.NopCloser(strings.NewReader("")), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		defer logFile.Close()
+
+		write := func(line string) error {
+			timestamp, message, _ := strings.Cut(line, " ")
+			if !opts.Since.IsZero() {
+				t, err := time.Parse(time.RFC3339Nano, timestamp)
+				if err == nil && t.Before(opts.Since) {
+					return nil
+				}
+			}
+			if opts.Timestamps {
+				_, err := io.WriteString(pipeWriter, line)
+				return err
+			}
+			_, err := io.WriteString(pipeWriter, message)
+			return err
+		}
+
+		reader := bufio.NewReader(logFile)
+		var lastLines []string
+		pending := ""
+
+		// the lines already written, only the last ones if a tail is requested
+		for {
+			line, err := reader.ReadString('\n')
+			pending += line
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+
+			if tail >= 0 {
+				lastLines = append(lastLines, pending)
+				if len(lastLines) > tail {
+					lastLines = lastLines[1:]
+				}
+			} else if err := write(pending); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+			pending = ""
+		}
+
+		// the last line, flushed without its newline when the container exited, counts towards the tail
+		if tail > 0 && pending != "" && len(lastLines) >= tail {
+			lastLines = lastLines[len(lastLines)-tail+1:]
+		}
+
+		for _, line := range lastLines {
+			if err := write(line); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+
+		// the lines written from now on, until the container exits
+		for opts.Follow {
+			line, err := reader.ReadString('\n')
+			pending += line
+			if err == io.EOF {
+				if !c.info().State.Running {
+					// the last lines could have been written after the previous read
+					rest, _ := io.ReadAll(reader)
+					pending += string(rest)
+					break
+				}
+				select {
+				case <-ctx.Done():
+					pipeWriter.CloseWithError(ctx.Err())
+					return
+				case <-time.After(500 * time.Millisecond):
+				}
+				continue
+			} else if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+
+			if err := write(pending); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+			pending = ""
+		}
+
+		if pending != "" {
+			write(pending)
+		}
+		pipeWriter.Close()
+	}()
+
+	return pipeReader, nil
+}
+
+func (a *ApptainerRuntime) Exec(ctx context.Context, id string, cmd []string) (ExecResult, error) {
+	c, err := a.lookup(id)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	if !c.info().State.Running {
+		return ExecResult{}, fmt.Errorf("container %s is not running", c.Name)
+	}
+
+	args := append([]string{"exec", "--cleanenv", "--env-file", c.envPath(), "instance://" + c.ID}, cmd...)
+	execCmd := a.command(ctx, args...)
+
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout = &stdout
+	execCmd.Stderr = &stderr
+
+	err = execCmd.Run()
+	var exitError *exec.ExitError
+	if err != nil && !errors.As(err, &exitError) {
+		return ExecResult{}, err
+	}
+
+	return ExecResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: execCmd.ProcessState.ExitCode()}, nil
+}
+
+// ExecAttach runs the command in the instance of the container. Terminals are not supported: the output of the command is sent to stdout and stderr as it is.
+func (a *ApptainerRuntime) ExecAttach(ctx context.Context, id string, opts ExecOptions) (ExecSession, error) {
+	c, err := a.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.info().State.Running {
+		return nil, fmt.Errorf("container %s is not running", c.Name)
+	}
+
+	args := append([]string{"exec", "--cleanenv", "--env-file", c.envPath(), "instance://" + c.ID}, opts.Cmd...)
+
+	// the command must not be bound to the context of the request starting it, but to the session
+	execCmd := a.command(context.Background(), args...)
+	execCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+
+	session := &apptainerExecSession{cmd: execCmd, done: make(chan struct{})}
+
+	if opts.Stdin {
+		session.stdin, err = execCmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+	execCmd.Stdout = stdoutWriter
+	execCmd.Stderr = stderrWriter
+	session.stdout = stdoutReader
+	session.stderr = stderrReader
+
+	err = execCmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		session.err = execCmd.Wait()
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		close(session.done)
+	}()
+
+	return session, nil
+}
+
+// apptainerExecSession is a command executed in an Apptainer instance by ExecAttach
+type apptainerExecSession struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.Reader
+	stderr io.Reader
+
+	done chan struct{}
+	err  error
+}
+
+func (s *apptainerExecSession) Stdin() io.WriteCloser {
+	return s.stdin
+}
+
+func (s *apptainerExecSession) Stdout() io.Reader {
+	return s.stdout
+}
+
+func (s *apptainerExecSession) Stderr() io.Reader {
+	return s.stderr
+}
+
+func (s *apptainerExecSession) Resize(ctx context.Context, height uint, width uint) error {
+	return errors.New("terminals are not supported by Apptainer")
+}
+
+func (s *apptainerExecSession) Wait(ctx context.Context) (int, error) {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	var exitError *exec.ExitError
+	if s.err != nil && !errors.As(s.err, &exitError) {
+		return 0, s.err
+	}
+	return s.cmd.ProcessState.ExitCode(), nil
+}
+
+func (s *apptainerExecSession) Close() error {
+	select {
+	case <-s.done:
+	default:
+		syscall.Kill(-s.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return nil
+}
+
+// procClockTicks is the unit of the CPU times in procfs, USER_HZ, which is 100 on every Linux architecture
+const procClockTicks = 100
+
+// Stats sums the usage of the processes of the container, read from procfs: the containers are not placed in cgroups of their own,
+// and the processes of a container are the process group of its command. No network traffic is reported, since the containers share the network of the host,
+// nor the size of a writable layer, which the containers do not have.
+func (a *ApptainerRuntime) Stats(ctx context.Context, id string, opts StatsOptions) (ContainerStats, error) {
+	c, err := a.lookup(id)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+
+	c.mu.Lock()
+	pid, running := c.Pid, c.State.Running
+	c.mu.Unlock()
+
+	stats := ContainerStats{Time: time.Now()}
+	if !running || pid == 0 {
+		return stats, nil
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return ContainerStats{}, err
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	processGroup := strconv.Itoa(pid)
+	var cpuTicks uint64
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			// the process has exited in the meantime
+			continue
+		}
+
+		// the fields are read after the name of the command, which may contain spaces and parentheses
+		fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+		if len(fields) < 22 || fields[2] != processGroup {
+			continue
+		}
+		field := func(i int) uint64 {
+			value, _ := strconv.ParseUint(fields[i], 10, 64)
+			return value
+		}
+
+		// minflt, majflt, utime, stime, cutime, cstime and rss, the times of the children already waited for included
+		stats.PageFaults += field(7) + field(9)
+		stats.MajorPageFaults += field(9)
+		cpuTicks += field(11) + field(12) + field(13) + field(14)
+		stats.MemoryRSSBytes += field(21) * pageSize
+	}
+
+	stats.CPUUsageNanoSeconds = cpuTicks * uint64(time.Second/procClockTicks)
+	stats.MemoryUsageBytes = stats.MemoryRSSBytes
+	stats.MemoryWorkingSetBytes = stats.MemoryRSSBytes
+
+	return stats, nil
+}
+
+// CreateNetwork does nothing, since Apptainer containers share the network of the host
+func (a *ApptainerRuntime) CreateNetwork(ctx context.Context, name string) (string, error) {
+	return name, nil
+}
+
+// RemoveNetwork does nothing, since Apptainer containers share the network of the host
+func (a *ApptainerRuntime) RemoveNetwork(ctx context.Context, id string) error {
+	return nil
+}
+
+func (a *ApptainerRuntime) ConnectNetwork(ctx context.Context, network string, id string, ip string) error {
+	return errors.New("networks are not supported by Apptainer")
+}
+
+// ListNetworks returns no network, since Apptainer containers share the network of the host
+func (a *ApptainerRuntime) ListNetworks(ctx context.Context, name string) ([]NetworkInfo, error) {
+	return nil, nil
+}
+
+func (a *ApptainerRuntime) Close() error {
+	return nil
+}
+
+// timestampWriter writes every line prefixed with the time it has been written at, in the format of the docker logs
+type timestampWriter struct {
+	mu      sync.Mutex
+	writer  io.Writer
+	pending []byte
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending = append(t.pending, p...)
+	for {
+		i := bytes.IndexByte(t.pending, '\n')
+		if i < 0 {
+			break
+		}
+		_, err := t.writer.Write(append([]byte(time.Now().UTC().Format(time.RFC3339Nano)+" "), t.pending[:i+1]...))
+		if err != nil {
+			return 0, err
+		}
+		t.pending = t.pending[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the last line, even if it is not terminated
+func (t *timestampWriter) Flush() {
+	if len(t.pending) > 0 {
+		t.Write([]byte("\n"))
+	}
+}
@@ -0,0 +1,339 @@
+package containerruntime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImageURI(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "busybox", want: "docker://busybox"},
+		{image: "ghcr.io/intertwin-eu/image:1.0", want: "docker://ghcr.io/intertwin-eu/image:1.0"},
+		{image: "docker://busybox", want: "docker://busybox"},
+		{image: "oras://ghcr.io/intertwin-eu/image.sif:latest", want: "oras://ghcr.io/intertwin-eu/image.sif:latest"},
+		{image: "/cvmfs/unpacked.cern.ch/image", want: "/cvmfs/unpacked.cern.ch/image"},
+		{image: "./image", want: "./image"},
+		{image: "image.sif", want: "image.sif"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			if got := imageURI(test.image); got != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApptainerCommand(t *testing.T) {
+	tests := []struct {
+		name              string
+		commandPrefix     string
+		singularityPrefix string
+		args              []string
+		wantScript        string
+	}{
+		{
+			name:       "no prefix",
+			args:       []string{"instance", "stop", "id"},
+			wantScript: "APPTAINER=$(command -v apptainer || command -v singularity || echo apptainer)\n\"$APPTAINER\" 'instance' 'stop' 'id'",
+		},
+		{
+			name:              "prefixes",
+			commandPrefix:     "module load apptainer",
+			singularityPrefix: "srun --pty",
+			args:              []string{"--version"},
+			wantScript:        "module load apptainer\nAPPTAINER=$(command -v apptainer || command -v singularity || echo apptainer)\nsrun --pty \"$APPTAINER\" '--version'",
+		},
+		{
+			name:       "quotes",
+			args:       []string{"exec", "instance://id", "sh", "-c", "echo 'it works' $HOME"},
+			wantScript: "APPTAINER=$(command -v apptainer || command -v singularity || echo apptainer)\n\"$APPTAINER\" 'exec' 'instance://id' 'sh' '-c' 'echo '\"'\"'it works'\"'\"' $HOME'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &ApptainerRuntime{CommandPrefix: test.commandPrefix, SingularityPrefix: test.singularityPrefix, BashPath: "/bin/bash"}
+			got := a.command(context.Background(), test.args...).Args
+			want := []string{"/bin/bash", "-c", test.wantScript}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestApptainerCommandArguments(t *testing.T) {
+	// apptainer is replaced by a function printing its arguments, one per line
+	a := &ApptainerRuntime{CommandPrefix: `apptainer() { printf '%s\n' "$@"; }`, BashPath: "/bin/bash"}
+	args := []string{"exec", "--env-file", "/state/it's here/env", "instance://id", "sh", "-c", "echo \"$HOME\" `id` $(id) && true"}
+
+	output, err := a.command(context.Background(), args...).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	if !reflect.DeepEqual(got, args) {
+		t.Fatalf("got %q, want %q", got, args)
+	}
+}
+
+func TestApptainerInstanceStartArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		spec ContainerSpec
+		want []string
+	}{
+		{
+			name: "minimal",
+			spec: ContainerSpec{Image: "busybox"},
+			want: []string{"instance", "start", "--cleanenv", "--env-file", "/state/id/env", "docker://busybox", "id"},
+		},
+		{
+			name: "mounts, devices and GPUs",
+			spec: ContainerSpec{
+				Image: "/images/cuda.sif",
+				Mounts: []MountSpec{
+					{Source: "/data/config", Target: "/etc/config", ReadOnly: true},
+					{Source: "/data/scratch", Target: "/scratch"},
+				},
+				Devices: []string{"/dev/fuse"},
+				Runtime: "nvidia",
+			},
+			want: []string{
+				"instance", "start", "--cleanenv", "--env-file", "/state/id/env",
+				"--bind", "/data/config:/etc/config:ro", "--bind", "/data/scratch:/scratch", "--bind", "/dev/fuse", "--nv",
+				"/images/cuda.sif", "id",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &apptainerContainer{ID: "id", Spec: test.spec, dir: "/state/id"}
+			if got := c.instanceStartArgs(); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApptainerRunArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		spec ContainerSpec
+		want []string
+	}{
+		{
+			name: "runscript",
+			spec: ContainerSpec{Image: "busybox"},
+			want: []string{"run", "--cleanenv", "--env-file", "/state/id/env", "instance://id"},
+		},
+		{
+			name: "arguments of the runscript",
+			spec: ContainerSpec{Image: "busybox", Command: []string{"--port", "8888"}, WorkingDir: "/work"},
+			want: []string{"run", "--cleanenv", "--env-file", "/state/id/env", "--pwd", "/work", "instance://id", "--port", "8888"},
+		},
+		{
+			name: "entrypoint",
+			spec: ContainerSpec{Image: "busybox", Entrypoint: []string{"/bin/sh", "-c"}, Command: []string{"sleep 10"}},
+			want: []string{"exec", "--cleanenv", "--env-file", "/state/id/env", "instance://id", "/bin/sh", "-c", "sleep 10"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &apptainerContainer{ID: "id", Spec: test.spec, dir: "/state/id"}
+			if got := c.runArgs(); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// writeApptainerState writes the state of a container as left by a previous execution of the plugin
+func writeApptainerState(t *testing.T, stateDir string, id string, state string) {
+	t.Helper()
+
+	dir := filepath.Join(stateDir, "containers", id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "state.json"), []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewApptainerRuntimeRestoresState(t *testing.T) {
+	stateDir := t.TempDir()
+	startedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	writeApptainerState(t, stateDir, "running-id", `{"id": "running-id", "name": "pod_main", "spec": {"name": "pod_main", "image": "busybox", "labels": {"interlink.eu/pod-uid": "uid"}},
+		"state": {"Status": "running", "Running": true, "StartedAt": "2024-05-01T10:00:00Z"}}`)
+	writeApptainerState(t, stateDir, "exited-id", `{"id": "exited-id", "name": "pod_init", "spec": {"name": "pod_init", "image": "busybox"},
+		"state": {"Status": "exited", "ExitCode": 0, "StartedAt": "2024-05-01T10:00:00Z", "FinishedAt": "2024-05-01T10:00:01Z"}}`)
+	writeApptainerState(t, stateDir, "corrupted-id", `{"id": `)
+	if err := os.MkdirAll(filepath.Join(stateDir, "containers", "empty-id"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// the instances are stopped by /bin/true, which ignores its arguments
+	a, err := NewApptainerRuntime("", "", "/bin/true", stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containers, err := a.List(context.Background(), ListOptions{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("got %d containers, want the 2 valid ones", len(containers))
+	}
+
+	running, err := a.Inspect(context.Background(), "pod_main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running.ID != "running-id" || running.Image != "busybox" || running.ImageID != "docker://busybox" || running.Labels["interlink.eu/pod-uid"] != "uid" {
+		t.Fatalf("got %+v", running)
+	}
+	if state := running.State; state.Running || state.Status != "exited" || state.ExitCode != 137 || state.Error == "" || !state.StartedAt.Equal(startedAt) || state.FinishedAt.IsZero() {
+		t.Fatalf("got state %+v, want the container left running marked as killed", state)
+	}
+
+	exited, err := a.Inspect(context.Background(), "exited-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantState := ContainerState{Status: "exited", StartedAt: startedAt, FinishedAt: startedAt.Add(time.Second)}
+	if !reflect.DeepEqual(exited.State, wantState) {
+		t.Fatalf("got state %+v, want %+v", exited.State, wantState)
+	}
+
+	// the new state of the container left running is persisted
+	data, err := os.ReadFile(filepath.Join(stateDir, "containers", "running-id", "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved apptainerContainer
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.State.Running || saved.State.ExitCode != 137 {
+		t.Fatalf("got saved state %+v", saved.State)
+	}
+
+	if _, err := a.Inspect(context.Background(), "corrupted-id"); !IsNotFound(err) {
+		t.Fatalf("got %v, want the corrupted container ignored", err)
+	}
+}
+
+func TestApptainerLogs(t *testing.T) {
+	a, err := NewApptainerRuntime("", "", "/bin/true", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := a.Create(context.Background(), ContainerSpec{Name: "pod_main", Image: "busybox", Env: []string{"GREETING=it's me"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := a.lookup(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := os.ReadFile(c.envPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(env) != "GREETING='it'\"'\"'s me'\n" {
+		t.Fatalf("got env file %q", env)
+	}
+
+	// the log file is written by timestampWriter: the last line has been flushed without its newline
+	logs := "2024-05-01T10:00:00Z first\n2024-05-01T10:00:01Z second\n2024-05-01T10:00:02Z third"
+	if err := os.WriteFile(c.logPath(), []byte(logs), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts LogOptions
+		want string
+	}{
+		{name: "all", opts: LogOptions{}, want: "first\nsecond\nthird"},
+		{name: "timestamps", opts: LogOptions{Timestamps: true, Tail: "all"}, want: logs},
+		{name: "tail", opts: LogOptions{Tail: "2"}, want: "second\nthird"},
+		{name: "tail of the last line", opts: LogOptions{Tail: "1"}, want: "third"},
+		{name: "since", opts: LogOptions{Since: time.Date(2024, 5, 1, 10, 0, 1, 0, time.UTC)}, want: "second\nthird"},
+		{name: "follow an exited container", opts: LogOptions{Follow: true}, want: "first\nsecond\nthird"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := a.Logs(context.Background(), "pod_main", test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	if _, err := a.Logs(context.Background(), "pod_main", LogOptions{Tail: "ten"}); err == nil {
+		t.Fatal("invalid tail: got no error")
+	}
+	if _, err := a.Logs(context.Background(), "missing", LogOptions{}); !IsNotFound(err) {
+		t.Fatalf("missing container: got %v, want a not found error", err)
+	}
+}
+
+func TestTimestampWriter(t *testing.T) {
+	var output bytes.Buffer
+	writer := &timestampWriter{writer: &output}
+
+	for _, chunk := range []string{"fir", "st\nsecond\nth", "ird"} {
+		if n, err := writer.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("got %d, %v, want %d, nil", n, err, len(chunk))
+		}
+	}
+	writer.Flush()
+
+	lines := strings.SplitAfter(output.String(), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	want := []string{"first\n", "second\n", "third\n"}
+	if len(lines) != len(want) {
+		t.Fatalf("got lines %q, want %q", lines, want)
+	}
+	for i, line := range lines {
+		timestamp, message, _ := strings.Cut(line, " ")
+		if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		if message != want[i] {
+			t.Fatalf("got line %q, want %q", message, want[i])
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package containerruntime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+)
+
+// DockerRuntime implements ContainerRuntime on top of the Docker Engine API
+type DockerRuntime struct {
+	Client *client.Client
+}
+
+// NewDockerRuntime returns a DockerRuntime connected to the daemon listening on host (e.g. unix:///var/run/docker.sock).
+// If host is empty, the daemon is selected through the usual DOCKER_HOST environment variables.
+func NewDockerRuntime(host string) (*DockerRuntime, error) {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if host != "" {
+		opts = append(opts, client.WithHost(host))
+	}
+
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a new Docker client: %v", err)
+	}
+
+	return &DockerRuntime{Client: cli}, nil
+}
+
+// wrapError maps the not found errors of the Docker API to ErrNotFound
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errdefs.IsNotFound(err) {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
+
+func (d *DockerRuntime) Ping(ctx context.Context) error {
+	_, err := d.Client.Ping(ctx)
+	return err
+}
+
+func (d *DockerRuntime) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	config := &container.Config{
+		Image:        spec.Image,
+		Entrypoint:   spec.Entrypoint,
+		Cmd:          spec.Command,
+		Env:          spec.Env,
+		User:         spec.User,
+		WorkingDir:   spec.WorkingDir,
+		Labels:       spec.Labels,
+		ExposedPorts: nat.PortSet{},
+	}
+
+	hostConfig := &container.HostConfig{
+		Privileged:   spec.Privileged,
+		CapAdd:       spec.CapAdd,
+		Runtime:      spec.Runtime,
+		NetworkMode:  container.NetworkMode(spec.NetworkMode),
+		PortBindings: nat.PortMap{},
+		Resources: container.Resources{
+			Memory:   spec.MemoryBytes,
+			NanoCPUs: spec.NanoCPUs,
+		},
+	}
+
+	// binds are used instead of mounts to keep the docker run -v behaviour of creating missing host directories
+	for _, mount := range spec.Mounts {
+		hostConfig.Binds = append(hostConfig.Binds, bindString(mount))
+	}
+
+	for _, device := range spec.Devices {
+		hostConfig.Resources.Devices = append(hostConfig.Resources.Devices, container.DeviceMapping{
+			PathOnHost:        device,
+			PathInContainer:   device,
+			CgroupPermissions: "rwm",
+		})
+	}
+
+	for _, port := range spec.Ports {
+		protocol := strings.ToLower(port.Protocol)
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		containerPort, err := nat.NewPort(protocol, strconv.Itoa(int(port.ContainerPort)))
+		if err != nil {
+			return "", err
+		}
+		config.ExposedPorts[containerPort] = struct{}{}
+		hostConfig.PortBindings[containerPort] = append(hostConfig.PortBindings[containerPort], nat.PortBinding{HostPort: strconv.Itoa(int(port.HostPort))})
+	}
+
+	resp, err := d.Client.ContainerCreate(ctx, config, hostConfig, nil, nil, spec.Name)
+	if errdefs.IsNotFound(err) {
+		// same as docker run: pull the image if it is missing and try again
+		err = d.pull(ctx, spec.Image)
+		if err != nil {
+			return "", err
+		}
+		resp, err = d.Client.ContainerCreate(ctx, config, hostConfig, nil, nil, spec.Name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+func (d *DockerRuntime) pull(ctx context.Context, ref string) error {
+	reader, err := d.Client.ImagePull(ctx, ref, image.PullOptions{})
+	if err != nil {
+		return fmt.Errorf("%w: unable to pull image %s: %v", ErrImagePull, ref, err)
+	}
+	defer reader.Close()
+
+	// the pull is completed only when the progress stream has been fully consumed, which also reports its errors
+	err = jsonmessage.DisplayJSONMessagesStream(reader, io.Discard, 0, false, nil)
+	if err != nil {
+		return fmt.Errorf("%w: unable to pull image %s: %v", ErrImagePull, ref, err)
+	}
+	return nil
+}
+
+func bindString(mount MountSpec) string {
+	bind := mount.Source + ":" + mount.Target
+	var options []string
+	if mount.ReadOnly {
+		options = append(options, "ro")
+	}
+	if mount.Propagation != "" {
+		options = append(options, mount.Propagation)
+	}
+	if len(options) > 0 {
+		bind += ":" + strings.Join(options, ",")
+	}
+	return bind
+}
+
+func (d *DockerRuntime) Start(ctx context.Context, id string) error {
+	return wrapError(d.Client.ContainerStart(ctx, id, container.StartOptions{}))
+}
+
+func (d *DockerRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	seconds := int(math.Ceil(timeout.Seconds()))
+	return wrapError(d.Client.ContainerStop(ctx, id, container.StopOptions{Timeout: &seconds}))
+}
+
+func (d *DockerRuntime) Remove(ctx context.Context, id string, force bool) error {
+	return wrapError(d.Client.ContainerRemove(ctx, id, container.RemoveOptions{Force: force}))
+}
+
+func (d *DockerRuntime) Rename(ctx context.Context, id string, name string) error {
+	return wrapError(d.Client.ContainerRename(ctx, id, name))
+}
+
+func (d *DockerRuntime) Inspect(ctx context.Context, id string) (ContainerInfo, error) {
+	containerJSON, err := d.Client.ContainerInspect(ctx, id)
+	if err != nil {
+		return ContainerInfo{}, wrapError(err)
+	}
+
+	info := ContainerInfo{
+		ID:      containerJSON.ID,
+		Name:    strings.TrimPrefix(containerJSON.Name, "/"),
+		ImageID: containerJSON.Image,
+	}
+
+	if containerJSON.Config != nil {
+		info.Image = containerJSON.Config.Image
+		info.Labels = containerJSON.Config.Labels
+	}
+
+	if containerJSON.NetworkSettings != nil {
+		info.IPAddress = containerJSON.NetworkSettings.IPAddress
+		if info.IPAddress == "" {
+			for _, endpoint := range containerJSON.NetworkSettings.Networks {
+				if endpoint != nil && endpoint.IPAddress != "" {
+					info.IPAddress = endpoint.IPAddress
+					break
+				}
+			}
+		}
+	}
+
+	if containerJSON.State != nil {
+		info.State = ContainerState{
+			Status:     containerJSON.State.Status,
+			Running:    containerJSON.State.Running,
+			ExitCode:   containerJSON.State.ExitCode,
+			OOMKilled:  containerJSON.State.OOMKilled,
+			Error:      containerJSON.State.Error,
+			StartedAt:  parseTime(containerJSON.State.StartedAt),
+			FinishedAt: parseTime(containerJSON.State.FinishedAt),
+		}
+	}
+
+	return info, nil
+}
+
+// parseTime parses the timestamps returned by the Docker API, the zero value is returned for never set timestamps
+func parseTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || t.Year() <= 1 {
+		return time.Time{}
+	}
+	return t
+}
+
+func (d *DockerRuntime) List(ctx context.Context, opts ListOptions) ([]ContainerInfo, error) {
+	args := filters.NewArgs()
+	if opts.Name != "" {
+		args.Add("name", opts.Name)
+	}
+	for key, value := range opts.Labels {
+		args.Add("label", labelFilter(key, value))
+	}
+
+	containers, err := d.Client.ContainerList(ctx, container.ListOptions{All: opts.All, Filters: args})
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ContainerInfo
+	for _, c := range containers {
+		name := ""
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		infos = append(infos, ContainerInfo{
+			ID:      c.ID,
+			Name:    name,
+			Image:   c.Image,
+			ImageID: c.ImageID,
+			Labels:  c.Labels,
+			State: ContainerState{
+				Status:  c.State,
+				Running: c.State == "running",
+			},
+		})
+	}
+
+	return infos, nil
+}
+
+// Logs returns the stdout and stderr of the container merged in a single stream
+func (d *DockerRuntime) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	containerJSON, err := d.Client.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	logsOptions := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: opts.Timestamps,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+	}
+	if !opts.Since.IsZero() {
+		logsOptions.Since = fmt.Sprintf("%d.%09d", opts.Since.Unix(), opts.Since.Nanosecond())
+	}
+
+	reader, err := d.Client.ContainerLogs(ctx, id, logsOptions)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	// a TTY container has a raw stream, otherwise stdout and stderr are multiplexed
+	if containerJSON.Config != nil && containerJSON.Config.Tty {
+		return reader, nil
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pipeWriter, pipeWriter, reader)
+		reader.Close()
+		pipeWriter.CloseWithError(err)
+	}()
+
+	return pipeReader, nil
+}
+
+func (d *DockerRuntime) Exec(ctx context.Context, id string, cmd []string) (ExecResult, error) {
+	execID, err := d.Client.ContainerExecCreate(ctx, id, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return ExecResult{}, wrapError(err)
+	}
+
+	attach, err := d.Client.ContainerExecAttach(ctx, execID.ID, types.ExecStartCheck{})
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer attach.Close()
+
+	var stdout, stderr bytes.Buffer
+	_, err = stdcopy.StdCopy(&stdout, &stderr, attach.Reader)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	inspect, err := d.Client.ContainerExecInspect(ctx, execID.ID)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return ExecResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: inspect.ExitCode}, nil
+}
+
+func (d *DockerRuntime) ExecAttach(ctx context.Context, id string, opts ExecOptions) (ExecSession, error) {
+	execID, err := d.Client.ContainerExecCreate(ctx, id, types.ExecConfig{
+		Cmd:          opts.Cmd,
+		AttachStdin:  opts.Stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          opts.Tty,
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	attach, err := d.Client.ContainerExecAttach(ctx, execID.ID, types.ExecStartCheck{Tty: opts.Tty})
+	if err != nil {
+		return nil, err
+	}
+
+	resize := func(ctx context.Context, height uint, width uint) error {
+		return d.Client.ContainerExecResize(ctx, execID.ID, container.ResizeOptions{Height: height, Width: width})
+	}
+	inspect := func(ctx context.Context) (bool, int, error) {
+		inspect, err := d.Client.ContainerExecInspect(ctx, execID.ID)
+		return inspect.Running, inspect.ExitCode, err
+	}
+
+	return newStreamExecSession(attach.Conn, attach.Reader, attach.CloseWrite, opts, resize, inspect), nil
+}
+
+// Stats returns the usage of the container computed by the daemon, along with the size of its writable layer if asked for
+func (d *DockerRuntime) Stats(ctx context.Context, id string, opts StatsOptions) (ContainerStats, error) {
+	resp, err := d.Client.ContainerStatsOneShot(ctx, id)
+	if err != nil {
+		return ContainerStats{}, wrapError(err)
+	}
+	defer resp.Body.Close()
+
+	var statsJSON types.StatsJSON
+	err = json.NewDecoder(resp.Body).Decode(&statsJSON)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+	stats := dockerStats(statsJSON)
+	if !opts.Size {
+		return stats, nil
+	}
+
+	// the size of the writable layer is only computed when asked for
+	containerJSON, _, err := d.Client.ContainerInspectWithRaw(ctx, id, true)
+	if err != nil {
+		return ContainerStats{}, wrapError(err)
+	}
+	if containerJSON.SizeRw != nil && *containerJSON.SizeRw > 0 {
+		stats.RootfsUsedBytes = uint64(*containerJSON.SizeRw)
+	}
+
+	return stats, nil
+}
+
+// dockerStats converts the stats of the Docker API, also returned by the compatible API of Podman.
+// The memory counters are named differently with cgroup v1 and v2, the working set is computed as the kubelet does.
+func dockerStats(statsJSON types.StatsJSON) ContainerStats {
+	memory := statsJSON.MemoryStats
+	stats := ContainerStats{
+		Time:                statsJSON.Read,
+		CPUUsageNanoSeconds: statsJSON.CPUStats.CPUUsage.TotalUsage,
+		MemoryUsageBytes:    memory.Usage,
+		MemoryRSSBytes:      memoryStat(memory.Stats, "anon", "total_rss"),
+		MemoryLimitBytes:    memory.Limit,
+		PageFaults:          memoryStat(memory.Stats, "pgfault", "total_pgfault"),
+		MajorPageFaults:     memoryStat(memory.Stats, "pgmajfault", "total_pgmajfault"),
+	}
+	if stats.Time.IsZero() {
+		stats.Time = time.Now()
+	}
+
+	inactiveFile := memoryStat(memory.Stats, "inactive_file", "total_inactive_file")
+	if memory.Usage > inactiveFile {
+		stats.MemoryWorkingSetBytes = memory.Usage - inactiveFile
+	}
+
+	for name, network := range statsJSON.Networks {
+		stats.Networks = append(stats.Networks, InterfaceStats{
+			Name:     name,
+			RxBytes:  network.RxBytes,
+			RxErrors: network.RxErrors,
+			TxBytes:  network.TxBytes,
+			TxErrors: network.TxErrors,
+		})
+	}
+
+	return stats
+}
+
+// memoryStat returns the first of the memory counters found, the cgroup v2 name being given before the cgroup v1 one
+func memoryStat(stats map[string]uint64, names ...string) uint64 {
+	for _, name := range names {
+		if value, ok := stats[name]; ok {
+			return value
+		}
+	}
+	return 0
+}
+
+func (d *DockerRuntime) CreateNetwork(ctx context.Context, name string) (string, error) {
+	resp, err := d.Client.NetworkCreate(ctx, name, types.NetworkCreate{Driver: "bridge"})
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (d *DockerRuntime) RemoveNetwork(ctx context.Context, id string) error {
+	return wrapError(d.Client.NetworkRemove(ctx, id))
+}
+
+func (d *DockerRuntime) ConnectNetwork(ctx context.Context, networkID string, id string, ip string) error {
+	endpoint := &network.EndpointSettings{}
+	if ip != "" {
+		endpoint.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: ip}
+	}
+	return wrapError(d.Client.NetworkConnect(ctx, networkID, id, endpoint))
+}
+
+// ListNetworks returns the networks whose name matches the regular expression name
+func (d *DockerRuntime) ListNetworks(ctx context.Context, name string) ([]NetworkInfo, error) {
+	args := filters.NewArgs()
+	if name != "" {
+		args.Add("name", name)
+	}
+
+	networks, err := d.Client.NetworkList(ctx, types.NetworkListOptions{Filters: args})
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []NetworkInfo
+	for _, n := range networks {
+		infos = append(infos, NetworkInfo{ID: n.ID, Name: n.Name})
+	}
+	return infos, nil
+}
+
+func (d *DockerRuntime) Events(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	messages, errs := d.Client.Events(ctx, types.EventsOptions{Filters: filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))})
+
+	containerEvents := make(chan ContainerEvent)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message := <-messages:
+				select {
+				case containerEvents <- ContainerEvent{ID: message.Actor.ID, Name: message.Actor.Attributes["name"], Action: string(message.Action), Labels: message.Actor.Attributes}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return containerEvents, errs
+}
+
+func (d *DockerRuntime) Close() error {
+	return d.Client.Close()
+}
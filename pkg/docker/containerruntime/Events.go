@@ -0,0 +1,59 @@
+package containerruntime
+
+import (
+	"context"
+	"sync"
+)
+
+// ContainerEvent notifies a change of a container, e.g. its start, its termination or its removal
+type ContainerEvent struct {
+	ID   string
+	Name string
+	// Action is the change that happened to the container: create, start, restart, die, oom, pause, unpause, rename or destroy
+	Action string
+	Labels map[string]string
+}
+
+// eventBroadcaster sends the events generated by a runtime without a daemon to all the subscribers of its event stream
+type eventBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan ContainerEvent]struct{}
+}
+
+// subscribe returns the stream of the events published from now on, until ctx is done
+func (b *eventBroadcaster) subscribe(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	events := make(chan ContainerEvent, 64)
+	errs := make(chan error, 1)
+
+	b.mu.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan ContainerEvent]struct{})
+	}
+	b.subscribers[events] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		delete(b.subscribers, events)
+		b.mu.Unlock()
+
+		errs <- ctx.Err()
+	}()
+
+	return events, errs
+}
+
+// publish sends the event to the subscribers without blocking: a subscriber too slow to receive it misses the event, and is expected to resync
+func (b *eventBroadcaster) publish(event ContainerEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
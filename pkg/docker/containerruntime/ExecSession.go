@@ -0,0 +1,122 @@
+package containerruntime
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// execInspectFunc returns whether an exec is still running and its exit code
+type execInspectFunc func(ctx context.Context) (bool, int, error)
+
+// streamExecSession is an ExecSession over a hijacked connection of the Docker or libpod API,
+// which multiplexes stdout and stderr unless a terminal is allocated
+type streamExecSession struct {
+	conn       io.Closer
+	stdin      io.Writer
+	closeWrite func() error
+	attachIn   bool
+
+	stdout *io.PipeReader
+	stderr *io.PipeReader
+	copied chan struct{}
+
+	resize  func(ctx context.Context, height uint, width uint) error
+	inspect execInspectFunc
+}
+
+func newStreamExecSession(conn io.ReadWriteCloser, reader io.Reader, closeWrite func() error, opts ExecOptions, resize func(ctx context.Context, height uint, width uint) error, inspect execInspectFunc) *streamExecSession {
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+
+	session := &streamExecSession{
+		conn:       conn,
+		stdin:      conn,
+		closeWrite: closeWrite,
+		attachIn:   opts.Stdin,
+		stdout:     stdoutReader,
+		stderr:     stderrReader,
+		copied:     make(chan struct{}),
+		resize:     resize,
+		inspect:    inspect,
+	}
+
+	go func() {
+		defer close(session.copied)
+
+		var err error
+		if opts.Tty {
+			_, err = io.Copy(stdoutWriter, reader)
+		} else {
+			_, err = stdcopy.StdCopy(stdoutWriter, stderrWriter, reader)
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = nil
+		}
+		stdoutWriter.CloseWithError(err)
+		stderrWriter.CloseWithError(err)
+	}()
+
+	return session
+}
+
+// stdinWriter closes only the write side of the connection, so that the output of the command can still be read
+type stdinWriter struct {
+	io.Writer
+	closeWrite func() error
+}
+
+func (s stdinWriter) Close() error {
+	return s.closeWrite()
+}
+
+func (s *streamExecSession) Stdin() io.WriteCloser {
+	if !s.attachIn {
+		return nil
+	}
+	return stdinWriter{Writer: s.stdin, closeWrite: s.closeWrite}
+}
+
+func (s *streamExecSession) Stdout() io.Reader {
+	return s.stdout
+}
+
+func (s *streamExecSession) Stderr() io.Reader {
+	return s.stderr
+}
+
+func (s *streamExecSession) Resize(ctx context.Context, height uint, width uint) error {
+	return s.resize(ctx, height, width)
+}
+
+func (s *streamExecSession) Wait(ctx context.Context) (int, error) {
+	select {
+	case <-s.copied:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	// the output may be closed slightly before the exit code of the command is known
+	for {
+		running, exitCode, err := s.inspect(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if !running {
+			return exitCode, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (s *streamExecSession) Close() error {
+	return s.conn.Close()
+}
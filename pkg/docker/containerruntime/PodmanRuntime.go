@@ -0,0 +1,840 @@
+package containerruntime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const podmanAPIVersion = "v4.0.0"
+
+// PodmanRuntime implements ContainerRuntime on top of the libpod REST API exposed by "podman system service".
+// Besides containers, it manages Podman pods, which are used as sandboxes for the PODs.
+type PodmanRuntime struct {
+	client     *http.Client
+	baseURL    string
+	socketPath string
+}
+
+// PodSpec holds what is needed to create a Podman pod
+type PodSpec struct {
+	Name   string
+	Labels map[string]string
+	// Ports are published by the pod, since the containers of a Podman pod cannot publish ports themselves
+	Ports []PortSpec
+	// Network and IP, if set, attach the pod to an existing network with a static IP
+	Network string
+	IP      string
+}
+
+// PodInfo is the result of inspecting a Podman pod
+type PodInfo struct {
+	ID    string
+	Name  string
+	State string
+}
+
+// DefaultPodmanSocket returns the socket of the Podman service of the current user
+func DefaultPodmanSocket() string {
+	if os.Geteuid() == 0 {
+		return "/run/podman/podman.sock"
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "podman", "podman.sock")
+	}
+	return fmt.Sprintf("/run/user/%d/podman/podman.sock", os.Geteuid())
+}
+
+// NewPodmanRuntime returns a PodmanRuntime connected to the Podman service listening on socketPath.
+// If socketPath is empty, DefaultPodmanSocket is used.
+func NewPodmanRuntime(socketPath string) *PodmanRuntime {
+	socketPath = strings.TrimPrefix(socketPath, "unix://")
+	if socketPath == "" {
+		socketPath = DefaultPodmanSocket()
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &PodmanRuntime{
+		client:     &http.Client{Transport: transport},
+		baseURL:    "http://podman/" + podmanAPIVersion + "/libpod",
+		socketPath: socketPath,
+	}
+}
+
+// do sends a request to the libpod API. Error responses are turned into errors, wrapping ErrNotFound for 404s.
+func (p *PodmanRuntime) do(ctx context.Context, method string, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	requestURL := p.baseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiError)
+		err = fmt.Errorf("podman API %s %s returned %d: %s", method, path, resp.StatusCode, apiError.Message)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// doJSON sends a request to the libpod API and decodes the response into out, if not nil
+func (p *PodmanRuntime) doJSON(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	resp, err := p.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *PodmanRuntime) Ping(ctx context.Context) error {
+	return p.doJSON(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+type podmanMount struct {
+	Destination string   `json:"destination"`
+	Source      string   `json:"source"`
+	Type        string   `json:"type"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type podmanDevice struct {
+	Path string `json:"path"`
+}
+
+type podmanPortMapping struct {
+	ContainerPort uint16 `json:"container_port"`
+	HostPort      uint16 `json:"host_port"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+type podmanNamespace struct {
+	NSMode string `json:"nsmode"`
+}
+
+type podmanNetworkOptions struct {
+	StaticIPs []string `json:"static_ips,omitempty"`
+}
+
+type podmanResourceLimits struct {
+	Memory *struct {
+		Limit int64 `json:"limit"`
+	} `json:"memory,omitempty"`
+	CPU *struct {
+		Quota  int64  `json:"quota"`
+		Period uint64 `json:"period"`
+	} `json:"cpu,omitempty"`
+}
+
+// podmanSpecGenerator is the subset of the libpod SpecGenerator used by the sidecar
+type podmanSpecGenerator struct {
+	Name           string                          `json:"name,omitempty"`
+	Image          string                          `json:"image"`
+	Entrypoint     []string                        `json:"entrypoint,omitempty"`
+	Command        []string                        `json:"command,omitempty"`
+	Env            map[string]string               `json:"env,omitempty"`
+	User           string                          `json:"user,omitempty"`
+	WorkDir        string                          `json:"work_dir,omitempty"`
+	Labels         map[string]string               `json:"labels,omitempty"`
+	Mounts         []podmanMount                   `json:"mounts,omitempty"`
+	Devices        []podmanDevice                  `json:"devices,omitempty"`
+	PortMappings   []podmanPortMapping             `json:"portmappings,omitempty"`
+	Privileged     bool                            `json:"privileged,omitempty"`
+	CapAdd         []string                        `json:"cap_add,omitempty"`
+	OCIRuntime     string                          `json:"oci_runtime,omitempty"`
+	Pod            string                          `json:"pod,omitempty"`
+	ResourceLimits *podmanResourceLimits           `json:"resource_limits,omitempty"`
+	NetNS          *podmanNamespace                `json:"netns,omitempty"`
+	Networks       map[string]podmanNetworkOptions `json:"Networks,omitempty"`
+}
+
+func podmanPortMappings(ports []PortSpec) []podmanPortMapping {
+	var mappings []podmanPortMapping
+	for _, port := range ports {
+		mappings = append(mappings, podmanPortMapping{
+			ContainerPort: uint16(port.ContainerPort),
+			HostPort:      uint16(port.HostPort),
+			Protocol:      strings.ToLower(port.Protocol),
+		})
+	}
+	return mappings
+}
+
+func (p *PodmanRuntime) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	specGenerator := podmanSpecGenerator{
+		Name:       spec.Name,
+		Image:      spec.Image,
+		Entrypoint: spec.Entrypoint,
+		Command:    spec.Command,
+		Env:        map[string]string{},
+		User:       spec.User,
+		WorkDir:    spec.WorkingDir,
+		Labels:     spec.Labels,
+		Privileged: spec.Privileged,
+		CapAdd:     spec.CapAdd,
+		Pod:        spec.Pod,
+	}
+
+	for _, env := range spec.Env {
+		name, value, _ := strings.Cut(env, "=")
+		specGenerator.Env[name] = value
+	}
+
+	for _, mount := range spec.Mounts {
+		options := []string{"rbind"}
+		if mount.ReadOnly {
+			options = append(options, "ro")
+		}
+		if mount.Propagation != "" {
+			options = append(options, "r"+mount.Propagation)
+		}
+		specGenerator.Mounts = append(specGenerator.Mounts, podmanMount{Destination: mount.Target, Source: mount.Source, Type: "bind", Options: options})
+	}
+
+	for _, device := range spec.Devices {
+		specGenerator.Devices = append(specGenerator.Devices, podmanDevice{Path: device})
+	}
+
+	// Podman exposes NVIDIA GPUs as CDI devices instead of through the nvidia runtime
+	if spec.Runtime == "nvidia" {
+		for _, env := range spec.Env {
+			if gpuIndexes, ok := strings.CutPrefix(env, "NVIDIA_VISIBLE_DEVICES="); ok {
+				for _, gpuIndex := range strings.Split(gpuIndexes, ",") {
+					specGenerator.Devices = append(specGenerator.Devices, podmanDevice{Path: "nvidia.com/gpu=" + gpuIndex})
+				}
+			}
+		}
+	} else {
+		specGenerator.OCIRuntime = spec.Runtime
+	}
+
+	// the containers of a pod share its network, ports can only be published by the pod
+	if spec.Pod == "" {
+		specGenerator.PortMappings = podmanPortMappings(spec.Ports)
+
+		switch spec.NetworkMode {
+		case "":
+		case "host":
+			specGenerator.NetNS = &podmanNamespace{NSMode: "host"}
+		default:
+			specGenerator.NetNS = &podmanNamespace{NSMode: "bridge"}
+			specGenerator.Networks = map[string]podmanNetworkOptions{spec.NetworkMode: {}}
+		}
+	}
+
+	if spec.MemoryBytes != 0 || spec.NanoCPUs != 0 {
+		specGenerator.ResourceLimits = &podmanResourceLimits{}
+		if spec.MemoryBytes != 0 {
+			specGenerator.ResourceLimits.Memory = &struct {
+				Limit int64 `json:"limit"`
+			}{Limit: spec.MemoryBytes}
+		}
+		if spec.NanoCPUs != 0 {
+			period := uint64(100000)
+			specGenerator.ResourceLimits.CPU = &struct {
+				Quota  int64  `json:"quota"`
+				Period uint64 `json:"period"`
+			}{Quota: spec.NanoCPUs * int64(period) / 1000000000, Period: period}
+		}
+	}
+
+	err := p.ensureImage(ctx, spec.Image)
+	if err != nil {
+		return "", err
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err = p.doJSON(ctx, http.MethodPost, "/containers/create", nil, specGenerator, &created)
+	if err != nil {
+		return "", err
+	}
+
+	return created.ID, nil
+}
+
+// ensureImage pulls the image if it is not already present, as podman run does
+func (p *PodmanRuntime) ensureImage(ctx context.Context, ref string) error {
+	err := p.doJSON(ctx, http.MethodGet, "/images/"+url.PathEscape(ref)+"/exists", nil, nil, nil)
+	if !IsNotFound(err) {
+		return err
+	}
+
+	resp, err := p.do(ctx, http.MethodPost, "/images/pull", url.Values{"reference": {ref}, "quiet": {"true"}}, nil)
+	if err != nil {
+		return fmt.Errorf("%w: unable to pull image %s: %v", ErrImagePull, ref, err)
+	}
+	defer resp.Body.Close()
+
+	// the pull reports its progress, and its errors, as a stream of JSON objects
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var report struct {
+			Error string `json:"error"`
+		}
+		err = decoder.Decode(&report)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if report.Error != "" {
+			return fmt.Errorf("%w: unable to pull image %s: %s", ErrImagePull, ref, report.Error)
+		}
+	}
+}
+
+func (p *PodmanRuntime) Start(ctx context.Context, id string) error {
+	return p.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil, nil)
+}
+
+func (p *PodmanRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	seconds := int(math.Ceil(timeout.Seconds()))
+	return p.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/stop", url.Values{"timeout": {strconv.Itoa(seconds)}}, nil, nil)
+}
+
+func (p *PodmanRuntime) Remove(ctx context.Context, id string, force bool) error {
+	return p.doJSON(ctx, http.MethodDelete, "/containers/"+url.PathEscape(id), url.Values{"force": {strconv.FormatBool(force)}}, nil, nil)
+}
+
+func (p *PodmanRuntime) Rename(ctx context.Context, id string, name string) error {
+	return p.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/rename", url.Values{"name": {name}}, nil, nil)
+}
+
+type podmanContainerInspect struct {
+	ID        string `json:"Id"`
+	Name      string `json:"Name"`
+	Image     string `json:"Image"`
+	ImageName string `json:"ImageName"`
+	Config    struct {
+		Labels map[string]string `json:"Labels"`
+		Tty    bool              `json:"Tty"`
+	} `json:"Config"`
+	State struct {
+		Status     string    `json:"Status"`
+		Running    bool      `json:"Running"`
+		ExitCode   int       `json:"ExitCode"`
+		OOMKilled  bool      `json:"OOMKilled"`
+		Error      string    `json:"Error"`
+		StartedAt  time.Time `json:"StartedAt"`
+		FinishedAt time.Time `json:"FinishedAt"`
+	} `json:"State"`
+	NetworkSettings struct {
+		IPAddress string `json:"IPAddress"`
+		Networks  map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// podmanStatus maps the Podman container states to the Docker ones used by ContainerState
+func podmanStatus(status string) string {
+	switch status {
+	case "configured", "initialized":
+		return "created"
+	case "stopped", "stopping":
+		return "exited"
+	default:
+		return status
+	}
+}
+
+// zeroIfUnset returns the zero time for the "0001-01-01" timestamps of never started or finished containers
+func zeroIfUnset(t time.Time) time.Time {
+	if t.Year() <= 1 {
+		return time.Time{}
+	}
+	return t
+}
+
+func (p *PodmanRuntime) inspect(ctx context.Context, id string) (podmanContainerInspect, error) {
+	var containerJSON podmanContainerInspect
+	err := p.doJSON(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", nil, nil, &containerJSON)
+	return containerJSON, err
+}
+
+func (p *PodmanRuntime) Inspect(ctx context.Context, id string) (ContainerInfo, error) {
+	containerJSON, err := p.inspect(ctx, id)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+
+	info := ContainerInfo{
+		ID:        containerJSON.ID,
+		Name:      containerJSON.Name,
+		Image:     containerJSON.ImageName,
+		ImageID:   containerJSON.Image,
+		Labels:    containerJSON.Config.Labels,
+		IPAddress: containerJSON.NetworkSettings.IPAddress,
+		State: ContainerState{
+			Status:     podmanStatus(containerJSON.State.Status),
+			Running:    containerJSON.State.Running,
+			ExitCode:   containerJSON.State.ExitCode,
+			OOMKilled:  containerJSON.State.OOMKilled,
+			Error:      containerJSON.State.Error,
+			StartedAt:  zeroIfUnset(containerJSON.State.StartedAt),
+			FinishedAt: zeroIfUnset(containerJSON.State.FinishedAt),
+		},
+	}
+
+	if info.IPAddress == "" {
+		for _, endpoint := range containerJSON.NetworkSettings.Networks {
+			if endpoint.IPAddress != "" {
+				info.IPAddress = endpoint.IPAddress
+				break
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// podmanFilters encodes the filters query parameter of the libpod list endpoints
+func podmanFilters(filters map[string][]string) url.Values {
+	query := url.Values{}
+	if len(filters) > 0 {
+		data, _ := json.Marshal(filters)
+		query.Set("filters", string(data))
+	}
+	return query
+}
+
+func (p *PodmanRuntime) List(ctx context.Context, opts ListOptions) ([]ContainerInfo, error) {
+	filters := map[string][]string{}
+	if opts.Name != "" {
+		filters["name"] = []string{opts.Name}
+	}
+	for key, value := range opts.Labels {
+		filters["label"] = append(filters["label"], labelFilter(key, value))
+	}
+
+	query := podmanFilters(filters)
+	query.Set("all", strconv.FormatBool(opts.All))
+
+	var containers []struct {
+		ID      string            `json:"Id"`
+		Names   []string          `json:"Names"`
+		Image   string            `json:"Image"`
+		ImageID string            `json:"ImageID"`
+		Labels  map[string]string `json:"Labels"`
+		State   string            `json:"State"`
+	}
+	err := p.doJSON(ctx, http.MethodGet, "/containers/json", query, nil, &containers)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ContainerInfo
+	for _, c := range containers {
+		name := ""
+		if len(c.Names) > 0 {
+			name = c.Names[0]
+		}
+		status := podmanStatus(c.State)
+		infos = append(infos, ContainerInfo{
+			ID:      c.ID,
+			Name:    name,
+			Image:   c.Image,
+			ImageID: c.ImageID,
+			Labels:  c.Labels,
+			State: ContainerState{
+				Status:  status,
+				Running: status == "running",
+			},
+		})
+	}
+
+	return infos, nil
+}
+
+// Logs returns the stdout and stderr of the container merged in a single stream
+func (p *PodmanRuntime) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	containerJSON, err := p.inspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"stdout":     {"true"},
+		"stderr":     {"true"},
+		"follow":     {strconv.FormatBool(opts.Follow)},
+		"timestamps": {strconv.FormatBool(opts.Timestamps)},
+	}
+	if opts.Tail != "" {
+		query.Set("tail", opts.Tail)
+	}
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339Nano))
+	}
+
+	resp, err := p.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/logs", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if containerJSON.Config.Tty {
+		return resp.Body, nil
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pipeWriter, pipeWriter, resp.Body)
+		resp.Body.Close()
+		pipeWriter.CloseWithError(err)
+	}()
+
+	return pipeReader, nil
+}
+
+func (p *PodmanRuntime) Exec(ctx context.Context, id string, cmd []string) (ExecResult, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := p.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/exec", nil, map[string]interface{}{
+		"Cmd":          cmd,
+		"AttachStdout": true,
+		"AttachStderr": true,
+	}, &created)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	resp, err := p.do(ctx, http.MethodPost, "/exec/"+created.ID+"/start", nil, map[string]interface{}{"Detach": false, "Tty": false})
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer resp.Body.Close()
+
+	var stdout, stderr bytes.Buffer
+	_, err = stdcopy.StdCopy(&stdout, &stderr, resp.Body)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return ExecResult{}, err
+	}
+
+	var inspect struct {
+		ExitCode int `json:"ExitCode"`
+	}
+	err = p.doJSON(ctx, http.MethodGet, "/exec/"+created.ID+"/json", nil, nil, &inspect)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return ExecResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: inspect.ExitCode}, nil
+}
+
+func (p *PodmanRuntime) ExecAttach(ctx context.Context, id string, opts ExecOptions) (ExecSession, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := p.doJSON(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/exec", nil, map[string]interface{}{
+		"Cmd":          opts.Cmd,
+		"AttachStdin":  opts.Stdin,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          opts.Tty,
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, reader, err := p.hijack(ctx, "/exec/"+created.ID+"/start", map[string]interface{}{"Detach": false, "Tty": opts.Tty})
+	if err != nil {
+		return nil, err
+	}
+
+	resize := func(ctx context.Context, height uint, width uint) error {
+		query := url.Values{"h": {strconv.FormatUint(uint64(height), 10)}, "w": {strconv.FormatUint(uint64(width), 10)}}
+		return p.doJSON(ctx, http.MethodPost, "/exec/"+created.ID+"/resize", query, nil, nil)
+	}
+	inspect := func(ctx context.Context) (bool, int, error) {
+		var inspect struct {
+			Running  bool `json:"Running"`
+			ExitCode int  `json:"ExitCode"`
+		}
+		err := p.doJSON(ctx, http.MethodGet, "/exec/"+created.ID+"/json", nil, nil, &inspect)
+		return inspect.Running, inspect.ExitCode, err
+	}
+
+	return newStreamExecSession(conn, reader, conn.CloseWrite, opts, resize, inspect), nil
+}
+
+// hijack sends a POST request to the libpod API and takes over its connection, to stream the input and the output of an exec.
+// The http.Client cannot be used, since it does not allow writing to the connection once the response has been received.
+func (p *PodmanRuntime) hijack(ctx context.Context, path string, body interface{}) (*net.UnixConn, io.Reader, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", p.socketPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusSwitchingProtocols {
+		defer conn.Close()
+		message, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("podman API POST %s returned %d: %s", path, resp.StatusCode, strings.TrimSpace(string(message)))
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+		return nil, nil, err
+	}
+
+	return conn.(*net.UnixConn), reader, nil
+}
+
+// Stats returns the usage of the container in the format of the Docker API, along with the size of its writable layer if asked for
+func (p *PodmanRuntime) Stats(ctx context.Context, id string, opts StatsOptions) (ContainerStats, error) {
+	var statsJSON types.StatsJSON
+	err := p.doJSON(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/stats", url.Values{"stream": {"false"}}, nil, &statsJSON)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+	stats := dockerStats(statsJSON)
+	if !opts.Size {
+		return stats, nil
+	}
+
+	// the size of the writable layer is only computed when asked for
+	var containerJSON struct {
+		SizeRw *int64 `json:"SizeRw"`
+	}
+	err = p.doJSON(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", url.Values{"size": {"true"}}, nil, &containerJSON)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+	if containerJSON.SizeRw != nil && *containerJSON.SizeRw > 0 {
+		stats.RootfsUsedBytes = uint64(*containerJSON.SizeRw)
+	}
+
+	return stats, nil
+}
+
+func (p *PodmanRuntime) CreateNetwork(ctx context.Context, name string) (string, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	err := p.doJSON(ctx, http.MethodPost, "/networks/create", nil, map[string]string{"name": name, "driver": "bridge"}, &created)
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (p *PodmanRuntime) RemoveNetwork(ctx context.Context, id string) error {
+	return p.doJSON(ctx, http.MethodDelete, "/networks/"+url.PathEscape(id), nil, nil, nil)
+}
+
+func (p *PodmanRuntime) ConnectNetwork(ctx context.Context, network string, id string, ip string) error {
+	body := map[string]interface{}{"container": id}
+	if ip != "" {
+		body["static_ips"] = []string{ip}
+	}
+	return p.doJSON(ctx, http.MethodPost, "/networks/"+url.PathEscape(network)+"/connect", nil, body, nil)
+}
+
+// ListNetworks returns the networks whose name matches the regular expression name
+func (p *PodmanRuntime) ListNetworks(ctx context.Context, name string) ([]NetworkInfo, error) {
+	filters := map[string][]string{}
+	if name != "" {
+		filters["name"] = []string{name}
+	}
+
+	var networks []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	err := p.doJSON(ctx, http.MethodGet, "/networks/json", podmanFilters(filters), nil, &networks)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []NetworkInfo
+	for _, n := range networks {
+		infos = append(infos, NetworkInfo{ID: n.ID, Name: n.Name})
+	}
+	return infos, nil
+}
+
+// Events streams the events of the containers from the libpod API, which sends them in the format of the Docker API
+func (p *PodmanRuntime) Events(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	containerEvents := make(chan ContainerEvent)
+	errs := make(chan error, 1)
+
+	go func() {
+		query := podmanFilters(map[string][]string{"type": {"container"}})
+		query.Set("stream", "true")
+
+		resp, err := p.do(ctx, http.MethodGet, "/events", query, nil)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var message struct {
+				Action string `json:"Action"`
+				Actor  struct {
+					ID         string            `json:"ID"`
+					Attributes map[string]string `json:"Attributes"`
+				} `json:"Actor"`
+			}
+			err := decoder.Decode(&message)
+			if err != nil {
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				}
+				errs <- err
+				return
+			}
+
+			action := message.Action
+			if action == "died" {
+				action = "die"
+			}
+
+			select {
+			case containerEvents <- ContainerEvent{ID: message.Actor.ID, Name: message.Actor.Attributes["name"], Action: action, Labels: message.Actor.Attributes}:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return containerEvents, errs
+}
+
+// CreatePod creates a Podman pod, its infra container is started along with the first container of the pod
+func (p *PodmanRuntime) CreatePod(ctx context.Context, spec PodSpec) (string, error) {
+	body := map[string]interface{}{
+		"name":   spec.Name,
+		"labels": spec.Labels,
+	}
+	if len(spec.Ports) > 0 {
+		body["portmappings"] = podmanPortMappings(spec.Ports)
+	}
+	if spec.Network != "" {
+		networkOptions := podmanNetworkOptions{}
+		if spec.IP != "" {
+			networkOptions.StaticIPs = []string{spec.IP}
+		}
+		body["netns"] = podmanNamespace{NSMode: "bridge"}
+		body["Networks"] = map[string]podmanNetworkOptions{spec.Network: networkOptions}
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := p.doJSON(ctx, http.MethodPost, "/pods/create", nil, body, &created)
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (p *PodmanRuntime) InspectPod(ctx context.Context, name string) (PodInfo, error) {
+	var podJSON struct {
+		ID    string `json:"Id"`
+		Name  string `json:"Name"`
+		State string `json:"State"`
+	}
+	err := p.doJSON(ctx, http.MethodGet, "/pods/"+url.PathEscape(name)+"/json", nil, nil, &podJSON)
+	if err != nil {
+		return PodInfo{}, err
+	}
+	return PodInfo{ID: podJSON.ID, Name: podJSON.Name, State: podJSON.State}, nil
+}
+
+// RemovePod removes the pod and, being forced, all its containers
+func (p *PodmanRuntime) RemovePod(ctx context.Context, name string) error {
+	return p.doJSON(ctx, http.MethodDelete, "/pods/"+url.PathEscape(name), url.Values{"force": {"true"}}, nil, nil)
+}
+
+func (p *PodmanRuntime) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,389 @@
+package containerruntime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestPodmanRuntime returns a PodmanRuntime talking to a fake libpod API served by handler on a unix socket
+func newTestPodmanRuntime(t *testing.T, handler http.Handler) *PodmanRuntime {
+	t.Helper()
+
+	// the path of a unix socket is limited to about 100 bytes, which the test temporary directories may exceed
+	dir, err := os.MkdirTemp("", "podman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "podman.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return NewPodmanRuntime("unix://" + socketPath)
+}
+
+func TestPodmanPortMappings(t *testing.T) {
+	tests := []struct {
+		name  string
+		ports []PortSpec
+		want  []podmanPortMapping
+	}{
+		{name: "no port"},
+		{
+			name:  "protocols are lowercase",
+			ports: []PortSpec{{HostPort: 8080, ContainerPort: 80, Protocol: "TCP"}, {HostPort: 5353, ContainerPort: 53, Protocol: "UDP"}},
+			want:  []podmanPortMapping{{ContainerPort: 80, HostPort: 8080, Protocol: "tcp"}, {ContainerPort: 53, HostPort: 5353, Protocol: "udp"}},
+		},
+		{
+			name:  "default protocol",
+			ports: []PortSpec{{ContainerPort: 8888}},
+			want:  []podmanPortMapping{{ContainerPort: 8888}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := podmanPortMappings(test.ports)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPodmanStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{status: "configured", want: "created"},
+		{status: "initialized", want: "created"},
+		{status: "running", want: "running"},
+		{status: "paused", want: "paused"},
+		{status: "stopping", want: "exited"},
+		{status: "stopped", want: "exited"},
+		{status: "exited", want: "exited"},
+		{status: "removing", want: "removing"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.status, func(t *testing.T) {
+			if got := podmanStatus(test.status); got != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPodmanFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters map[string][]string
+		want    url.Values
+	}{
+		{name: "no filter", want: url.Values{}},
+		{name: "empty filters", filters: map[string][]string{}, want: url.Values{}},
+		{
+			name:    "name and labels",
+			filters: map[string][]string{"name": {"^pod_"}, "label": {"interlink.eu/pod-uid", "app=web"}},
+			want:    url.Values{"filters": {`{"label":["interlink.eu/pod-uid","app=web"],"name":["^pod_"]}`}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := podmanFilters(test.filters)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPodmanCreate(t *testing.T) {
+	tests := []struct {
+		name string
+		spec ContainerSpec
+		want podmanSpecGenerator
+	}{
+		{
+			name: "minimal",
+			spec: ContainerSpec{Name: "pod_main", Image: "busybox"},
+			want: podmanSpecGenerator{Name: "pod_main", Image: "busybox", Env: map[string]string{}},
+		},
+		{
+			name: "command, environment and mounts",
+			spec: ContainerSpec{
+				Name:       "pod_main",
+				Image:      "busybox",
+				Entrypoint: []string{"/bin/sh", "-c"},
+				Command:    []string{"echo $GREETING"},
+				Env:        []string{"GREETING=hello=world", "EMPTY="},
+				WorkingDir: "/work",
+				Labels:     map[string]string{"interlink.eu/pod-uid": "uid"},
+				Mounts: []MountSpec{
+					{Source: "/data/config", Target: "/etc/config", ReadOnly: true},
+					{Source: "/data/shared", Target: "/shared", Propagation: "shared"},
+				},
+				Devices: []string{"/dev/fuse"},
+				Pod:     "pod",
+			},
+			want: podmanSpecGenerator{
+				Name:       "pod_main",
+				Image:      "busybox",
+				Entrypoint: []string{"/bin/sh", "-c"},
+				Command:    []string{"echo $GREETING"},
+				Env:        map[string]string{"GREETING": "hello=world", "EMPTY": ""},
+				WorkDir:    "/work",
+				Labels:     map[string]string{"interlink.eu/pod-uid": "uid"},
+				Mounts: []podmanMount{
+					{Destination: "/etc/config", Source: "/data/config", Type: "bind", Options: []string{"rbind", "ro"}},
+					{Destination: "/shared", Source: "/data/shared", Type: "bind", Options: []string{"rbind", "rshared"}},
+				},
+				Devices: []podmanDevice{{Path: "/dev/fuse"}},
+				Pod:     "pod",
+			},
+		},
+		{
+			name: "NVIDIA GPUs as CDI devices",
+			spec: ContainerSpec{Name: "pod_gpu", Image: "cuda", Runtime: "nvidia", Env: []string{"NVIDIA_VISIBLE_DEVICES=0,2"}, Pod: "pod"},
+			want: podmanSpecGenerator{
+				Name:    "pod_gpu",
+				Image:   "cuda",
+				Env:     map[string]string{"NVIDIA_VISIBLE_DEVICES": "0,2"},
+				Devices: []podmanDevice{{Path: "nvidia.com/gpu=0"}, {Path: "nvidia.com/gpu=2"}},
+				Pod:     "pod",
+			},
+		},
+		{
+			name: "other OCI runtime",
+			spec: ContainerSpec{Name: "pod_main", Image: "busybox", Runtime: "crun", Pod: "pod"},
+			want: podmanSpecGenerator{Name: "pod_main", Image: "busybox", Env: map[string]string{}, OCIRuntime: "crun", Pod: "pod"},
+		},
+		{
+			name: "ports and network are ignored in a pod",
+			spec: ContainerSpec{Name: "pod_main", Image: "nginx", Ports: []PortSpec{{HostPort: 8080, ContainerPort: 80}}, NetworkMode: "pod_network", Pod: "pod"},
+			want: podmanSpecGenerator{Name: "pod_main", Image: "nginx", Env: map[string]string{}, Pod: "pod"},
+		},
+		{
+			name: "ports and network without a pod",
+			spec: ContainerSpec{Name: "dind", Image: "nginx", Ports: []PortSpec{{HostPort: 8080, ContainerPort: 80, Protocol: "TCP"}}, NetworkMode: "dind_network"},
+			want: podmanSpecGenerator{
+				Name:         "dind",
+				Image:        "nginx",
+				Env:          map[string]string{},
+				PortMappings: []podmanPortMapping{{ContainerPort: 80, HostPort: 8080, Protocol: "tcp"}},
+				NetNS:        &podmanNamespace{NSMode: "bridge"},
+				Networks:     map[string]podmanNetworkOptions{"dind_network": {}},
+			},
+		},
+		{
+			name: "host network",
+			spec: ContainerSpec{Name: "dind", Image: "nginx", NetworkMode: "host"},
+			want: podmanSpecGenerator{Name: "dind", Image: "nginx", Env: map[string]string{}, NetNS: &podmanNamespace{NSMode: "host"}},
+		},
+		{
+			name: "resource limits",
+			spec: ContainerSpec{Name: "pod_main", Image: "busybox", MemoryBytes: 512 * 1024 * 1024, NanoCPUs: 1500000000, Pod: "pod"},
+			want: podmanSpecGenerator{
+				Name:  "pod_main",
+				Image: "busybox",
+				Env:   map[string]string{},
+				Pod:   "pod",
+				ResourceLimits: &podmanResourceLimits{
+					Memory: &struct {
+						Limit int64 `json:"limit"`
+					}{Limit: 512 * 1024 * 1024},
+					CPU: &struct {
+						Quota  int64  `json:"quota"`
+						Period uint64 `json:"period"`
+					}{Quota: 150000, Period: 100000},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got podmanSpecGenerator
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /v4.0.0/libpod/images/{name}/exists", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			mux.HandleFunc("POST /v4.0.0/libpod/containers/create", func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Error(err)
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"Id": "container-id"}`))
+			})
+
+			id, err := newTestPodmanRuntime(t, mux).Create(context.Background(), test.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != "container-id" {
+				t.Fatalf("got ID %s, want container-id", id)
+			}
+			// the specs are compared as sent to the API, where the empty fields are omitted
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(test.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Fatalf("got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestPodmanCreatePullsMissingImage(t *testing.T) {
+	tests := []struct {
+		name         string
+		pullResponse string
+		wantErr      error
+	}{
+		{name: "pulled", pullResponse: `{"stream": "Trying to pull docker.io/library/busybox"}` + "\n" + `{"id": "image-id"}`},
+		{name: "pull error", pullResponse: `{"error": "manifest unknown"}`, wantErr: ErrImagePull},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pulled string
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /v4.0.0/libpod/images/{name}/exists", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message": "no such image"}`))
+			})
+			mux.HandleFunc("POST /v4.0.0/libpod/images/pull", func(w http.ResponseWriter, r *http.Request) {
+				pulled = r.URL.Query().Get("reference")
+				w.Write([]byte(test.pullResponse))
+			})
+			mux.HandleFunc("POST /v4.0.0/libpod/containers/create", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"Id": "container-id"}`))
+			})
+
+			_, err := newTestPodmanRuntime(t, mux).Create(context.Background(), ContainerSpec{Name: "pod_main", Image: "busybox"})
+			if pulled != "busybox" {
+				t.Fatalf("pulled %q, want busybox", pulled)
+			}
+			if test.wantErr == nil && err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPodmanInspect(t *testing.T) {
+	startedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		status       int
+		response     string
+		want         ContainerInfo
+		wantNotFound bool
+		wantErr      bool
+	}{
+		{
+			name:   "running",
+			status: http.StatusOK,
+			response: `{"Id": "id", "Name": "pod_main", "Image": "sha256:abc", "ImageName": "docker.io/library/busybox:latest",
+				"Config": {"Labels": {"interlink.eu/pod-uid": "uid"}},
+				"State": {"Status": "running", "Running": true, "StartedAt": "2024-05-01T10:00:00Z", "FinishedAt": "0001-01-01T00:00:00Z"},
+				"NetworkSettings": {"Networks": {"pod_network": {"IPAddress": "10.88.0.5"}}}}`,
+			want: ContainerInfo{
+				ID:        "id",
+				Name:      "pod_main",
+				Image:     "docker.io/library/busybox:latest",
+				ImageID:   "sha256:abc",
+				Labels:    map[string]string{"interlink.eu/pod-uid": "uid"},
+				IPAddress: "10.88.0.5",
+				State:     ContainerState{Status: "running", Running: true, StartedAt: startedAt},
+			},
+		},
+		{
+			name:   "stopped",
+			status: http.StatusOK,
+			response: `{"Id": "id", "Name": "pod_main", "NetworkSettings": {"IPAddress": "10.88.0.6"},
+				"State": {"Status": "stopped", "ExitCode": 137, "OOMKilled": true, "StartedAt": "2024-05-01T10:00:00Z", "FinishedAt": "2024-05-01T10:00:01Z"}}`,
+			want: ContainerInfo{
+				ID:        "id",
+				Name:      "pod_main",
+				IPAddress: "10.88.0.6",
+				State:     ContainerState{Status: "exited", ExitCode: 137, OOMKilled: true, StartedAt: startedAt, FinishedAt: startedAt.Add(time.Second)},
+			},
+		},
+		{name: "not found", status: http.StatusNotFound, response: `{"message": "no such container"}`, wantNotFound: true, wantErr: true},
+		{name: "server error", status: http.StatusInternalServerError, response: `{"message": "database is locked"}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /v4.0.0/libpod/containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.response))
+			})
+
+			got, err := newTestPodmanRuntime(t, mux).Inspect(context.Background(), "pod_main")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want an error: %t", err, test.wantErr)
+			}
+			if IsNotFound(err) != test.wantNotFound {
+				t.Fatalf("got error %v, want a not found error: %t", err, test.wantNotFound)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPodmanList(t *testing.T) {
+	var query url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v4.0.0/libpod/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`[
+			{"Id": "a", "Names": ["pod_main"], "Image": "busybox", "Labels": {"interlink.eu/pod-uid": "uid"}, "State": "running"},
+			{"Id": "b", "Names": ["pod_init"], "Image": "busybox", "Labels": {"interlink.eu/pod-uid": "uid"}, "State": "stopped"}
+		]`))
+	})
+
+	got, err := newTestPodmanRuntime(t, mux).List(context.Background(), ListOptions{All: true, Labels: map[string]string{"interlink.eu/pod-uid": ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantQuery := url.Values{"all": {"true"}, "filters": {`{"label":["interlink.eu/pod-uid"]}`}}
+	if !reflect.DeepEqual(query, wantQuery) {
+		t.Fatalf("got query %v, want %v", query, wantQuery)
+	}
+	want := []ContainerInfo{
+		{ID: "a", Name: "pod_main", Image: "busybox", Labels: map[string]string{"interlink.eu/pod-uid": "uid"}, State: ContainerState{Status: "running", Running: true}},
+		{ID: "b", Name: "pod_init", Image: "busybox", Labels: map[string]string{"interlink.eu/pod-uid": "uid"}, State: ContainerState{Status: "exited"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,210 @@
+package containerruntime
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned (wrapped) by a ContainerRuntime when the requested container or network does not exist
+var ErrNotFound = errors.New("not found")
+
+// IsNotFound reports whether err has been caused by a missing container or network
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// ErrImagePull is returned (wrapped) by ContainerRuntime.Create when the image of the container cannot be pulled
+var ErrImagePull = errors.New("image pull failed")
+
+// IsImagePull reports whether err has been caused by the failed pull of an image
+func IsImagePull(err error) bool {
+	return errors.Is(err, ErrImagePull)
+}
+
+// ContainerRuntime abstracts the container engine used to run the DIND containers and the containers of a POD.
+// Every method takes typed arguments, so that no command line has to be built or parsed.
+type ContainerRuntime interface {
+	Ping(ctx context.Context) error
+	Create(ctx context.Context, spec ContainerSpec) (string, error)
+	Start(ctx context.Context, id string) error
+	// Stop sends SIGTERM to the container and kills it if it is still running after timeout
+	Stop(ctx context.Context, id string, timeout time.Duration) error
+	Remove(ctx context.Context, id string, force bool) error
+	Rename(ctx context.Context, id string, name string) error
+	Inspect(ctx context.Context, id string) (ContainerInfo, error)
+	List(ctx context.Context, opts ListOptions) ([]ContainerInfo, error)
+	Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error)
+	Exec(ctx context.Context, id string, cmd []string) (ExecResult, error)
+	// ExecAttach runs a command inside the container with its standard streams attached to the caller
+	ExecAttach(ctx context.Context, id string, opts ExecOptions) (ExecSession, error)
+	// Stats returns a sample of the resource usage of the container
+	Stats(ctx context.Context, id string, opts StatsOptions) (ContainerStats, error)
+	CreateNetwork(ctx context.Context, name string) (string, error)
+	RemoveNetwork(ctx context.Context, id string) error
+	ConnectNetwork(ctx context.Context, network string, id string, ip string) error
+	ListNetworks(ctx context.Context, name string) ([]NetworkInfo, error)
+	// Events streams the events of the containers from now on. The stream ends when ctx is done or when it fails, the cause is then sent on the error channel.
+	Events(ctx context.Context) (<-chan ContainerEvent, <-chan error)
+	Close() error
+}
+
+// MountSpec describes a bind mount of a host path into a container
+type MountSpec struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	ReadOnly    bool   `json:"readOnly,omitempty"`
+	Propagation string `json:"propagation,omitempty"`
+}
+
+// PortSpec describes a port of the container published on the host
+type PortSpec struct {
+	HostPort      int32  `json:"hostPort"`
+	ContainerPort int32  `json:"containerPort"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+// ContainerSpec holds everything needed to create a container, independently of the runtime that will run it
+type ContainerSpec struct {
+	Name        string            `json:"name"`
+	Image       string            `json:"image"`
+	Entrypoint  []string          `json:"entrypoint,omitempty"`
+	Command     []string          `json:"command,omitempty"`
+	Env         []string          `json:"env,omitempty"`
+	User        string            `json:"user,omitempty"`
+	WorkingDir  string            `json:"workingDir,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Mounts      []MountSpec       `json:"mounts,omitempty"`
+	Devices     []string          `json:"devices,omitempty"`
+	Ports       []PortSpec        `json:"ports,omitempty"`
+	Privileged  bool              `json:"privileged,omitempty"`
+	CapAdd      []string          `json:"capAdd,omitempty"`
+	Runtime     string            `json:"runtime,omitempty"`
+	NetworkMode string            `json:"networkMode,omitempty"`
+	// Pod is the runtime pod the container joins, ignored by runtimes without pods
+	Pod         string `json:"pod,omitempty"`
+	MemoryBytes int64  `json:"memoryBytes,omitempty"`
+	NanoCPUs    int64  `json:"nanoCPUs,omitempty"`
+}
+
+// ContainerState is the runtime state of a container
+type ContainerState struct {
+	// Status is one of created, running, paused, restarting, removing, exited or dead
+	Status     string
+	Running    bool
+	ExitCode   int
+	OOMKilled  bool
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// ContainerInfo is the result of inspecting or listing a container
+type ContainerInfo struct {
+	ID        string
+	Name      string
+	Image     string
+	ImageID   string
+	Labels    map[string]string
+	IPAddress string
+	State     ContainerState
+}
+
+// ListOptions filters the containers returned by ContainerRuntime.List
+type ListOptions struct {
+	// All includes stopped containers
+	All bool
+	// Name is a regular expression matched against the container names
+	Name string
+	// Labels must all be set on the returned containers, a label with an empty value only has to be set
+	Labels map[string]string
+}
+
+// labelFilter returns the label filter of the Docker and Podman APIs matching the label, or its presence if the value is empty
+func labelFilter(key string, value string) string {
+	if value == "" {
+		return key
+	}
+	return key + "=" + value
+}
+
+// LogOptions selects which part of the container logs is returned
+type LogOptions struct {
+	Timestamps bool
+	Follow     bool
+	// Tail is the number of lines to return from the end of the logs, "all" or empty to return everything
+	Tail  string
+	Since time.Time
+}
+
+// StatsOptions selects what a sample of the resource usage of a container holds
+type StatsOptions struct {
+	// Size asks for the size of the writable layer of the container, which the runtime computes by walking the whole layer
+	Size bool
+}
+
+// ExecResult is the outcome of a command executed inside a container
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// NetworkInfo identifies a network managed by the runtime
+type NetworkInfo struct {
+	ID   string
+	Name string
+}
+
+// ContainerStats is a sample of the resource usage of a container. The counters are cumulative since the start of the container.
+type ContainerStats struct {
+	Time time.Time
+	// CPUUsageNanoSeconds is the CPU time consumed by the container
+	CPUUsageNanoSeconds uint64
+	MemoryUsageBytes    uint64
+	// MemoryWorkingSetBytes is the memory usage without the inactive page cache, which the kernel can reclaim
+	MemoryWorkingSetBytes uint64
+	MemoryRSSBytes        uint64
+	// MemoryLimitBytes is the memory available to the container, zero if unknown
+	MemoryLimitBytes uint64
+	PageFaults       uint64
+	MajorPageFaults  uint64
+	// Networks holds the traffic of the interfaces of the container, empty if the container shares the network of the host
+	Networks []InterfaceStats
+	// RootfsUsedBytes is the size of the writable layer of the container, only set if asked for
+	RootfsUsedBytes uint64
+}
+
+// InterfaceStats is the traffic of a network interface
+type InterfaceStats struct {
+	Name     string
+	RxBytes  uint64
+	RxErrors uint64
+	TxBytes  uint64
+	TxErrors uint64
+}
+
+// ExecOptions describes a command executed by ContainerRuntime.ExecAttach
+type ExecOptions struct {
+	Cmd []string
+	// Stdin attaches the standard input of the command
+	Stdin bool
+	// Tty allocates a terminal to the command, whose output is then entirely sent to its standard output
+	Tty bool
+}
+
+// ExecSession is a command running inside a container, whose standard streams are attached to the caller.
+// Stdout and Stderr must both be drained for the command to make progress.
+type ExecSession interface {
+	// Stdin returns the standard input of the command, nil if it is not attached. Closing it closes the standard input of the command.
+	Stdin() io.WriteCloser
+	Stdout() io.Reader
+	Stderr() io.Reader
+	// Resize changes the size of the terminal of the command
+	Resize(ctx context.Context, height uint, width uint) error
+	// Wait waits for the command to complete and returns its exit code
+	Wait(ctx context.Context) (int, error)
+	// Close detaches from the command
+	Close() error
+}
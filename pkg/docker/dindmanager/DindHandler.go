@@ -3,39 +3,73 @@ package dindmanager
 import (
 	"context"
 	"crypto/rand"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	exec "github.com/alexellis/go-execute/pkg/v1"
 	"github.com/containerd/containerd/log"
 
-	OSexec "os/exec"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
 )
 
+const (
+	// DindSocketLabel is set on every DIND container and holds the host path of the socket of its Docker daemon
+	DindSocketLabel = "interlink.eu/dind-socket"
+
+	dindSocketInContainer = "/run/interlink"
+)
+
+// dindTools are the packages installed in every DIND container. netcat relays the network probes and the port forwards of the POD,
+// since the network of its containers exists only inside the DIND container.
+var dindTools = []string{"net-tools", "iproute2", "netcat-openbsd"}
+
 type DindManagerInterface interface {
 	CleanDindContainers() error
-	BuildDindContainers(nDindContainer int8) error
-	PrintDindList() error
-	GetAvailableDind() (string, error)
-	SetDindUnavailable(dindID string) error
+	BuildDindForPod(podUID string) (string, error)
+	FillPool() error
+	RefillPool()
+	AcquireDind(podUID string) (string, error)
 	RemoveDindFromList(PodUID string) error
-	SetPodUIDToDind(dindID string, podUID string) error
 	GetDindFromPodUID(podUID string) (DindSpecs, error)
-	SetDindAvailable(PodUID string) error
+	CountAvailableDinds() int
+	Stats() DindPoolStats
 }
 
 type DindSpecs struct {
-	DindID        string
-	PodUID        string
-	DindNetworkID string
-	Available     bool
+	DindID        string `json:"dindID"`
+	PodUID        string `json:"podUID"`
+	DindNetworkID string `json:"dindNetworkID"`
+	SocketPath    string `json:"socketPath"`
+	Available     bool   `json:"available"`
+}
+
+// DindPoolStats is the occupation of the pool of DIND containers
+type DindPoolStats struct {
+	Available int
+	InUse     int
+	// BuildFailures is the number of builds of DIND containers that have failed since the start of the sidecar
+	BuildFailures int
 }
 
 type DindManager struct {
 	DindList []DindSpecs
+	Runtime  containerruntime.ContainerRuntime
 	Ctx      context.Context
+	// StorePath is the file in which DindList is persisted, so that the DIND containers survive a restart of the sidecar.
+	// If empty, DindList is kept only in memory.
+	StorePath string
+	// PoolSize is the number of available DIND containers kept ready for the new PODs
+	PoolSize int
+
+	mu sync.Mutex
+	// filling is set while FillPool is building DIND containers
+	filling bool
+	// buildFailures is the number of failed builds of DIND containers
+	buildFailures int
 }
 
 // GenerateUUIDv4 generates a random UUIDv4
@@ -52,43 +86,72 @@ func GenerateUUIDv4() (string, error) {
 	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
 }
 
+// CleanDindContainers restores DindList from the store, adopting the DIND containers that are still up and running.
+// Only the orphaned DIND containers and networks, i.e. the ones that are stopped, unknown to the store or unreachable, are removed.
 func (a *DindManager) CleanDindContainers() error {
 
-	// print the number of DIND containers to be created
 	log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Start cleaning zombie DIND containers"))
 
-	// exec this command docker ps -a --format "{{.Names}}" | grep '_dind$' | wc -l
-	shell := exec.ExecTask{
-		Command: "docker",
-		Args:    []string{"ps", "-a", "--format", "{{.Names}}", "|", "grep", "_dind$", "|", "wc", "-l"},
-		Shell:   true,
+	storedDinds, err := a.loadDindList()
+	if err != nil {
+		log.G(a.Ctx).Error(fmt.Sprintf("\u274c Error loading the DIND list from %s, all DIND containers will be removed: %s", a.StorePath, err))
 	}
-	execReturn, err := shell.Execute()
+
+	dindContainers, err := a.Runtime.List(a.Ctx, containerruntime.ListOptions{All: true, Name: "_dind$"})
 	if err != nil {
 		return err
 	}
 
-	// log the number of zombie DIND containers (remove the \n at the end of the string)
-	log.G(a.Ctx).Info(fmt.Sprintf("\u2705 %s zombie DIND containers found", strings.ReplaceAll(execReturn.Stdout, "\n", "")))
+	var adoptedDinds []DindSpecs
+	zombies := 0
 
-	shell = exec.ExecTask{
-		Command: "docker",
-		Args:    []string{"ps", "-a", "--format", "{{.Names}}", "|", "grep", "_dind$", "|", "xargs", "-I", "{}", "docker", "rm", "-f", "{}"},
-		Shell:   true,
+	for _, dindContainer := range dindContainers {
+		dindSpec, ok := a.adoptDindContainer(dindContainer, storedDinds)
+		if ok {
+			log.G(a.Ctx).Info(fmt.Sprintf("\u2705 DIND container %s adopted, PodUID: %s", dindContainer.Name, dindSpec.PodUID))
+			adoptedDinds = append(adoptedDinds, dindSpec)
+			continue
+		}
+
+		zombies++
+		err = a.Runtime.Remove(a.Ctx, dindContainer.ID, true)
+		if err != nil {
+			return err
+		}
+		if socketPath, ok := dindContainer.Labels[DindSocketLabel]; ok {
+			os.RemoveAll(filepath.Dir(socketPath))
+		}
 	}
-	_, err = shell.Execute()
+
+	log.G(a.Ctx).Info(fmt.Sprintf("\u2705 %d DIND containers adopted, %d zombie DIND containers removed", len(adoptedDinds), zombies))
+
+	dindNetworks, err := a.Runtime.ListNetworks(a.Ctx, "_dind_network$")
 	if err != nil {
 		return err
 	}
 
-	// exec this command  docker network ls --filter name=_dind_network$ --format "{{.ID}}" | xargs -r docker network rm
+	for _, dindNetwork := range dindNetworks {
+		inUse := false
+		for _, dindSpec := range adoptedDinds {
+			if dindSpec.DindNetworkID == dindNetwork.Name || dindSpec.DindNetworkID == dindNetwork.ID {
+				inUse = true
+				break
+			}
+		}
+		if inUse {
+			continue
+		}
 
-	shell = exec.ExecTask{
-		Command: "docker",
-		Args:    []string{"network", "ls", "--filter", "name=_dind_network$", "--format", "{{.ID}}", "|", "xargs", "-r", "docker", "network", "rm"},
-		Shell:   true,
+		err = a.Runtime.RemoveNetwork(a.Ctx, dindNetwork.ID)
+		if err != nil {
+			return err
+		}
 	}
-	_, err = shell.Execute()
+
+	a.mu.Lock()
+	a.DindList = adoptedDinds
+	err = a.saveDindList()
+	a.mu.Unlock()
 	if err != nil {
 		return err
 	}
@@ -98,206 +161,335 @@ func (a *DindManager) CleanDindContainers() error {
 	return nil
 }
 
-func (a *DindManager) BuildDindContainers(nDindContainer int8) error {
+// adoptDindContainer returns the DindSpecs of a DIND container found at startup, if it can be used again.
+// A DIND container is adopted if it is running and its Docker daemon answers. Its specs are taken from the store if present,
+// otherwise they are rebuilt from the container: a DIND container is named after its socket directory until it is assigned to a POD.
+func (a *DindManager) adoptDindContainer(dindContainer containerruntime.ContainerInfo, storedDinds []DindSpecs) (DindSpecs, bool) {
+	socketPath, ok := dindContainer.Labels[DindSocketLabel]
+	if !ok || !dindContainer.State.Running {
+		return DindSpecs{}, false
+	}
 
-	// print the number of DIND containers to be created
-	log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Creating %d DIND containers", nDindContainer))
+	dindUID := filepath.Base(filepath.Dir(socketPath))
+	dindSpec := DindSpecs{
+		DindID:        dindUID + "_dind",
+		DindNetworkID: dindUID + "_dind_network",
+		SocketPath:    socketPath,
+		Available:     true,
+	}
+	if podUID := strings.TrimSuffix(dindContainer.Name, "_dind"); podUID != dindUID {
+		dindSpec.PodUID = podUID
+		dindSpec.Available = false
+	}
 
-	// get the working dir
-	wd, err := os.Getwd()
+	if storedDinds != nil {
+		found := false
+		for _, storedDind := range storedDinds {
+			if storedDind.DindID == dindSpec.DindID {
+				found = true
+				// a DIND container taken from the pool but not yet assigned to a POD is an orphan
+				if !storedDind.Available && storedDind.PodUID == "" {
+					return DindSpecs{}, false
+				}
+				dindSpec = storedDind
+				break
+			}
+		}
+		if !found {
+			return DindSpecs{}, false
+		}
+	}
+
+	pingCtx, cancel := context.WithTimeout(a.Ctx, 5*time.Second)
+	defer cancel()
+	dindRuntime, err := NewDindRuntime(socketPath)
+	if err != nil {
+		return DindSpecs{}, false
+	}
+	defer dindRuntime.Close()
+	if dindRuntime.Ping(pingCtx) != nil {
+		return DindSpecs{}, false
+	}
+
+	return dindSpec, true
+}
+
+// loadDindList reads the DindList persisted in the store. It returns nil if there is no store or it does not exist yet.
+func (a *DindManager) loadDindList() ([]DindSpecs, error) {
+	if a.StorePath == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(a.StorePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var dindList []DindSpecs
+	err = json.Unmarshal(data, &dindList)
+	if err != nil {
+		return nil, err
+	}
+	// an empty pool is stored as null, it must not be mistaken for a missing store
+	if dindList == nil {
+		dindList = []DindSpecs{}
+	}
+	return dindList, nil
+}
+
+// saveDindList persists DindList in the store, it must be called with a.mu held
+func (a *DindManager) saveDindList() error {
+	if a.StorePath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(a.DindList)
 	if err != nil {
 		return err
 	}
 
-	// get the env variable GPUENABLED, if 1 then the DIND container will have GPU support, otherwise it will not
+	err = os.MkdirAll(filepath.Dir(a.StorePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
 
-	gpuEnabled := os.Getenv("GPUENABLED")
-	dindImage := "docker:dind"
-	if gpuEnabled == "1" {
-		dindImage = "ghcr.io/extrality/nvidia-dind"
+	// the list is written to a temporary file first, so that a crash never leaves a truncated store
+	tmpPath := a.StorePath + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
 	}
+	return os.Rename(tmpPath, a.StorePath)
+}
 
-	for i := int8(0); i < nDindContainer; i++ {
+// BuildDindForPod builds a DIND container assigned to the POD right away, so that it cannot be taken by another POD. It is used when the pool is empty.
+func (a *DindManager) BuildDindForPod(podUID string) (string, error) {
+	dindSpec, err := a.buildDindContainer(podUID)
+	if err != nil {
+		return "", err
+	}
+	return dindSpec.DindID, nil
+}
+
+// FillPool builds DIND containers until PoolSize of them are available. Only one fill runs at a time: it returns right away if another one is running,
+// since that one keeps building until the pool is full.
+func (a *DindManager) FillPool() error {
+	a.mu.Lock()
+	if a.filling {
+		a.mu.Unlock()
+		return nil
+	}
+	a.filling = true
+	a.mu.Unlock()
+
+	defer func() {
+		a.mu.Lock()
+		a.filling = false
+		a.mu.Unlock()
+	}()
 
-		// generate a random UID for the DIND container
-		randUID, err := GenerateUUIDv4()
+	for a.CountAvailableDinds() < a.PoolSize {
+		_, err := a.buildDindContainer("")
 		if err != nil {
 			return err
 		}
+	}
 
-		// create the networks
-		shell := exec.ExecTask{
-			Command: "docker",
-			Args:    []string{"network", "create", "--driver", "bridge", randUID + "_dind_network"},
-			Shell:   true,
-		}
-		_, err = shell.Execute()
-
-		log.G(a.Ctx).Info(fmt.Sprintf("\u2705 DIND network %s created", randUID+"_dind_network"))
+	return nil
+}
 
+// RefillPool fills the pool in background, e.g. after a DIND container has been taken by a POD
+func (a *DindManager) RefillPool() {
+	go func() {
+		err := a.FillPool()
 		if err != nil {
-			return err
+			log.G(a.Ctx).Error(fmt.Sprintf("\u274c Error refilling the pool of DIND containers: %s", err))
 		}
+	}()
+}
 
-		dindContainerArgs := []string{"run"}
-		//dindContainerArgs = append(dindContainerArgs, gpuArgsAsArray...)
-		if _, err := os.Stat("/cvmfs"); err == nil {
-			dindContainerArgs = append(dindContainerArgs, "-v", "/cvmfs:/cvmfs")
+// buildDindContainer builds a DIND container and adds it to DindList, available if podUID is empty and assigned to the POD otherwise.
+// If the build fails, everything created for the DIND container is removed.
+func (a *DindManager) buildDindContainer(podUID string) (dindSpec DindSpecs, err error) {
+	var rollback []func()
+	defer func() {
+		if err == nil {
+			return
 		}
-
-		if os.Getenv("FPGAENABLED") == "1" {
-			if _, err := os.Stat("/tools/Xilinx/"); err == nil {
-				dindContainerArgs = append(dindContainerArgs, "-v", "/tools/Xilinx/:/tools/Xilinx/:ro")
-			}
+		a.mu.Lock()
+		a.buildFailures++
+		a.mu.Unlock()
+		for i := len(rollback) - 1; i >= 0; i-- {
+			rollback[i]()
 		}
+	}()
+
+	// get the working dir
+	wd, err := os.Getwd()
+	if err != nil {
+		return DindSpecs{}, err
+	}
 
-		// add the network to the dind container
-		dindContainerArgs = append(dindContainerArgs, "--network", randUID+"_dind_network")
+	// get the env variable GPUENABLED, if 1 then the DIND container will have GPU support, otherwise it will not
 
-		// append also --net vk0
-		//dindContainerArgs = append(dindContainerArgs, "--net", "vk0")
-		dindContainerArgs = append(dindContainerArgs, "--cap-add", "NET_ADMIN")
-		// "--runtime=nvidia" is added to the dind container if the GPUENABLED env variable is set to 1
+	gpuEnabled := os.Getenv("GPUENABLED")
+	dindImage := "docker:dind"
+	if gpuEnabled == "1" {
+		dindImage = "ghcr.io/extrality/nvidia-dind"
+	}
 
-		if gpuEnabled == "1" {
-			dindContainerArgs = append(dindContainerArgs, "--runtime=nvidia")
-		}
-		dindContainerArgs = append(dindContainerArgs, "--privileged", "-v", wd+":/"+wd, "-v", "/home:/home", "-v", "/var/lib/docker/overlay2:/var/lib/docker/overlay2", "-v", "/var/lib/docker/image:/var/lib/docker/image", "-d", "--name", randUID+"_dind", dindImage)
+	// generate a random UID for the DIND container
+	randUID, err := GenerateUUIDv4()
+	if err != nil {
+		return DindSpecs{}, err
+	}
 
-		var dindContainerID string
-		shell = exec.ExecTask{
-			Command: "docker",
-			Args:    dindContainerArgs,
-			Shell:   true,
-		}
+	// create the networks
+	networkID, err := a.Runtime.CreateNetwork(a.Ctx, randUID+"_dind_network")
+	if err != nil {
+		return DindSpecs{}, err
+	}
+	rollback = append(rollback, func() { a.Runtime.RemoveNetwork(a.Ctx, networkID) })
 
-		// log the command to be executed with docker and the args
-		log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Command is %s", shell.Command+" "+strings.Join(shell.Args, " ")))
+	log.G(a.Ctx).Info(fmt.Sprintf("\u2705 DIND network %s created", randUID+"_dind_network"))
 
-		execReturn, err := shell.Execute()
-		if err != nil {
-			log.G(a.Ctx).Error(fmt.Sprintf("\u274c Error creating DIND container %s", randUID+"_dind"))
-			log.G(a.Ctx).Error(fmt.Sprintf("\u274c %s", execReturn.Stderr))
-			return err
-		}
-		dindContainerID = execReturn.Stdout
+	// the Docker daemon of the DIND container also listens on a socket shared with the host, so that the sidecar can reach it through the Engine API
+	socketDir := filepath.Join(DindSocketDir(), randUID)
+	err = os.MkdirAll(socketDir, 0700)
+	if err != nil {
+		return DindSpecs{}, err
+	}
+	rollback = append(rollback, func() { os.RemoveAll(socketDir) })
+	socketPath := filepath.Join(socketDir, "docker.sock")
+
+	containerSpec := containerruntime.ContainerSpec{
+		Name:        randUID + "_dind",
+		Image:       dindImage,
+		Command:     []string{"--host=unix://" + dindSocketInContainer + "/docker.sock"},
+		Labels:      map[string]string{DindSocketLabel: socketPath},
+		NetworkMode: randUID + "_dind_network",
+		CapAdd:      []string{"NET_ADMIN"},
+		Privileged:  true,
+		Mounts: []containerruntime.MountSpec{
+			{Source: socketDir, Target: dindSocketInContainer},
+			{Source: wd, Target: wd},
+			{Source: "/home", Target: "/home"},
+			{Source: "/var/lib/docker/overlay2", Target: "/var/lib/docker/overlay2"},
+			{Source: "/var/lib/docker/image", Target: "/var/lib/docker/image"},
+		},
+	}
 
-		// create a variable of maximum number of retries
-		maxRetries := 20
-		output := []byte{}
+	if _, err := os.Stat("/cvmfs"); err == nil {
+		containerSpec.Mounts = append(containerSpec.Mounts, containerruntime.MountSpec{Source: "/cvmfs", Target: "/cvmfs"})
+	}
 
-		// wait until the dind container is up and running by check that the command docker ps inside of it does not return an error
-		for {
+	if os.Getenv("FPGAENABLED") == "1" {
+		if _, err := os.Stat("/tools/Xilinx/"); err == nil {
+			containerSpec.Mounts = append(containerSpec.Mounts, containerruntime.MountSpec{Source: "/tools/Xilinx/", Target: "/tools/Xilinx/", ReadOnly: true})
+		}
+	}
 
-			if maxRetries == 0 {
-				return fmt.Errorf("DIND container %s not up and running", dindContainerID)
-			}
+	// "--runtime=nvidia" is added to the dind container if the GPUENABLED env variable is set to 1
+	if gpuEnabled == "1" {
+		containerSpec.Runtime = "nvidia"
+	}
 
-			cmd := OSexec.Command("docker", "logs", randUID+"_dind")
-			output, err = cmd.CombinedOutput()
+	dindContainerID, err := a.Runtime.Create(a.Ctx, containerSpec)
+	if err != nil {
+		log.G(a.Ctx).Error(fmt.Sprintf("\u274c Error creating DIND container %s", randUID+"_dind"))
+		log.G(a.Ctx).Error(fmt.Sprintf("\u274c %s", err))
+		return DindSpecs{}, err
+	}
+	// the container is removed before its network
+	rollback = append(rollback, func() { a.Runtime.Remove(a.Ctx, dindContainerID, true) })
 
-			if err != nil {
-				time.Sleep(1 * time.Second)
-			}
+	err = a.Runtime.Start(a.Ctx, dindContainerID)
+	if err != nil {
+		log.G(a.Ctx).Error(fmt.Sprintf("\u274c Error starting DIND container %s", randUID+"_dind"))
+		return DindSpecs{}, err
+	}
 
-			if strings.Contains(string(output), "API listen on /var/run/docker.sock") {
-				break
-			} else {
-				time.Sleep(1 * time.Second)
-			}
+	err = waitForDind(a.Ctx, socketPath)
+	if err != nil {
+		return DindSpecs{}, fmt.Errorf("DIND container %s not up and running: %v", dindContainerID, err)
+	}
 
-			maxRetries -= 1
+	log.G(a.Ctx).Info(fmt.Sprintf("\u2705 DIND container %s is up and running", dindContainerID))
 
-		}
+	// docker:dind is based on Alpine, while the NVIDIA image is based on Ubuntu
+	installScript := "if command -v apk >/dev/null; then apk add --no-cache " + strings.Join(dindTools, " ") +
+		"; else apt-get update && apt-get install -y " + strings.Join(dindTools, " ") + "; fi"
+	execResult, err := a.Runtime.Exec(a.Ctx, dindContainerID, []string{"/bin/sh", "-c", installScript})
+	if err != nil {
+		return DindSpecs{}, err
+	}
+	if execResult.ExitCode != 0 {
+		return DindSpecs{}, fmt.Errorf("unable to install %s in DIND container %s: %s", strings.Join(dindTools, ", "), dindContainerID, execResult.Stderr)
+	}
 
-		log.G(a.Ctx).Info(fmt.Sprintf("\u2705 DIND container %s is up and running", dindContainerID))
+	log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Installed the tools of DIND container %s", dindContainerID))
 
-		shell = exec.ExecTask{
-			Command: "docker",
-			Args:    []string{"exec", randUID + "_dind", "apt-get", "update"},
-			Shell:   true,
-		}
-		_, err = shell.Execute()
-		if err != nil {
-			return err
-		}
+	dindSpec = DindSpecs{DindID: randUID + "_dind", PodUID: podUID, DindNetworkID: randUID + "_dind_network", SocketPath: socketPath, Available: podUID == ""}
 
-		log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Executed apt-get update"))
+	// add the dind container to the list of DIND containers
+	a.mu.Lock()
+	a.DindList = append(a.DindList, dindSpec)
+	saveErr := a.saveDindList()
+	a.mu.Unlock()
+	if saveErr != nil {
+		log.G(a.Ctx).Error(fmt.Sprintf("\u274c Error saving the DIND list: %s", saveErr))
+	}
 
-		shell = exec.ExecTask{
-			Command: "docker",
-			Args:    []string{"exec", randUID + "_dind", "apt-get", "install", "-y", "net-tools"},
-			Shell:   true,
-		}
-		_, err = shell.Execute()
-		if err != nil {
-			return err
-		}
+	return dindSpec, nil
+}
 
-		log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Installed net-tools"))
+// waitForDind waits until the Docker daemon of a DIND container answers on its socket
+func waitForDind(ctx context.Context, socketPath string) error {
+	dindRuntime, err := NewDindRuntime(socketPath)
+	if err != nil {
+		return err
+	}
+	defer dindRuntime.Close()
 
-		shell = exec.ExecTask{
-			Command: "docker",
-			Args:    []string{"exec", randUID + "_dind", "apt-get", "install", "-y", "iproute2"},
-			Shell:   true,
+	// create a variable of maximum number of retries
+	maxRetries := 20
+
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, time.Second)
+		err = dindRuntime.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
 		}
-		_, err = shell.Execute()
-		if err != nil {
+
+		maxRetries -= 1
+		if maxRetries == 0 {
 			return err
 		}
 
-		// log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Installed iproute2"))
-
-		// // run docker network connet vk0 container-id
-		// shell = exec.ExecTask{
-		// 	Command: "docker",
-		// 	Args:    []string{"network", "connect", "vk0", randUID + "_dind"},
-		// 	Shell:   true,
-		// }
-		// _, err = shell.Execute()
-		// if err != nil {
-		// 	return err
-		// }
-
-		// shell = exec.ExecTask{
-		// 	Command: "docker",
-		// 	Args:    []string{"exec", randUID + "_dind", "docker", "network", "create", "--subnet", "10.244.12.0/24", "-o", "com.docker.network.bridge.name=vk0", "vk0", "-o", "com.docker.network.driver.mtu=1500"},
-		// 	Shell:   true,
-		// }
-		// _, err = shell.Execute()
-		// if err != nil {
-		// 	return err
-		// }
-
-		// log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Created network vk0"))
-
-		// shell = exec.ExecTask{
-		// 	Command: "docker",
-		// 	Args:    []string{"exec", randUID + "_dind", "ip", "link", "set", "to-cluster", "master", "vk0"},
-		// 	Shell:   true,
-		// }
-		// _, err = shell.Execute()
-		// if err != nil {
-		// 	return err
-		// }
-
-		// log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Set vk0 as master"))
-
-		// add the dind container to the list of DIND containers
-		a.DindList = append(a.DindList, DindSpecs{DindID: randUID + "_dind", PodUID: "", DindNetworkID: randUID + "_dind_network", Available: true})
+		time.Sleep(1 * time.Second)
 	}
+}
 
-	return nil
+// NewDindRuntime returns a runtime connected to the Docker daemon running inside a DIND container
+func NewDindRuntime(socketPath string) (containerruntime.ContainerRuntime, error) {
+	return containerruntime.NewDockerRuntime("unix://" + socketPath)
 }
 
-func (a *DindManager) PrintDindList() error {
-	for _, dindSpec := range a.DindList {
-		log.G(a.Ctx).Info(fmt.Sprintf("DindID: %s, PodUID: %s, DindNetworkID: %s, Available: %t", dindSpec.DindID, dindSpec.PodUID, dindSpec.DindNetworkID, dindSpec.Available))
+// DindSocketDir returns the host directory holding the Docker sockets of the DIND containers. It can be set with the DINDSOCKETDIR env variable.
+func DindSocketDir() string {
+	if dir := os.Getenv("DINDSOCKETDIR"); dir != "" {
+		return dir
 	}
-	return nil
+	return filepath.Join(os.TempDir(), "interlink-dind")
 }
 
 func (a *DindManager) GetDindFromPodUID(podUID string) (DindSpecs, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	for _, dindSpec := range a.DindList {
 		if dindSpec.PodUID == podUID {
 			return dindSpec, nil
@@ -306,50 +498,59 @@ func (a *DindManager) GetDindFromPodUID(podUID string) (DindSpecs, error) {
 	return DindSpecs{}, fmt.Errorf("DIND container with PodUID %s not found", podUID)
 }
 
-func (a *DindManager) GetAvailableDind() (string, error) {
-	for _, dindSpec := range a.DindList {
-		if dindSpec.Available {
-			return dindSpec.DindID, nil
-		}
-	}
-	return "", fmt.Errorf("No available DIND container")
-}
+// AcquireDind takes an available DIND container and assigns it to the POD in a single step,
+// so that PODs created concurrently never share the same DIND container
+func (a *DindManager) AcquireDind(podUID string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-func (a *DindManager) SetDindUnavailable(dindID string) error {
 	for i, dindSpec := range a.DindList {
-		if dindSpec.DindID == dindID {
+		if dindSpec.Available {
 			a.DindList[i].Available = false
-			return nil
+			a.DindList[i].PodUID = podUID
+			return dindSpec.DindID, a.saveDindList()
 		}
 	}
-	return fmt.Errorf("DIND container %s not found", dindID)
+	return "", fmt.Errorf("No available DIND container")
 }
 
-func (a *DindManager) SetDindAvailable(PodUI string) error {
-	for i, dindSpec := range a.DindList {
-		if dindSpec.PodUID == PodUI {
-			a.DindList[i].Available = true
-			return nil
+func (a *DindManager) CountAvailableDinds() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	count := 0
+	for _, dindSpec := range a.DindList {
+		if dindSpec.Available {
+			count++
 		}
 	}
-	return fmt.Errorf("DIND container %s not found", PodUI)
+	return count
 }
 
-func (a *DindManager) SetPodUIDToDind(dindID string, podUID string) error {
-	for i, dindSpec := range a.DindList {
-		if dindSpec.DindID == dindID {
-			a.DindList[i].PodUID = podUID
-			return nil
+// Stats returns the number of DIND containers available and assigned to PODs, and the number of failed builds
+func (a *DindManager) Stats() DindPoolStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := DindPoolStats{BuildFailures: a.buildFailures}
+	for _, dindSpec := range a.DindList {
+		if dindSpec.Available {
+			stats.Available++
+		} else {
+			stats.InUse++
 		}
 	}
-	return fmt.Errorf("DIND container %s not found", dindID)
+	return stats
 }
 
 func (a *DindManager) RemoveDindFromList(PodUID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	for i, dindSpec := range a.DindList {
 		if dindSpec.PodUID == PodUID {
 			a.DindList = append(a.DindList[:i], a.DindList[i+1:]...)
-			return nil
+			return a.saveDindList()
 		}
 	}
 	return fmt.Errorf("DIND container with PodUID %s not found", PodUID)
@@ -0,0 +1,243 @@
+package dindmanager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+)
+
+// serveDockerPing starts a fake Docker daemon answering the pings on a socket under dir/dindUID, and returns the path of the socket
+func serveDockerPing(t *testing.T, dir string, dindUID string) string {
+	t.Helper()
+
+	socketDir := filepath.Join(dir, dindUID)
+	if err := os.MkdirAll(socketDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	socketPath := filepath.Join(socketDir, "docker.sock")
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", "1.43")
+		w.Write([]byte("OK"))
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return socketPath
+}
+
+func TestLoadDindList(t *testing.T) {
+	dir := t.TempDir()
+
+	manager := &DindManager{Ctx: context.Background()}
+	dindList, err := manager.loadDindList()
+	if err != nil || dindList != nil {
+		t.Fatalf("without store: got %v, %v, want nil, nil", dindList, err)
+	}
+
+	manager.StorePath = filepath.Join(dir, "missing", "dind_registry.json")
+	dindList, err = manager.loadDindList()
+	if err != nil || dindList != nil {
+		t.Fatalf("missing store: got %v, %v, want nil, nil", dindList, err)
+	}
+
+	manager.StorePath = filepath.Join(dir, "store", "dind_registry.json")
+	manager.DindList = []DindSpecs{
+		{DindID: "a_dind", DindNetworkID: "a_dind_network", SocketPath: "/run/a/docker.sock", Available: true},
+		{DindID: "b_dind", PodUID: "pod-b", DindNetworkID: "b_dind_network", SocketPath: "/run/b/docker.sock"},
+	}
+	if err := manager.saveDindList(); err != nil {
+		t.Fatal(err)
+	}
+	dindList, err = manager.loadDindList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dindList, manager.DindList) {
+		t.Fatalf("got %+v, want %+v", dindList, manager.DindList)
+	}
+
+	manager.DindList = nil
+	if err := manager.saveDindList(); err != nil {
+		t.Fatal(err)
+	}
+	dindList, err = manager.loadDindList()
+	if err != nil || dindList == nil || len(dindList) != 0 {
+		t.Fatalf("empty store: got %v, %v, want an empty list", dindList, err)
+	}
+
+	if err := os.WriteFile(manager.StorePath, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.loadDindList(); err == nil {
+		t.Fatal("corrupted store: got no error")
+	}
+}
+
+func TestAdoptDindContainer(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := serveDockerPing(t, dir, "dind-uid")
+	deadSocketPath := filepath.Join(dir, "dead-uid", "docker.sock")
+
+	running := containerruntime.ContainerState{Status: "running", Running: true}
+	available := DindSpecs{DindID: "dind-uid_dind", DindNetworkID: "dind-uid_dind_network", SocketPath: socketPath, Available: true}
+	assigned := DindSpecs{DindID: "dind-uid_dind", PodUID: "pod-uid", DindNetworkID: "dind-uid_dind_network", SocketPath: socketPath}
+
+	tests := []struct {
+		name        string
+		container   containerruntime.ContainerInfo
+		storedDinds []DindSpecs
+		want        DindSpecs
+		wantOK      bool
+	}{
+		{
+			name:      "no socket label",
+			container: containerruntime.ContainerInfo{Name: "dind-uid_dind", State: running},
+		},
+		{
+			name:      "stopped",
+			container: containerruntime.ContainerInfo{Name: "dind-uid_dind", Labels: map[string]string{DindSocketLabel: socketPath}},
+		},
+		{
+			name:      "daemon not answering",
+			container: containerruntime.ContainerInfo{Name: "dead-uid_dind", Labels: map[string]string{DindSocketLabel: deadSocketPath}, State: running},
+		},
+		{
+			name:      "available without store",
+			container: containerruntime.ContainerInfo{Name: "dind-uid_dind", Labels: map[string]string{DindSocketLabel: socketPath}, State: running},
+			want:      available,
+			wantOK:    true,
+		},
+		{
+			name:      "assigned without store",
+			container: containerruntime.ContainerInfo{Name: "pod-uid_dind", Labels: map[string]string{DindSocketLabel: socketPath}, State: running},
+			want:      assigned,
+			wantOK:    true,
+		},
+		{
+			name:        "missing from the store",
+			container:   containerruntime.ContainerInfo{Name: "dind-uid_dind", Labels: map[string]string{DindSocketLabel: socketPath}, State: running},
+			storedDinds: []DindSpecs{{DindID: "other_dind", Available: true}},
+		},
+		{
+			name:        "taken but not assigned",
+			container:   containerruntime.ContainerInfo{Name: "dind-uid_dind", Labels: map[string]string{DindSocketLabel: socketPath}, State: running},
+			storedDinds: []DindSpecs{{DindID: "dind-uid_dind", DindNetworkID: "dind-uid_dind_network", SocketPath: socketPath}},
+		},
+		{
+			name:        "assigned in the store",
+			container:   containerruntime.ContainerInfo{Name: "pod-uid_dind", Labels: map[string]string{DindSocketLabel: socketPath}, State: running},
+			storedDinds: []DindSpecs{{DindID: "other_dind", Available: true}, assigned},
+			want:        assigned,
+			wantOK:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := &DindManager{Ctx: context.Background()}
+			got, ok := manager.adoptDindContainer(test.container, test.storedDinds)
+			if ok != test.wantOK || got != test.want {
+				t.Fatalf("got %+v, %t, want %+v, %t", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+// fakeRuntime records the networks and containers created and removed by the DindManager, the methods it does not implement panic
+type fakeRuntime struct {
+	containerruntime.ContainerRuntime
+
+	createErr error
+	startErr  error
+
+	networks   []string
+	containers []string
+}
+
+func (f *fakeRuntime) CreateNetwork(ctx context.Context, name string) (string, error) {
+	f.networks = append(f.networks, name)
+	return name, nil
+}
+
+func (f *fakeRuntime) RemoveNetwork(ctx context.Context, id string) error {
+	for i, network := range f.networks {
+		if network == id {
+			f.networks = append(f.networks[:i], f.networks[i+1:]...)
+			return nil
+		}
+	}
+	return containerruntime.ErrNotFound
+}
+
+func (f *fakeRuntime) Create(ctx context.Context, spec containerruntime.ContainerSpec) (string, error) {
+	if f.createErr != nil {
+		return "", f.createErr
+	}
+	f.containers = append(f.containers, spec.Name)
+	return spec.Name, nil
+}
+
+func (f *fakeRuntime) Start(ctx context.Context, id string) error {
+	return f.startErr
+}
+
+func (f *fakeRuntime) Remove(ctx context.Context, id string, force bool) error {
+	for i, container := range f.containers {
+		if container == id {
+			f.containers = append(f.containers[:i], f.containers[i+1:]...)
+			return nil
+		}
+	}
+	return containerruntime.ErrNotFound
+}
+
+func TestBuildDindContainerRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		runtime *fakeRuntime
+	}{
+		{name: "create fails", runtime: &fakeRuntime{createErr: errors.New("no such image")}},
+		{name: "start fails", runtime: &fakeRuntime{startErr: errors.New("privileged containers not allowed")}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			socketDir := t.TempDir()
+			t.Setenv("DINDSOCKETDIR", socketDir)
+
+			manager := &DindManager{Runtime: test.runtime, Ctx: context.Background(), PoolSize: 2}
+			if err := manager.FillPool(); err == nil {
+				t.Fatal("got no error")
+			}
+
+			if len(test.runtime.networks) != 0 || len(test.runtime.containers) != 0 {
+				t.Fatalf("leaked networks %v and containers %v", test.runtime.networks, test.runtime.containers)
+			}
+			if entries, _ := os.ReadDir(socketDir); len(entries) != 0 {
+				t.Fatalf("leaked socket directories %v", entries)
+			}
+			if stats := manager.Stats(); stats.Available != 0 || stats.InUse != 0 || stats.BuildFailures != 1 {
+				t.Fatalf("got %+v, want a single build failure", stats)
+			}
+			if manager.filling {
+				t.Fatal("the pool is still marked as filling")
+			}
+
+			if _, err := manager.BuildDindForPod("pod-uid"); err == nil {
+				t.Fatal("BuildDindForPod: got no error")
+			}
+		})
+	}
+}
@@ -2,9 +2,7 @@ package fpgastrategies
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"regexp"
 	"sort"
@@ -15,6 +13,8 @@ import (
 	"sync"
 
 	"github.com/containerd/containerd/log"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/acceleratorledger"
 )
 
 type FPGASpecs struct {
@@ -34,6 +34,13 @@ type FPGAManager struct {
 	FPGASpecsMutex sync.Mutex
 	Vendor         string
 	Ctx            context.Context
+	// Ledger persists the FPGA allocations, it is written on every assignment and release
+	Ledger acceleratorledger.Ledger
+	// IsContainerLive is used by Check to release the FPGAs assigned to the containers of deleted PODs
+	IsContainerLive acceleratorledger.LivenessCheck
+
+	// ledgerSpecs are the allocations loaded from the Ledger at Init, applied to the FPGAs found by Discover
+	ledgerSpecs []FPGASpecs
 }
 
 type FPGAManagerInterface interface {
@@ -51,6 +58,17 @@ type FPGAManagerInterface interface {
 
 func (a *FPGAManager) Init() error {
 
+	if a.Ledger.Path == "" {
+		a.Ledger.Path = "fpga_specs.json"
+	}
+
+	found, err := a.Ledger.Load(&a.ledgerSpecs)
+	if err != nil {
+		log.G(a.Ctx).Error(fmt.Sprintf("\u274C Unable to load the FPGA allocations from %s: %v", a.Ledger.Path, err))
+	} else if found {
+		log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Loaded the FPGA allocations from %s", a.Ledger.Path))
+	}
+
 	// Check if the Xilinx setup.sh file exists
 	if _, err := os.Stat("/opt/xilinx/xrt/setup.sh"); os.IsNotExist(err) {
 		return fmt.Errorf("/opt/xilinx/xrt/setup.sh does not exist: %v", err)
@@ -69,7 +87,7 @@ func (a *FPGAManager) Init() error {
 		Shell:   true,
 	}
 
-	_, err := shell.Execute()
+	_, err = shell.Execute()
 	if err != nil {
 		return fmt.Errorf("Error running source setup.sh command: %v", err)
 	}
@@ -171,6 +189,14 @@ func (a *FPGAManager) Discover() error {
 							DeviceToMount: "/dev/dri/renderD" + deviceID,
 							Available:     true, // Assuming it's available for now, can update based on further output parsing
 						}
+
+						// restore the allocation found in the ledger, Check will release it if the container does not exist anymore
+						for _, ledgerSpec := range a.ledgerSpecs {
+							if ledgerSpec.LogicUUID == logicUUID && !ledgerSpec.Available {
+								spec.ContainerID = ledgerSpec.ContainerID
+								spec.Available = false
+							}
+						}
 						a.FPGASpecsList = append(a.FPGASpecsList, spec)
 					}
 				}
@@ -190,8 +216,47 @@ func (a *FPGAManager) Discover() error {
 	return nil
 }
 
+// Check reconciles the FPGA allocations restored from the ledger with the live PODs
 func (a *FPGAManager) Check() error {
-	return nil
+
+	a.FPGASpecsMutex.Lock()
+	defer a.FPGASpecsMutex.Unlock()
+
+	a.releaseDeadAllocations()
+
+	for _, fpgaSpec := range a.FPGASpecsList {
+		if !fpgaSpec.Available {
+			log.G(a.Ctx).Info(fmt.Sprintf("\u274C FPGA with UUID %s is not available. It is in use by container %s", fpgaSpec.LogicUUID, fpgaSpec.ContainerID))
+		} else {
+			log.G(a.Ctx).Info(fmt.Sprintf("\u2705 FPGA with UUID %s is available", fpgaSpec.LogicUUID))
+		}
+	}
+
+	return a.Ledger.Save(a.FPGASpecsList)
+}
+
+// releaseDeadAllocations releases the FPGAs assigned to the containers whose POD does not exist anymore, it must be called with FPGASpecsMutex held
+func (a *FPGAManager) releaseDeadAllocations() {
+	if a.IsContainerLive == nil {
+		return
+	}
+
+	for i := range a.FPGASpecsList {
+		if a.FPGASpecsList[i].ContainerID == "" {
+			continue
+		}
+		live, err := a.IsContainerLive(a.FPGASpecsList[i].ContainerID)
+		if err != nil {
+			// in doubt, the FPGA is kept assigned
+			log.G(a.Ctx).Error(fmt.Sprintf("\u274C Unable to check container %s: %v", a.FPGASpecsList[i].ContainerID, err))
+			continue
+		}
+		if !live {
+			log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Releasing FPGA with UUID %s, its container %s does not exist anymore", a.FPGASpecsList[i].LogicUUID, a.FPGASpecsList[i].ContainerID))
+			a.FPGASpecsList[i].ContainerID = ""
+			a.FPGASpecsList[i].Available = true
+		}
+	}
 }
 
 func (a *FPGAManager) Shutdown() error {
@@ -199,11 +264,23 @@ func (a *FPGAManager) Shutdown() error {
 	return nil
 }
 
+// GetFPGASpecsList returns a copy of the FPGASpecsList, which can be read while the FPGAs are assigned and released
 func (a *FPGAManager) GetFPGASpecsList() []FPGASpecs {
-	return a.FPGASpecsList
+	a.FPGASpecsMutex.Lock()
+	defer a.FPGASpecsMutex.Unlock()
+
+	return append([]FPGASpecs{}, a.FPGASpecsList...)
 }
 
 func (a *FPGAManager) Assign(UUID string, containerID string) error {
+	a.FPGASpecsMutex.Lock()
+	defer a.FPGASpecsMutex.Unlock()
+
+	return a.assign(UUID, containerID)
+}
+
+// assign must be called with FPGASpecsMutex held
+func (a *FPGAManager) assign(UUID string, containerID string) error {
 
 	for i := range a.FPGASpecsList {
 		if a.FPGASpecsList[i].LogicUUID == UUID {
@@ -246,7 +323,7 @@ func (a *FPGAManager) Release(containerID string) error {
 		}
 	}
 
-	return nil
+	return a.Ledger.Save(a.FPGASpecsList)
 }
 
 /* func (a *FPGAManager) GetAvailableFPGAs(numFPGAs int) ([]FPGASpecs, error) {
@@ -304,7 +381,16 @@ func (a *FPGAManager) Release(containerID string) error {
 	return nil, fmt.Errorf("Not enough available FPGAs. Requested: %d, Available: %d", numFPGAs, len(availableFPGAs))
 } */
 
+// GetAvailableFPGAs returns numFPGAs available FPGAs without assigning them, e.g. to plan the creation of a POD
 func (a *FPGAManager) GetAvailableFPGAs(numFPGAs int) ([]FPGASpecs, error) {
+	a.FPGASpecsMutex.Lock()
+	defer a.FPGASpecsMutex.Unlock()
+
+	return a.getAvailableFPGAs(numFPGAs)
+}
+
+// getAvailableFPGAs must be called with FPGASpecsMutex held, since it may sort FPGASpecsList
+func (a *FPGAManager) getAvailableFPGAs(numFPGAs int) ([]FPGASpecs, error) {
 
 	var availableFPGAs []FPGASpecs
 
@@ -369,35 +455,30 @@ func (a *FPGAManager) GetAndAssignAvailableFPGAs(numFPGAs int, containerID strin
 	a.FPGASpecsMutex.Lock()
 	defer a.FPGASpecsMutex.Unlock()
 
-	fpgaSpecs, err := a.GetAvailableFPGAs(numFPGAs)
+	fpgaSpecs, err := a.getAvailableFPGAs(numFPGAs)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, fpgaSpec := range fpgaSpecs {
-		err = a.Assign(fpgaSpec.LogicUUID, containerID)
+		err = a.assign(fpgaSpec.LogicUUID, containerID)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	err = a.Ledger.Save(a.FPGASpecsList)
+	if err != nil {
+		return nil, err
+	}
+
 	return fpgaSpecs, nil
 }
 
-// dump the FPGASpecsList into a JSON file
+// dump the FPGASpecsList into the JSON file of the ledger
 func (a *FPGAManager) Dump() error {
+	a.FPGASpecsMutex.Lock()
+	defer a.FPGASpecsMutex.Unlock()
 
-	// Convert the array to JSON format
-	jsonData, err := json.MarshalIndent(a.FPGASpecsList, "", "  ")
-	if err != nil {
-		return fmt.Errorf("Error marshalling JSON: %v", err)
-	}
-
-	// Write JSON data to a file
-	err = ioutil.WriteFile("fpga_specs.json", jsonData, 0644)
-	if err != nil {
-		return fmt.Errorf("Error writing to file: %v", err)
-	}
-
-	return nil
+	return a.Ledger.Save(a.FPGASpecsList)
 }
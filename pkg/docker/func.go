@@ -7,12 +7,11 @@ import (
 	"path/filepath"
 	"strings"
 
-	exec2 "github.com/alexellis/go-execute/pkg/v1"
 	"github.com/containerd/containerd/log"
 	v1 "k8s.io/api/core/v1"
 
 	commonIL "github.com/intertwin-eu/interlink-docker-plugin/pkg/common"
-	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/dindmanager"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
 	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/fpgastrategies"
 	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/gpustrategies"
 )
@@ -21,11 +20,23 @@ type SidecarHandler struct {
 	Config      commonIL.InterLinkConfig
 	Ctx         context.Context
 	GpuManager  gpustrategies.GPUManagerInterface
-	DindManager dindmanager.DindManagerInterface
+	Sandboxes   SandboxManager
 	FPGAManager fpgastrategies.FPGAManagerInterface
+	// Supervisors run and restart the containers of the PODs
+	Supervisors *PodSupervisors
+	// CreateQueue creates the PODs of the create requests in background
+	CreateQueue *CreateQueue
+	// StatusCache holds the state of the containers of the PODs, answering the status requests
+	StatusCache *StatusCache
+	// Logs archives the logs of the containers of the PODs, so that they outlive their sandboxes
+	Logs *LogArchive
+	// Metrics measures the requests, the creations of the PODs and the state of the sidecar
+	Metrics *Metrics
+	// Usage keeps the last usage of the containers, from which the stats requests compute the rate of their CPU usage
+	Usage *UsageSamples
 }
 
-func parseContainerCommandAndReturnArgs(Ctx context.Context, config commonIL.InterLinkConfig, podUID string, podNamespace string, container v1.Container) ([]string, []string, []string, error) {
+func parseContainerCommandAndReturnArgs(Ctx context.Context, config commonIL.InterLinkConfig, podUID string, podNamespace string, container v1.Container) ([]containerruntime.MountSpec, []string, []string, error) {
 
 	dirPath := config.DataRootFolder + podNamespace + "-" + podUID
 	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
@@ -38,7 +49,7 @@ func parseContainerCommandAndReturnArgs(Ctx context.Context, config commonIL.Int
 	}
 
 	if container.Command == nil {
-		return []string{}, container.Command, container.Args, nil
+		return nil, container.Command, container.Args, nil
 	}
 
 	prefileName := container.Name + "_" + podUID + "_" + podNamespace
@@ -59,7 +70,7 @@ func parseContainerCommandAndReturnArgs(Ctx context.Context, config commonIL.Int
 				log.G(Ctx).Error(err)
 				return nil, nil, nil, err
 			}
-			return []string{"-v " + fileNamePath + ":/" + fileName}, []string{"/bin/sh" + " /" + fileName}, []string{}, nil
+			return []containerruntime.MountSpec{{Source: fileNamePath, Target: "/" + fileName}}, []string{"/bin/sh", "/" + fileName}, []string{}, nil
 		}
 
 		argsFileName := container.Name + "_args"
@@ -78,22 +89,22 @@ func parseContainerCommandAndReturnArgs(Ctx context.Context, config commonIL.Int
 			return nil, nil, nil, err
 		}
 
-		return []string{"-v " + argsFileNamePath + ":/" + argsFileName, "-v " + fullFileNamePath + ":/" + fileName}, []string{"/bin/sh" + " /" + fileName}, []string{}, nil
+		return []containerruntime.MountSpec{{Source: argsFileNamePath, Target: "/" + argsFileName}, {Source: fullFileNamePath, Target: "/" + fileName}}, []string{"/bin/sh", "/" + fileName}, []string{}, nil
 
 	} else {
-		return []string{}, container.Command, container.Args, nil
+		return nil, container.Command, container.Args, nil
 	}
 }
 
-func prepareMounts(Ctx context.Context, config commonIL.InterLinkConfig, data commonIL.RetrievedPodData, container v1.Container) (string, error) {
-	mountedData := ""
+func prepareMounts(Ctx context.Context, config commonIL.InterLinkConfig, data commonIL.RetrievedPodData, container v1.Container) ([]containerruntime.MountSpec, error) {
+	var mountedData []containerruntime.MountSpec
 
 	podUID := string(data.Pod.UID)
 	podNamespace := string(data.Pod.UID)
 
 	err := os.MkdirAll(config.DataRootFolder+data.Pod.Namespace+"-"+podUID, os.ModePerm)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	allContainers := append(data.Containers, data.InitContainers...)
@@ -110,11 +121,9 @@ func prepareMounts(Ctx context.Context, config commonIL.InterLinkConfig, data co
 			if containerName == podNamespace+"-"+podUID+"-"+cont.Name {
 				paths, err := mountData(Ctx, config, data.Pod, cfgMap, container)
 				if err != nil {
-					return "", errors.New("Error mounting ConfigMap " + cfgMap.Name)
-				}
-				for _, path := range paths {
-					mountedData += "-v " + path + " "
+					return nil, errors.New("Error mounting ConfigMap " + cfgMap.Name)
 				}
+				mountedData = append(mountedData, paths...)
 			}
 		}
 
@@ -122,11 +131,9 @@ func prepareMounts(Ctx context.Context, config commonIL.InterLinkConfig, data co
 			if containerName == podNamespace+"-"+podUID+"-"+cont.Name {
 				paths, err := mountData(Ctx, config, data.Pod, secret, container)
 				if err != nil {
-					return "", errors.New("Error mounting Secret " + secret.Name)
-				}
-				for _, path := range paths {
-					mountedData += "-v " + path + " "
+					return nil, errors.New("Error mounting Secret " + secret.Name)
 				}
+				mountedData = append(mountedData, paths...)
 			}
 		}
 
@@ -135,22 +142,17 @@ func prepareMounts(Ctx context.Context, config commonIL.InterLinkConfig, data co
 				paths, err := mountData(Ctx, config, data.Pod, emptyDir, container)
 				if err != nil {
 					log.G(Ctx).Error("Error mounting EmptyDir " + emptyDir)
-					return "", errors.New("Error mounting EmptyDir " + emptyDir)
-				}
-				for _, path := range paths {
-					mountedData += "-v " + path + " "
+					return nil, errors.New("Error mounting EmptyDir " + emptyDir)
 				}
+				mountedData = append(mountedData, paths...)
 			}
 		}
 	}
 
-	if last := len(mountedData) - 1; last >= 0 && mountedData[last] == ',' {
-		mountedData = mountedData[:last]
-	}
 	return mountedData, nil
 }
 
-func mountData(Ctx context.Context, config commonIL.InterLinkConfig, pod v1.Pod, data interface{}, container v1.Container) ([]string, error) {
+func mountData(Ctx context.Context, config commonIL.InterLinkConfig, pod v1.Pod, data interface{}, container v1.Container) ([]containerruntime.MountSpec, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		log.G(Ctx).Error(err)
@@ -168,7 +170,7 @@ func mountData(Ctx context.Context, config commonIL.InterLinkConfig, pod v1.Pod,
 
 			switch mount := data.(type) {
 			case v1.ConfigMap:
-				var configMapNamePaths []string
+				var configMapNamePaths []containerruntime.MountSpec
 				err := os.RemoveAll(config.DataRootFolder + pod.Namespace + "-" + string(pod.UID) + "/" + "configMaps/" + vol.Name)
 
 				if err != nil {
@@ -189,20 +191,12 @@ func mountData(Ctx context.Context, config commonIL.InterLinkConfig, pod v1.Pod,
 					if mount.Data != nil {
 						for key := range mount.Data {
 							path := filepath.Join(podConfigMapDir, key)
-							path += (":" + correctMountPath + "/" + key + " ")
-							configMapNamePaths = append(configMapNamePaths, path)
+							configMapNamePaths = append(configMapNamePaths, containerruntime.MountSpec{Source: path, Target: correctMountPath + "/" + key})
 						}
 					}
 
-					cmd := []string{"-p " + podConfigMapDir}
-					shell := exec2.ExecTask{
-						Command: "mkdir",
-						Args:    cmd,
-						Shell:   true,
-					}
-
-					execReturn, _ := shell.Execute()
-					if execReturn.Stderr != "" {
+					err = os.MkdirAll(podConfigMapDir, os.ModePerm)
+					if err != nil {
 						return nil, err
 					}
 
@@ -219,7 +213,7 @@ func mountData(Ctx context.Context, config commonIL.InterLinkConfig, pod v1.Pod,
 				}
 
 			case v1.Secret:
-				var secretNamePaths []string
+				var secretNamePaths []containerruntime.MountSpec
 				err := os.RemoveAll(config.DataRootFolder + pod.Namespace + "-" + string(pod.UID) + "/" + "secrets/" + vol.Name)
 
 				if err != nil {
@@ -232,25 +226,14 @@ func mountData(Ctx context.Context, config commonIL.InterLinkConfig, pod v1.Pod,
 					if mount.Data != nil {
 						for key := range mount.Data {
 							path := filepath.Join(podSecretDir, key)
-							path += (":" + mountSpec.MountPath + "/" + key + " ")
-							secretNamePaths = append(secretNamePaths, path)
+							secretNamePaths = append(secretNamePaths, containerruntime.MountSpec{Source: path, Target: mountSpec.MountPath + "/" + key})
 						}
 					}
 
-					cmd := []string{"-p " + podSecretDir}
-					shell := exec2.ExecTask{
-						Command: "mkdir",
-						Args:    cmd,
-						Shell:   true,
-					}
-
-					execReturn, _ := shell.Execute()
-					if strings.Compare(execReturn.Stdout, "") != 0 {
+					err = os.MkdirAll(podSecretDir, os.ModePerm)
+					if err != nil {
 						return nil, err
 					}
-					if execReturn.Stderr != "" {
-						return nil, errors.New(execReturn.Stderr)
-					}
 
 					for k, v := range mount.Data {
 						// TODO: Ensure that these files are deleted in failure cases
@@ -293,29 +276,19 @@ func mountData(Ctx context.Context, config commonIL.InterLinkConfig, pod v1.Pod,
 					}
 
 					edPath = filepath.Join(wd + "/" + config.DataRootFolder + pod.Namespace + "-" + string(pod.UID) + "/" + "emptyDirs/" + vol.Name)
-					cmd := []string{"-p " + edPath}
-					shell := exec2.ExecTask{
-						Command: "mkdir",
-						Args:    cmd,
-						Shell:   true,
-					}
-
-					_, err := shell.Execute()
+					err := os.MkdirAll(edPath, os.ModePerm)
 					if err != nil {
-						return []string{""}, nil
+						return nil, err
 					}
 
+					edMount := containerruntime.MountSpec{Source: edPath, Target: emptyDirMountPath + "/"}
 					if isReadOnly {
-						edPath += (":" + emptyDirMountPath + "/:ro")
-					} else {
-						if isBidirectional {
-							edPath += (":" + emptyDirMountPath + "/:shared")
-						} else {
-							edPath += (":" + emptyDirMountPath + "/")
-						}
+						edMount.ReadOnly = true
+					} else if isBidirectional {
+						edMount.Propagation = "shared"
 					}
 
-					return []string{edPath}, nil
+					return []containerruntime.MountSpec{edMount}, nil
 				}
 			}
 		}
@@ -2,14 +2,13 @@ package gpustrategies
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"strconv"
 	"strings"
 
 	"github.com/NVIDIA/go-nvml/pkg/nvml"
 	"github.com/containerd/log"
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/acceleratorledger"
 
 	//"github.com/containerd/containerd/log"
 	"github.com/docker/docker/api/types/container"
@@ -32,6 +31,13 @@ type GPUManager struct {
 	GPUSpecsMutex sync.Mutex // Mutex to make GPUSpecsList access atomic
 	Vendor        string
 	Ctx           context.Context
+	// Ledger persists the GPU allocations, it is written on every assignment and release
+	Ledger acceleratorledger.Ledger
+	// IsContainerLive is used by Check to release the GPUs assigned to the containers of deleted PODs
+	IsContainerLive acceleratorledger.LivenessCheck
+
+	// ledgerSpecs are the allocations loaded from the Ledger at Init, applied to the GPUs found by Discover
+	ledgerSpecs []GPUSpecs
 }
 
 type GPUManagerInterface interface {
@@ -49,6 +55,17 @@ type GPUManagerInterface interface {
 
 func (a *GPUManager) Init() error {
 
+	if a.Ledger.Path == "" {
+		a.Ledger.Path = "gpu_specs.json"
+	}
+
+	found, err := a.Ledger.Load(&a.ledgerSpecs)
+	if err != nil {
+		log.G(a.Ctx).Error(fmt.Sprintf("\u274C Unable to load the GPU allocations from %s: %v", a.Ledger.Path, err))
+	} else if found {
+		log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Loaded the GPU allocations from %s", a.Ledger.Path))
+	}
+
 	ret := nvml.Init()
 	if ret != nvml.SUCCESS {
 		return fmt.Errorf("Unable to initialize NVML")
@@ -86,8 +103,18 @@ func (a *GPUManager) Discover() error {
 			return fmt.Errorf("Unable to get index of device at index %d: %v", i, nvml.ErrorString(ret))
 		}
 
+		gpuSpec := GPUSpecs{Name: name, UUID: uuid, Type: "NVIDIA", ContainerID: "", Available: true, Index: index}
+
+		// restore the allocation found in the ledger, Check will release it if the container does not exist anymore
+		for _, ledgerSpec := range a.ledgerSpecs {
+			if ledgerSpec.UUID == uuid && !ledgerSpec.Available {
+				gpuSpec.ContainerID = ledgerSpec.ContainerID
+				gpuSpec.Available = false
+			}
+		}
+
 		// Add the GPU to the GPUSpecsList
-		a.GPUSpecsList = append(a.GPUSpecsList, GPUSpecs{Name: name, UUID: uuid, Type: "NVIDIA", ContainerID: "", Available: true, Index: index})
+		a.GPUSpecsList = append(a.GPUSpecsList, gpuSpec)
 	}
 
 	// print the GPUSpecsList if the length is greater than 0
@@ -103,8 +130,17 @@ func (a *GPUManager) Discover() error {
 	return nil
 }
 
+// Check reconciles the GPU allocations restored from the ledger with the live PODs, then marks as unavailable the GPUs used by the running containers of the host
 func (a *GPUManager) Check() error {
 
+	a.GPUSpecsMutex.Lock()
+	defer a.GPUSpecsMutex.Unlock()
+
+	err := a.releaseDeadAllocations()
+	if err != nil {
+		return err
+	}
+
 	//cli, err := client.NewEnvClient()
 	cli, err := client.NewClientWithOpts(client.WithVersion("1.44"))
 	if err != nil {
@@ -132,11 +168,11 @@ func (a *GPUManager) Check() error {
 				for _, gpuID := range gpuIDsSplitted {
 					gpuIndex, err := strconv.Atoi(gpuID)
 					if err != nil {
-						fmt.Errorf("unable to convert GPU ID to int: %v", err)
+						log.G(a.Ctx).Error("\u274C Unable to convert GPU ID " + gpuID + " of container " + containerInfo.Name + " to int: " + err.Error())
 						continue
 					}
 					for i := range a.GPUSpecsList {
-						if a.GPUSpecsList[i].Index == gpuIndex {
+						if a.GPUSpecsList[i].Index == gpuIndex && a.GPUSpecsList[i].Available {
 							a.GPUSpecsList[i].ContainerID = containerInfo.ID
 							a.GPUSpecsList[i].Available = false
 						}
@@ -158,6 +194,30 @@ func (a *GPUManager) Check() error {
 	return nil
 }
 
+// releaseDeadAllocations releases the GPUs assigned to the containers whose POD does not exist anymore and saves the ledger, it must be called with GPUSpecsMutex held
+func (a *GPUManager) releaseDeadAllocations() error {
+	if a.IsContainerLive != nil {
+		for i := range a.GPUSpecsList {
+			if a.GPUSpecsList[i].Available {
+				continue
+			}
+			live, err := a.IsContainerLive(a.GPUSpecsList[i].ContainerID)
+			if err != nil {
+				// in doubt, the GPU is kept assigned
+				log.G(a.Ctx).Error(fmt.Sprintf("\u274C Unable to check container %s: %v", a.GPUSpecsList[i].ContainerID, err))
+				continue
+			}
+			if !live {
+				log.G(a.Ctx).Info(fmt.Sprintf("\u2705 Releasing GPU with UUID %s, its container %s does not exist anymore", a.GPUSpecsList[i].UUID, a.GPUSpecsList[i].ContainerID))
+				a.GPUSpecsList[i].ContainerID = ""
+				a.GPUSpecsList[i].Available = true
+			}
+		}
+	}
+
+	return a.Ledger.Save(a.GPUSpecsList)
+}
+
 func (a *GPUManager) Shutdown() error {
 
 	ret := nvml.Shutdown()
@@ -168,11 +228,23 @@ func (a *GPUManager) Shutdown() error {
 	return nil
 }
 
+// GetGPUSpecsList returns a copy of the GPUSpecsList, which can be read while the GPUs are assigned and released
 func (a *GPUManager) GetGPUSpecsList() []GPUSpecs {
-	return a.GPUSpecsList
+	a.GPUSpecsMutex.Lock()
+	defer a.GPUSpecsMutex.Unlock()
+
+	return append([]GPUSpecs{}, a.GPUSpecsList...)
 }
 
 func (a *GPUManager) Assign(UUID string, containerID string) error {
+	a.GPUSpecsMutex.Lock()
+	defer a.GPUSpecsMutex.Unlock()
+
+	return a.assign(UUID, containerID)
+}
+
+// assign must be called with GPUSpecsMutex held
+func (a *GPUManager) assign(UUID string, containerID string) error {
 
 	for i := range a.GPUSpecsList {
 		if a.GPUSpecsList[i].UUID == UUID {
@@ -207,10 +279,19 @@ func (a *GPUManager) Release(containerID string) error {
 		}
 	}
 
-	return nil
+	return a.Ledger.Save(a.GPUSpecsList)
 }
 
+// GetAvailableGPUs returns numGPUs available GPUs without assigning them, e.g. to plan the creation of a POD
 func (a *GPUManager) GetAvailableGPUs(numGPUs int) ([]GPUSpecs, error) {
+	a.GPUSpecsMutex.Lock()
+	defer a.GPUSpecsMutex.Unlock()
+
+	return a.getAvailableGPUs(numGPUs)
+}
+
+// getAvailableGPUs must be called with GPUSpecsMutex held
+func (a *GPUManager) getAvailableGPUs(numGPUs int) ([]GPUSpecs, error) {
 
 	var availableGPUs []GPUSpecs
 	for _, gpuSpec := range a.GPUSpecsList {
@@ -229,35 +310,30 @@ func (a *GPUManager) GetAndAssignAvailableGPUs(numGPUs int, containerID string)
 	a.GPUSpecsMutex.Lock()
 	defer a.GPUSpecsMutex.Unlock()
 
-	gpuSpecs, err := a.GetAvailableGPUs(numGPUs)
+	gpuSpecs, err := a.getAvailableGPUs(numGPUs)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, gpuSpec := range gpuSpecs {
-		err = a.Assign(gpuSpec.UUID, containerID)
+		err = a.assign(gpuSpec.UUID, containerID)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	err = a.Ledger.Save(a.GPUSpecsList)
+	if err != nil {
+		return nil, err
+	}
+
 	return gpuSpecs, nil
 }
 
-// dump the GPUSpecsList into a JSON file
+// dump the GPUSpecsList into the JSON file of the ledger
 func (a *GPUManager) Dump() error {
+	a.GPUSpecsMutex.Lock()
+	defer a.GPUSpecsMutex.Unlock()
 
-	// Convert the array to JSON format
-	jsonData, err := json.MarshalIndent(a.GPUSpecsList, "", "  ")
-	if err != nil {
-		return fmt.Errorf("Error marshalling JSON: %v", err)
-	}
-
-	// Write JSON data to a file
-	err = ioutil.WriteFile("gpu_specs.json", jsonData, 0644)
-	if err != nil {
-		return fmt.Errorf("Error writing to file: %v", err)
-	}
-
-	return nil
+	return a.Ledger.Save(a.GPUSpecsList)
 }
@@ -0,0 +1,126 @@
+package gpustrategies
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/acceleratorledger"
+)
+
+func TestReleaseDeadAllocations(t *testing.T) {
+	ledger := acceleratorledger.Ledger{Path: filepath.Join(t.TempDir(), "gpu_specs.json")}
+	manager := &GPUManager{
+		Ctx:    context.Background(),
+		Ledger: ledger,
+		GPUSpecsList: []GPUSpecs{
+			{UUID: "GPU-0", ContainerID: "live-container", Index: 0},
+			{UUID: "GPU-1", ContainerID: "dead-container", Index: 1},
+			{UUID: "GPU-2", ContainerID: "unknown-container", Index: 2},
+			{UUID: "GPU-3", Available: true, Index: 3},
+		},
+		IsContainerLive: func(containerName string) (bool, error) {
+			switch containerName {
+			case "live-container":
+				return true, nil
+			case "dead-container":
+				return false, nil
+			}
+			return false, errors.New("runtime unreachable")
+		},
+	}
+
+	if err := manager.releaseDeadAllocations(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []GPUSpecs{
+		{UUID: "GPU-0", ContainerID: "live-container", Index: 0},
+		{UUID: "GPU-1", Available: true, Index: 1},
+		// in doubt, the GPU is kept assigned
+		{UUID: "GPU-2", ContainerID: "unknown-container", Index: 2},
+		{UUID: "GPU-3", Available: true, Index: 3},
+	}
+	if !reflect.DeepEqual(manager.GPUSpecsList, want) {
+		t.Fatalf("got %+v, want %+v", manager.GPUSpecsList, want)
+	}
+
+	var saved []GPUSpecs
+	if _, err := ledger.Load(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, want) {
+		t.Fatalf("ledger: got %+v, want %+v", saved, want)
+	}
+}
+
+func TestAssignAndRelease(t *testing.T) {
+	ledger := acceleratorledger.Ledger{Path: filepath.Join(t.TempDir(), "gpu_specs.json")}
+	manager := &GPUManager{
+		Ctx:          context.Background(),
+		Ledger:       ledger,
+		GPUSpecsList: []GPUSpecs{{UUID: "GPU-0", Available: true}, {UUID: "GPU-1", Available: true}},
+	}
+
+	gpuSpecs, err := manager.GetAndAssignAvailableGPUs(2, "container")
+	if err != nil || len(gpuSpecs) != 2 {
+		t.Fatalf("got %+v, %v, want 2 GPUs", gpuSpecs, err)
+	}
+	if _, err := manager.GetAvailableGPUs(1); err == nil {
+		t.Fatal("got a GPU while all of them are assigned")
+	}
+
+	var saved []GPUSpecs
+	if _, err := ledger.Load(&saved); err != nil {
+		t.Fatal(err)
+	}
+	for _, gpuSpec := range saved {
+		if gpuSpec.Available || gpuSpec.ContainerID != "container" {
+			t.Fatalf("ledger after the assignment: got %+v", saved)
+		}
+	}
+
+	if err := manager.Release("container"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ledger.Load(&saved); err != nil {
+		t.Fatal(err)
+	}
+	for _, gpuSpec := range saved {
+		if !gpuSpec.Available || gpuSpec.ContainerID != "" {
+			t.Fatalf("ledger after the release: got %+v", saved)
+		}
+	}
+}
+
+// TestConcurrentAccess is meant to be run with -race: the PODs are planned and created concurrently by the create workers
+func TestConcurrentAccess(t *testing.T) {
+	manager := &GPUManager{
+		Ctx:          context.Background(),
+		Ledger:       acceleratorledger.Ledger{Path: filepath.Join(t.TempDir(), "gpu_specs.json")},
+		GPUSpecsList: []GPUSpecs{{UUID: "GPU-0", Available: true}, {UUID: "GPU-1", Available: true}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			manager.GetAvailableGPUs(1)
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := manager.GetAndAssignAvailableGPUs(1, "container"); err == nil {
+				manager.Release("container")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			manager.GetGPUSpecsList()
+		}()
+	}
+	wg.Wait()
+}
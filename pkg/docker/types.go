@@ -1,13 +1,27 @@
 package docker
 
+import "github.com/intertwin-eu/interlink-docker-plugin/pkg/docker/containerruntime"
+
 type DockerRunStruct struct {
-	Name            string `json:"name"`
-	Command         string `json:"command"`
-	IsInitContainer bool   `json:"isInitContainer"`
-	GpuArgs         string `json:"gpuArgs"`
-	FpgaArgs        string `json:"fpgaArgs"`
+	Name            string                         `json:"name"`
+	Spec            containerruntime.ContainerSpec `json:"spec"`
+	IsInitContainer bool                           `json:"isInitContainer"`
+	IsSidecar       bool                           `json:"isSidecar"`
+	GpuArgs         string                         `json:"gpuArgs"`
+	FpgaArgs        string                         `json:"fpgaArgs"`
 }
 type CreateStruct struct {
 	PodUID string `json:"PodUID"`
 	PodJID string `json:"PodJID"`
 }
+
+// PlanStruct is the execution plan of a POD, returned by a dry run of its creation
+type PlanStruct struct {
+	PodUID       string            `json:"PodUID"`
+	PodName      string            `json:"PodName"`
+	PodNamespace string            `json:"PodNamespace"`
+	Containers   []DockerRunStruct `json:"containers"`
+	// Scripts holds the content of the files generated from the commands and arguments of the containers, by path
+	Scripts map[string]string `json:"scripts,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}